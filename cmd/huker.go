@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/openinx/huker/pkg"
 	huker "github.com/openinx/huker/pkg/core"
@@ -10,12 +11,46 @@ import (
 	"github.com/openinx/huker/pkg/utils"
 	"github.com/qiniu/log"
 	"os"
+	"os/user"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// Options to operate the tasks of job, which override the ones in huker.yaml if positive.
+var (
+	parallel    int
+	taskTimeout time.Duration
+	// Break the locks of jobs held by others.
+	forceUnlock bool
+)
+
+// The operations lock the jobs, and are recorded into the audit log.
+func newHukerJob() (huker.HukerJob, error) {
+	h, err := huker.NewDefaultHukerJob()
+	if err != nil {
+		return nil, err
+	}
+	h.SetParallel(parallel)
+	h.SetTaskTimeout(taskTimeout)
+	operator := currentOperator()
+	locked := huker.NewLockedHukerJob(h, huker.NewDefaultLockManager(), operator+" ("+huker.OriginCLI+")", forceUnlock)
+	return huker.NewAuditedHukerJob(locked, huker.NewDefaultAuditLog(), huker.OriginCLI, operator), nil
+}
+
+// Operator of the CLI, such as <user>@<hostname>
+func currentOperator() string {
+	name := os.Getenv("USER")
+	if usr, err := user.Current(); err == nil {
+		name = usr.Username
+	}
+	hostname, _ := os.Hostname()
+	return name + "@" + hostname
+}
+
 func logConsole(action string, job string, results []huker.TaskResult) {
 	if results != nil {
 		for i := range results {
@@ -33,7 +68,7 @@ func logConsole(action string, job string, results []huker.TaskResult) {
 }
 
 func handleClusterAction(action string, project, cluster, job string, taskId int, extraArgs []string) error {
-	h, err := huker.NewDefaultHukerJob()
+	h, err := newHukerJob()
 	if err != nil {
 		return err
 	}
@@ -52,10 +87,12 @@ func handleClusterAction(action string, project, cluster, job string, taskId int
 		results, err = h.Show(project, cluster, job, taskId)
 	case "restart":
 		results, err = h.Restart(project, cluster, job, taskId)
-	case "rolling_update":
-		results, err = h.RollingUpdate(project, cluster, job, taskId)
 	case "cleanup":
 		results, err = h.Cleanup(project, cluster, job, taskId)
+	case "decommission":
+		results, err = h.Decommission(project, cluster, job, taskId)
+	case "recommission":
+		results, err = h.Recommission(project, cluster, job, taskId)
 	case "shell":
 		return h.Shell(project, cluster, job, extraArgs)
 	default:
@@ -69,21 +106,452 @@ func handleClusterAction(action string, project, cluster, job string, taskId int
 	}
 }
 
+// Validate all cluster definitions under conf/, exit non-zero if any error found.
+func handleValidate() {
+	h, err := huker.NewDefaultHukerJob()
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	errs, err := h.Validate()
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	for _, e := range errs {
+		fmt.Println(e.Error())
+	}
+	if len(errs) > 0 {
+		fmt.Printf("Validate failed, %d error(s) found.\n", len(errs))
+		os.Exit(1)
+	}
+	fmt.Println("Validate success, no error found.")
+}
+
+// Print the programs of tasks which will be sent to the agents, without contacting any agent.
+func handleRender(args []string) {
+	asJSON := false
+	agentRootDir, _ := filepath.Abs(".")
+	index := 0
+	for ; index < len(args) && strings.HasPrefix(args[index], "-"); index++ {
+		if args[index] == "--json" {
+			asJSON = true
+		} else if args[index] == "--agent-root-dir" && index+1 < len(args) {
+			index++
+			agentRootDir = args[index]
+		} else {
+			fmt.Fprintf(os.Stderr, "Unexpected arguments: %v\n", args[index:])
+			os.Exit(1)
+		}
+	}
+	args = args[index:]
+	if len(args) < 3 || len(args) > 4 {
+		fmt.Println("Command render: not enough arguments")
+		fmt.Println("Usage: render [--json] [--agent-root-dir <dir>] <project> <cluster> <job> [<task_id>]")
+		os.Exit(1)
+	}
+	taskId := -1
+	if len(args) == 4 {
+		var err error
+		if taskId, err = strconv.Atoi(args[3]); err != nil {
+			fmt.Fprintf(os.Stderr, "<task_id> shoud be int, instead of %s\n", args[3])
+			os.Exit(1)
+		}
+	}
+
+	h, err := huker.NewDefaultHukerJob()
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	results, err := h.Render(args[0], args[1], args[2], taskId, agentRootDir)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	if len(results) == 0 {
+		log.Warnf("render job %s -> No task found.", args[2])
+	}
+	if asJSON {
+		progs := []*supervisor.Program{}
+		for _, result := range results {
+			progs = append(progs, result.Prog)
+		}
+		data, err := json.MarshalIndent(progs, "", "  ")
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+		return
+	}
+	for _, result := range results {
+		prog := result.Prog
+		fmt.Printf("==> %s/%s.%d at %s\n", prog.Name, prog.Job, prog.TaskId, result.Host.ToKey())
+		fmt.Printf("Root dir: %s\n", prog.RootDir)
+		fmt.Printf("Package : %s (md5sum: %s)\n", prog.PkgAddress, prog.PkgMD5Sum)
+		fmt.Printf("Command : %s %s\n", prog.Bin, strings.Join(prog.Args, " "))
+		var envs []string
+		for key, value := range prog.Env {
+			envs = append(envs, key+"="+value)
+		}
+		sort.Strings(envs)
+		fmt.Printf("Env     : %s\n", strings.Join(envs, " "))
+		var fnames []string
+		for fname := range prog.Configs {
+			fnames = append(fnames, fname)
+		}
+		sort.Strings(fnames)
+		for _, fname := range fnames {
+			fmt.Printf("--- %s\n%s\n", fname, prog.Configs[fname])
+		}
+		fmt.Println()
+	}
+}
+
+// Bootstrap, start or stop the cluster with all its dependencies in order, exit non-zero if failed.
+func handleClusterWide(command string, args []string) {
+	if len(args) != 2 {
+		fmt.Printf("Command %s: not enough arguments\n", command)
+		fmt.Printf("Usage: %s <project> <cluster>\n", command)
+		os.Exit(1)
+	}
+	h, err := newHukerJob()
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	var jobResults []huker.JobResult
+	switch command {
+	case "bootstrap-cluster":
+		jobResults, err = h.BootstrapCluster(args[0], args[1])
+	case "start-cluster":
+		jobResults, err = h.StartCluster(args[0], args[1])
+	case "stop-cluster":
+		jobResults, err = h.StopCluster(args[0], args[1])
+	}
+	action := strings.TrimSuffix(command, "-cluster")
+	for _, jobResult := range jobResults {
+		logConsole(action, jobResult.Cluster+"/"+jobResult.Job, jobResult.Results)
+	}
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+}
+
+// Rolling update the job batch by batch, exit non-zero if any task is not updated.
+func handleRollingUpdate(args []string) {
+	opts := huker.RollingUpdateOptions{}
+	index := 0
+	for ; index < len(args) && strings.HasPrefix(args[index], "-"); index++ {
+		if args[index] == "--resume" {
+			opts.Resume = true
+			continue
+		}
+		if index+1 >= len(args) {
+			fmt.Fprintf(os.Stderr, "Unexpected arguments: %v\n", args[index:])
+			os.Exit(1)
+		}
+		var err error
+		switch args[index] {
+		case "--batch-size":
+			opts.BatchSize, err = strconv.Atoi(args[index+1])
+		case "--max-unavailable":
+			opts.MaxUnavailable, err = strconv.Atoi(args[index+1])
+		case "--failure-threshold":
+			opts.FailureThreshold, err = strconv.Atoi(args[index+1])
+		case "--wait-healthy":
+			opts.WaitHealthy, err = time.ParseDuration(args[index+1])
+		default:
+			fmt.Fprintf(os.Stderr, "Unexpected arguments: %v\n", args[index:])
+			os.Exit(1)
+		}
+		if err != nil || opts.BatchSize < 0 || opts.MaxUnavailable < 0 || opts.FailureThreshold < 0 || opts.WaitHealthy < 0 {
+			fmt.Fprintf(os.Stderr, "Invalid value of %s: %s\n", args[index], args[index+1])
+			os.Exit(1)
+		}
+		index++
+	}
+	args = args[index:]
+	if len(args) < 3 || len(args) > 4 {
+		fmt.Println("Command rolling_update: not enough arguments")
+		fmt.Println("Usage: rolling_update [--batch-size N] [--max-unavailable N] [--failure-threshold N] " +
+			"[--wait-healthy DURATION] [--resume] <project> <cluster> <job> [<task_id>]")
+		os.Exit(1)
+	}
+	taskId := -1
+	if len(args) == 4 {
+		var err error
+		if taskId, err = strconv.Atoi(args[3]); err != nil {
+			fmt.Fprintf(os.Stderr, "<task_id> shoud be int, instead of %s\n", args[3])
+			os.Exit(1)
+		}
+	}
+
+	h, err := newHukerJob()
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	results, err := h.RollingUpdate(args[0], args[1], args[2], taskId, opts)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	logConsole("rolling_update", args[2], results)
+	for _, result := range results {
+		if result.Err != nil {
+			os.Exit(1)
+		}
+	}
+}
+
+// Update the canary tasks of job first and promote to the rest after the soak, exit non-zero if any task is not
+// updated. The options can be given before or after the job.
+func handleCanary(args []string) {
+	opts := huker.CanaryOptions{}
+	var positional []string
+	for index := 0; index < len(args); index++ {
+		if !strings.HasPrefix(args[index], "-") {
+			positional = append(positional, args[index])
+			continue
+		}
+		if index+1 >= len(args) {
+			fmt.Fprintf(os.Stderr, "Unexpected arguments: %v\n", args[index:])
+			os.Exit(1)
+		}
+		var err error
+		switch args[index] {
+		case "--tasks":
+			opts.Tasks, err = strconv.Atoi(args[index+1])
+		case "--soak":
+			opts.Soak, err = time.ParseDuration(args[index+1])
+		case "--max-crashes":
+			opts.MaxCrashes, err = strconv.Atoi(args[index+1])
+		default:
+			fmt.Fprintf(os.Stderr, "Unexpected arguments: %v\n", args[index:])
+			os.Exit(1)
+		}
+		if err != nil || opts.Tasks < 0 || opts.Soak < 0 || opts.MaxCrashes < 0 {
+			fmt.Fprintf(os.Stderr, "Invalid value of %s: %s\n", args[index], args[index+1])
+			os.Exit(1)
+		}
+		index++
+	}
+	if len(positional) != 3 {
+		fmt.Println("Command canary: not enough arguments")
+		fmt.Println("Usage: canary <project> <cluster> <job> [--tasks N] [--soak DURATION] [--max-crashes N]")
+		os.Exit(1)
+	}
+
+	h, err := newHukerJob()
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	results, err := h.Canary(positional[0], positional[1], positional[2], opts)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	logConsole("canary", positional[2], results)
+	for _, result := range results {
+		if result.Err != nil {
+			os.Exit(1)
+		}
+	}
+}
+
+// Print the recorded operations of CLI and dashboard, the latest ones first.
+func handleHistory(args []string) {
+	filter := huker.AuditFilter{Limit: 20}
+	asJSON := false
+	index := 0
+	for ; index < len(args) && strings.HasPrefix(args[index], "-"); index++ {
+		if args[index] == "--json" {
+			asJSON = true
+			continue
+		}
+		if index+1 >= len(args) {
+			fmt.Fprintf(os.Stderr, "Unexpected arguments: %v\n", args[index:])
+			os.Exit(1)
+		}
+		var err error
+		switch args[index] {
+		case "--action":
+			filter.Action = args[index+1]
+		case "--operator":
+			filter.Operator = args[index+1]
+		case "--since":
+			var d time.Duration
+			d, err = time.ParseDuration(args[index+1])
+			filter.Since = time.Now().Add(-d)
+		case "--limit":
+			filter.Limit, err = strconv.Atoi(args[index+1])
+		default:
+			fmt.Fprintf(os.Stderr, "Unexpected arguments: %v\n", args[index:])
+			os.Exit(1)
+		}
+		if err != nil || filter.Limit < 0 {
+			fmt.Fprintf(os.Stderr, "Invalid value of %s: %s\n", args[index], args[index+1])
+			os.Exit(1)
+		}
+		index++
+	}
+	args = args[index:]
+	if len(args) > 3 {
+		fmt.Println("Command history: too many arguments")
+		fmt.Println("Usage: history [--action ACTION] [--operator OPERATOR] [--since DURATION] [--limit N] [--json] " +
+			"[<project> [<cluster> [<job>]]]")
+		os.Exit(1)
+	}
+	for i, field := range []*string{&filter.Project, &filter.Cluster, &filter.Job} {
+		if i < len(args) {
+			*field = args[i]
+		}
+	}
+
+	records, err := huker.NewDefaultAuditLog().Query(filter)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	if asJSON {
+		if records == nil {
+			records = []*huker.AuditRecord{}
+		}
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+		return
+	}
+	for _, r := range records {
+		result := "Success"
+		if !r.Success() {
+			result = fmt.Sprintf("Failed (%d/%d tasks failed)", r.FailedTasks(), len(r.Tasks))
+		}
+		fmt.Printf("%s  %s  %s  %s %s  %v  %s\n", r.Time.Format("2006-01-02 15:04:05"), r.Operator, r.Origin,
+			r.Action, r.Target(), r.Duration.Round(time.Millisecond), result)
+		if r.PkgMD5Sum != "" {
+			fmt.Printf("    package md5sum: %s\n", r.PkgMD5Sum)
+		}
+		if r.Err != "" {
+			fmt.Printf("    %s\n", r.Err)
+		}
+		for _, t := range r.Tasks {
+			if t.Err != "" {
+				fmt.Printf("    %s/%s.%d at %s: %s\n", t.Cluster, t.Job, t.TaskId, t.Host, t.Err)
+			}
+		}
+	}
+}
+
+// Print the differences between the desired programs and the deployed ones, exit non-zero if any task needs a
+// rolling_update or fails to compare.
+func handleDiff(args []string) {
+	if len(args) < 3 || len(args) > 4 {
+		fmt.Println("Command diff: not enough arguments")
+		fmt.Println("Usage: diff <project> <cluster> <job> [<task_id>]")
+		os.Exit(1)
+	}
+	taskId := -1
+	if len(args) == 4 {
+		var err error
+		if taskId, err = strconv.Atoi(args[3]); err != nil {
+			fmt.Fprintf(os.Stderr, "<task_id> shoud be int, instead of %s\n", args[3])
+			os.Exit(1)
+		}
+	}
+
+	h, err := newHukerJob()
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	taskDiffs, err := h.Diff(args[0], args[1], args[2], taskId)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	if len(taskDiffs) == 0 {
+		log.Warnf("diff job %s -> No task found.", args[2])
+	}
+	changed := 0
+	for _, taskDiff := range taskDiffs {
+		if taskDiff.Err != nil {
+			fmt.Printf("==> %s/%s.%d at %s: Failed, %v\n", args[1], args[2], taskDiff.Host.TaskId, taskDiff.Host.ToKey(), taskDiff.Err)
+			changed++
+			continue
+		}
+		if !taskDiff.NeedRollingUpdate() {
+			fmt.Printf("==> %s/%s.%d at %s: Up to date\n", args[1], args[2], taskDiff.Host.TaskId, taskDiff.Host.ToKey())
+			continue
+		}
+		changed++
+		fmt.Printf("==> %s/%s.%d at %s: Need rolling_update\n", args[1], args[2], taskDiff.Host.TaskId, taskDiff.Host.ToKey())
+		if taskDiff.Package != "" {
+			fmt.Printf("Package : %s\n", taskDiff.Package)
+		}
+		fmt.Print(taskDiff.Command)
+		fmt.Print(taskDiff.Env)
+		for _, fname := range taskDiff.ConfigNames() {
+			fmt.Print(taskDiff.Configs[fname])
+		}
+		fmt.Println()
+	}
+	if changed > 0 {
+		os.Exit(1)
+	}
+}
+
 func printUsageAndExit() {
 	fmt.Println("Usage: huker [<options> <command> <args>]")
 	fmt.Println("Options: ")
 	fmt.Println("  --log-level INFO|DEBUG|WARN|ERROR   Log level when execute the command")
 	fmt.Println("  --log-file  FILE                    File to write the log.")
+	fmt.Println("  --parallel  N                       Max number of tasks to operate concurrently (default: 10)")
+	fmt.Println("  --task-timeout DURATION             Timeout for operating a single task, such as 30s, 5m (default: 10m)")
+	fmt.Println("  --force-unlock                      Break the locks of jobs held by others, if the holder is gone")
 	fmt.Println("Commands: ")
 	fmt.Println("Some commands take arguments, Pass no args for usage.")
 	fmt.Println("  shell               Run the shell for specified job")
 	fmt.Println("  bootstrap           Bootstrap the job to install packages and start the job")
+	fmt.Println("  install             Install the packages and configuration files of job without starting it")
 	fmt.Println("  show                Show the job status")
 	fmt.Println("  cleanup             Cleanup the packages")
-	fmt.Println("  rolling_update      Rolling update the configuration files and packages for job")
+	fmt.Println("  rolling_update      Rolling update the configuration files and packages for job batch by batch")
+	fmt.Println("    --batch-size        Number of tasks to update at the same time (default: 1)")
+	fmt.Println("    --max-unavailable   Max number of unavailable tasks during the rollout (default: batch size)")
+	fmt.Println("    --failure-threshold Stop the rollout once so many tasks failed (default: 1)")
+	fmt.Println("    --wait-healthy      Max time to wait for the batch to be healthy, such as 60s (default: 60s)")
+	fmt.Println("    --resume            Skip the tasks completed by the previous rollout to the same programs")
+	fmt.Println("  canary              Rolling update a few canary tasks, and promote to the rest if they survive the soak")
+	fmt.Println("    --tasks             Number of canary tasks (default: 1)")
+	fmt.Println("    --soak              Time to watch the canary tasks before promoting, such as 10m (default: 10m)")
+	fmt.Println("    --max-crashes       Roll back once a canary task crashes more than so many times (default: 0)")
 	fmt.Println("  restart             Restart the job")
 	fmt.Println("  start               Start the job")
 	fmt.Println("  stop                Stop the job")
+	fmt.Println("  decommission        Decommission the job by its decommission procedure, then stop it")
+	fmt.Println("  recommission        Remove the decommissioned job from the exclude file, then start it")
+	fmt.Println("  bootstrap-cluster   Bootstrap the cluster with its dependencies in order of dependencies and depends_on")
+	fmt.Println("  start-cluster       Start the cluster with its dependencies in order of dependencies and depends_on")
+	fmt.Println("  stop-cluster        Stop the cluster with its dependencies in the reverse order of start-cluster")
+	fmt.Println("  history             Print the operations of CLI and dashboard recorded in the audit log")
+	fmt.Println("    --action          Only the operations of the action, such as restart")
+	fmt.Println("    --operator        Only the operations of the operator, such as <user>@<hostname>")
+	fmt.Println("    --since           Only the operations in the duration, such as 24h")
+	fmt.Println("    --limit           Max number of operations to print (default: 20)")
+	fmt.Println("    --json            Print as json")
+	fmt.Println("  validate            Validate all cluster definitions under conf/")
+	fmt.Println("  render              Print the rendered configs and command line of the job without contacting agents")
+	fmt.Println("    --json            Print as json")
+	fmt.Println("    --agent-root-dir  Root directory of huker agent to substitute $AgentRootDir (default: .)")
+	fmt.Println("  diff                Print the differences between the rendered configs and the deployed ones on agents")
 	fmt.Println("  start-pkg-manager   Start the package manager http server")
 	fmt.Println("  start-dashboard     Start huker dashboard")
 	fmt.Println("  start-agent         Start the supervisor agent")
@@ -132,7 +600,11 @@ func main() {
 
 	index := 1
 	for ; index+1 < len(os.Args) && strings.HasPrefix(os.Args[index], "-"); index += 2 {
-		if os.Args[index] == "--log-level" {
+		if os.Args[index] == "--force-unlock" {
+			// The only option without value.
+			forceUnlock = true
+			index--
+		} else if os.Args[index] == "--log-level" {
 			switch os.Args[index+1] {
 			case "INFO":
 				log.SetOutputLevel(log.Linfo)
@@ -153,6 +625,20 @@ func main() {
 				os.Exit(1)
 			}
 			log.SetOutput(f)
+		} else if os.Args[index] == "--parallel" {
+			n, err := strconv.Atoi(os.Args[index+1])
+			if err != nil || n <= 0 {
+				fmt.Fprintf(os.Stderr, "--parallel should be positive int, not %s\n", os.Args[index+1])
+				printUsageAndExit()
+			}
+			parallel = n
+		} else if os.Args[index] == "--task-timeout" {
+			d, err := time.ParseDuration(os.Args[index+1])
+			if err != nil || d <= 0 {
+				fmt.Fprintf(os.Stderr, "--task-timeout should be positive duration, not %s\n", os.Args[index+1])
+				printUsageAndExit()
+			}
+			taskTimeout = d
 		}
 	}
 
@@ -163,13 +649,37 @@ func main() {
 
 	command := os.Args[index]
 	index++
-	for _, cmd := range []string{"shell", "bootstrap", "show", "cleanup", "rolling_update", "restart", "stop", "start"} {
+	for _, cmd := range []string{"shell", "bootstrap", "install", "show", "cleanup", "restart", "stop", "start",
+		"decommission", "recommission"} {
 		if cmd == command {
 			handleAction(command, os.Args[index:])
 			return
 		}
 	}
 
+	if command == "validate" {
+		handleValidate()
+		return
+	} else if command == "render" {
+		handleRender(os.Args[index:])
+		return
+	} else if command == "bootstrap-cluster" || command == "start-cluster" || command == "stop-cluster" {
+		handleClusterWide(command, os.Args[index:])
+		return
+	} else if command == "rolling_update" {
+		handleRollingUpdate(os.Args[index:])
+		return
+	} else if command == "history" {
+		handleHistory(os.Args[index:])
+		return
+	} else if command == "canary" {
+		handleCanary(os.Args[index:])
+		return
+	} else if command == "diff" {
+		handleDiff(os.Args[index:])
+		return
+	}
+
 	hukerDir := utils.GetHukerDir()
 	hukerYaml := path.Join(hukerDir, "conf", "huker.yaml")
 	cfg, err := pkg.NewHukerConfig(hukerYaml)
@@ -226,10 +736,13 @@ func main() {
 			return
 		}
 		dashboardPort, _ := strconv.Atoi(u.Port())
-		if dashboard, err := dash.NewDashboard(dashboardPort, path.Join(hukerDir, "conf"), cfg.Get(pkg.HukerPkgSrvHttpAddress), cfg.Get(pkg.HukerGrafanaHttpAddress)); err != nil {
+		dashboard, err := dash.NewDashboard(dashboardPort, path.Join(hukerDir, "conf"), cfg.Get(pkg.HukerPkgSrvHttpAddress), cfg.Get(pkg.HukerGrafanaHttpAddress))
+		if err != nil {
 			log.Fatal(err)
 			return
-		} else if err := dashboard.Start(); err != nil {
+		}
+		dashboard.SetAuditLog(huker.NewDefaultAuditLog())
+		if err := dashboard.Start(); err != nil {
 			log.Fatal(err)
 			return
 		}
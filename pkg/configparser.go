@@ -9,6 +9,12 @@ import (
 )
 
 const (
+	// core
+	HukerTaskParallel       = "huker.task.parallel"
+	HukerTaskTimeoutSeconds = "huker.task.timeout.seconds"
+	HukerAuditLogFile       = "huker.audit.log.file"
+	HukerLockDir            = "huker.lock.dir"
+
 	// pkgsrv
 	HukerPkgSrvHttpAddress = "huker.pkgsrv.http.address"
 
@@ -27,6 +27,8 @@ func TestHukerConfig(t *testing.T) {
 		val          interface{}
 		expectedType int
 	}{
+		{HukerTaskParallel, 10, typeInt},
+		{HukerTaskTimeoutSeconds, 600, typeInt},
 		{HukerPkgSrvHttpAddress, "http://127.0.0.1:4000", typeURL},
 		{HukerDashboardHttpAddress, "http://127.0.0.1:8001", typeURL},
 		{HukerOpenTSDBHttpAddress, "http://127.0.0.1:51001", typeURL},
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"github.com/openinx/huker/pkg"
 	"github.com/openinx/huker/pkg/supervisor"
@@ -10,12 +11,17 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Constant key and value for the environment variables.
 const (
 	defaultLocalTaskId = 0
+	defaultParallel    = 10
+	defaultTaskTimeout = 10 * time.Minute
 )
 
 type TaskResult struct {
@@ -28,35 +34,72 @@ func NewTaskResult(host *Host, prog *supervisor.Program, err error) TaskResult {
 	return TaskResult{Host: host, Prog: prog, Err: err}
 }
 
+// Results of a job operated as one step of the whole cluster.
+type JobResult struct {
+	ClusterStep
+	Results []TaskResult
+}
+
 type HukerJob interface {
 	List() ([]*Cluster, error)
+	Validate() ([]*ValidateError, error)
+	Render(project, cluster, job string, taskId int, agentRootDir string) ([]TaskResult, error)
+	Diff(project, cluster, job string, taskId int) ([]*TaskDiff, error)
 	Install(project, cluster, job string, taskId int) ([]TaskResult, error)
 	Shell(project, cluster, job string, extraArgs []string) error
 	Bootstrap(project, cluster, job string, taskId int) ([]TaskResult, error)
 	Start(project, cluster, job string, taskId int) ([]TaskResult, error)
 	Stop(project, cluster, job string, taskId int) ([]TaskResult, error)
 	Restart(project, cluster, job string, taskId int) ([]TaskResult, error)
-	RollingUpdate(project, cluster, job string, taskId int) ([]TaskResult, error)
+	RollingUpdate(project, cluster, job string, taskId int, opts RollingUpdateOptions) ([]TaskResult, error)
+	Canary(project, cluster, job string, opts CanaryOptions) ([]TaskResult, error)
+	Decommission(project, cluster, job string, taskId int) ([]TaskResult, error)
+	Recommission(project, cluster, job string, taskId int) ([]TaskResult, error)
 	Show(project, cluster, job string, taskId int) ([]TaskResult, error)
 	Cleanup(project, cluster, job string, taskId int) ([]TaskResult, error)
+	BootstrapCluster(project, cluster string) ([]JobResult, error)
+	StartCluster(project, cluster string) ([]JobResult, error)
+	StopCluster(project, cluster string) ([]JobResult, error)
+	ClusterSteps(project, cluster string) ([]ClusterStep, error)
 	ListHosts() ([]string, error)
 }
 
-func NewDefaultHukerJob() (HukerJob, error) {
+func NewDefaultHukerJob() (*ConfigFileHukerJob, error) {
 	cfg, err := pkg.NewHukerConfig(path.Join(utils.GetHukerDir(), "conf", "huker.yaml"))
 	if err != nil {
 		return nil, err
 	}
 	cfgRootDir := path.Join(utils.GetHukerDir(), "conf")
 	pkgSrvAddres := cfg.Get(pkg.HukerPkgSrvHttpAddress)
-	return NewConfigFileHukerJob(cfgRootDir, pkgSrvAddres)
+	j, err := NewConfigFileHukerJob(cfgRootDir, pkgSrvAddres)
+	if err != nil {
+		return nil, err
+	}
+	j.SetParallel(cfg.GetInt(pkg.HukerTaskParallel))
+	j.SetTaskTimeout(time.Duration(cfg.GetInt(pkg.HukerTaskTimeoutSeconds)) * time.Second)
+	return j, nil
 }
 
 type ConfigFileHukerJob struct {
 	configRootDir    string
 	pkgServerAddress string
+
+	// Max number of tasks to operate concurrently, default: 10
+	parallel int
+
+	// Timeout for operating a single task, default: 10m
+	taskTimeout time.Duration
+
+	// Directory to save the progress of rolling updates for --resume, default: <local-huker-dir>/rolling_update
+	rolloutStateDir string
+
+	// Listener of the result of every operated task once it's done, nil if not listened.
+	taskListener TaskListener
 }
 
+// Listener of the result of the operated task of cluster and job, which is called concurrently by the tasks.
+type TaskListener func(cluster, job string, result TaskResult)
+
 func NewConfigFileHukerJob(configRootDir, pkgServerAddress string) (*ConfigFileHukerJob, error) {
 	if _, err := os.Stat(configRootDir); err != nil {
 		return nil, err
@@ -64,10 +107,113 @@ func NewConfigFileHukerJob(configRootDir, pkgServerAddress string) (*ConfigFileH
 	return &ConfigFileHukerJob{
 		configRootDir:    configRootDir,
 		pkgServerAddress: pkgServerAddress,
+		parallel:         defaultParallel,
+		taskTimeout:      defaultTaskTimeout,
+		rolloutStateDir:  path.Join(utils.LocalHukerDir(), "rolling_update"),
 	}, nil
 }
 
+// Set the max number of tasks to operate concurrently, the non-positive value is ignored.
+func (j *ConfigFileHukerJob) SetParallel(parallel int) {
+	if parallel > 0 {
+		j.parallel = parallel
+	}
+}
+
+// Set the timeout for operating a single task, the non-positive value is ignored.
+func (j *ConfigFileHukerJob) SetTaskTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		j.taskTimeout = timeout
+	}
+}
+
+// Set the directory to save the progress of rolling updates, the empty value is ignored.
+func (j *ConfigFileHukerJob) SetRolloutStateDir(dir string) {
+	if dir != "" {
+		j.rolloutStateDir = dir
+	}
+}
+
+// Copy of the huker job which reports the result of every operated task to listener once it's done, such as the
+// progress of a running operation. The results of the whole operation are still returned as before.
+func (j *ConfigFileHukerJob) WithTaskListener(listener TaskListener) *ConfigFileHukerJob {
+	c := *j
+	c.taskListener = listener
+	return &c
+}
+
+func (j *ConfigFileHukerJob) notifyTask(cluster, job string, result TaskResult) {
+	if j.taskListener != nil {
+		j.taskListener(cluster, job, result)
+	}
+}
+
+// Wrap the task function of the operated job, to notify the task listener of its result once it's done.
+func (j *ConfigFileHukerJob) notified(cluster, job string,
+	task func(context.Context, *Host) TaskResult) func(context.Context, *Host) TaskResult {
+	return func(ctx context.Context, host *Host) TaskResult {
+		result := task(ctx, host)
+		j.notifyTask(cluster, job, result)
+		return result
+	}
+}
+
+// Select the hosts of job to operate in task id order, all hosts are selected if taskId is negative.
+func selectHosts(jobPtr *Job, taskId int) []*Host {
+	var hosts []*Host
+	for _, host := range jobPtr.Hosts {
+		if taskId < 0 || taskId == host.TaskId {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.SliceStable(hosts, func(i, k int) bool {
+		return hosts[i].TaskId < hosts[k].TaskId
+	})
+	return hosts
+}
+
+// Run the task function for every host concurrently, at most j.parallel ones at the same time. The results are
+// returned in the same order as hosts. The context of a task is done after j.taskTimeout, which aborts its requests
+// to the agents, and the task failed by then is reported as timeout. The slot of a task is released only when the task
+// function returns, though the agent may still complete the operation of an aborted request.
+func (j *ConfigFileHukerJob) runTasks(hosts []*Host, task func(context.Context, *Host) TaskResult) []TaskResult {
+	taskResults := make([]TaskResult, len(hosts))
+	slots := make(chan struct{}, j.parallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), j.taskTimeout)
+			defer cancel()
+			taskResults[i] = task(ctx, hosts[i])
+			if err := taskResults[i].Err; err != nil && ctx.Err() == context.DeadlineExceeded {
+				taskResults[i] = NewTaskResult(hosts[i], nil, fmt.Errorf("Timeout after %v: %v", j.taskTimeout, err))
+			}
+		}(i)
+	}
+	wg.Wait()
+	return taskResults
+}
+
 func (cfg *ConfigFileHukerJob) newCluster(project, cluster, job string) (*Cluster, error) {
+	c, err := cfg.newProjectCluster(project, cluster, job)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.Jobs[job]; !ok {
+		return nil, fmt.Errorf("Job `%s` does not exist in %s", job, c.ConfigPath)
+	}
+	return c, nil
+}
+
+// Load the cluster defined by <config-root-dir>/<project>/<cluster>.yaml, the package directories are rendered for
+// the given job.
+func (cfg *ConfigFileHukerJob) newProjectCluster(project, cluster, job string) (*Cluster, error) {
 	projectPath := path.Join(cfg.configRootDir, project)
 	if _, err := os.Stat(projectPath); err != nil {
 		return nil, fmt.Errorf("Invalid project `%s`, create configuration under %s directory please.", project, projectPath)
@@ -92,14 +238,58 @@ func (cfg *ConfigFileHukerJob) newCluster(project, cluster, job string) (*Cluste
 	if err != nil {
 		return nil, fmt.Errorf("Load service configuration failed, err: %v", err)
 	}
+	return c, nil
+}
 
-	if _, ok := c.Jobs[job]; !ok {
-		return nil, fmt.Errorf("Job `%s` does not exist in %s", job, clusterCfg)
+// Deploy the program to the task, and return the program reported by the agent after that.
+type updateFunc func(context.Context, *Job, *Host, *supervisor.SupervisorCli, *supervisor.Program) (*supervisor.Program,
+	error)
+
+// Build the program which will be sent to the supervisor agent of the host.
+func (j *ConfigFileHukerJob) newProgram(c *Cluster, jobPtr *Job, host *Host) (*supervisor.Program, error) {
+	cfgMap, err := c.RenderConfigFiles(jobPtr, host.TaskId, false)
+	if err != nil {
+		log.Errorf("Failed to render config file, project: %s, cluster:%s, job:%s, taskId:%d",
+			c.Project, c.ClusterName, jobPtr.JobName, host.TaskId)
+		return nil, err
 	}
-	return c, nil
+	env, err := c.RenderEnv(jobPtr, host.TaskId, false)
+	if err != nil {
+		return nil, err
+	}
+	probes, err := c.RenderProbes(jobPtr, host.TaskId, false)
+	if err != nil {
+		return nil, err
+	}
+	return &supervisor.Program{
+		Name:       c.ClusterName,
+		Job:        jobPtr.JobName,
+		TaskId:     host.TaskId,
+		Bin:        c.MainProcess,
+		Args:       jobPtr.toShell(host.TaskId),
+		Configs:    cfgMap,
+		PkgAddress: j.pkgServerAddress + "/" + c.PackageName,
+		PkgName:    c.PackageName,
+		PkgMD5Sum:  c.PackageMd5sum,
+		Hooks:      jobPtr.Hooks,
+		Env:        env,
+		Type:       jobPtr.ProcessType,
+		Probes:     probes,
+	}, nil
 }
 
-type updateFunc func(*Job, *Host, *supervisor.SupervisorCli, *supervisor.Program) error
+// Render the programs of all hosts before contacting any agent, so an invalid config won't leave the job half updated.
+func (j *ConfigFileHukerJob) newPrograms(c *Cluster, jobPtr *Job, hosts []*Host) (map[*Host]*supervisor.Program, error) {
+	progs := make(map[*Host]*supervisor.Program)
+	for _, host := range hosts {
+		prog, err := j.newProgram(c, jobPtr, host)
+		if err != nil {
+			return nil, err
+		}
+		progs[host] = prog
+	}
+	return progs, nil
+}
 
 func (j *ConfigFileHukerJob) updateJob(project, cluster, job string, taskId int, update updateFunc) ([]TaskResult, error) {
 	c, err := j.newCluster(project, cluster, job)
@@ -107,41 +297,85 @@ func (j *ConfigFileHukerJob) updateJob(project, cluster, job string, taskId int,
 		return nil, err
 	}
 
+	jobPtr := c.Jobs[job]
+	hosts := selectHosts(jobPtr, taskId)
+	progs, err := j.newPrograms(c, jobPtr, hosts)
+	if err != nil {
+		return nil, err
+	}
+	excludeFiles := c.excludeFiles(job)
+	return j.runTasks(hosts, j.notified(cluster, job, func(ctx context.Context, host *Host) TaskResult {
+		superClient := supervisor.NewSupervisorCli(host.ToHttpAddress()).WithContext(ctx)
+		if err := keepExcludeEntries(superClient, excludeFiles, progs[host]); err != nil {
+			return NewTaskResult(host, nil, err)
+		}
+		prog, err := update(ctx, jobPtr, host, superClient, progs[host])
+		return NewTaskResult(host, prog, err)
+	})), nil
+}
+
+// Build the same programs as updateJob without contacting any agent, the $AgentRootDir and $TaskId variables
+// are substituted by the given agentRootDir just like the agent does.
+func (j *ConfigFileHukerJob) Render(project, cluster, job string, taskId int, agentRootDir string) ([]TaskResult, error) {
+	c, err := j.newCluster(project, cluster, job)
+	if err != nil {
+		return nil, err
+	}
+
 	jobPtr := c.Jobs[job]
 	var taskResults []TaskResult
-	for _, host := range jobPtr.Hosts {
-		if taskId < 0 || taskId == host.TaskId {
-			cfgMap, err := c.RenderConfigFiles(jobPtr, host.TaskId, false)
-			if err != nil {
-				log.Errorf("Failed to render config file, project: %s, cluster:%s, job:%s, taskId:%d",
-					project, cluster, job, host.TaskId)
-				return nil, err
-			}
-			superClient := supervisor.NewSupervisorCli(host.ToHttpAddress())
-			prog := &supervisor.Program{
-				Name:       c.ClusterName,
-				Job:        job,
-				TaskId:     host.TaskId,
-				Bin:        c.MainProcess,
-				Args:       jobPtr.toShell(),
-				Configs:    cfgMap,
-				PkgAddress: j.pkgServerAddress + "/" + c.PackageName,
-				PkgName:    c.PackageName,
-				PkgMD5Sum:  c.PackageMd5sum,
-				Hooks:      jobPtr.Hooks,
-			}
-			taskResults = append(taskResults, NewTaskResult(host, nil, update(jobPtr, host, superClient, prog)))
+	for _, host := range selectHosts(jobPtr, taskId) {
+		prog, err := j.newProgram(c, jobPtr, host)
+		if err != nil {
+			return nil, err
 		}
+		prog.RenderVars(agentRootDir)
+		taskResults = append(taskResults, NewTaskResult(host, prog, nil))
 	}
 	return taskResults, nil
 }
 
-func (j *ConfigFileHukerJob) List() ([]*Cluster, error) {
+// Compare the programs rendered from yaml with the ones deployed on the agents. The desired programs are rendered
+// with the agent root dir and the decommissioned entries of the deployed ones, so only the real changes are reported.
+func (j *ConfigFileHukerJob) Diff(project, cluster, job string, taskId int) ([]*TaskDiff, error) {
+	c, err := j.newCluster(project, cluster, job)
+	if err != nil {
+		return nil, err
+	}
+
+	jobPtr := c.Jobs[job]
+	hosts := selectHosts(jobPtr, taskId)
+	var taskDiffs []*TaskDiff
+	for _, result := range j.runTasks(hosts, func(ctx context.Context, host *Host) TaskResult {
+		prog, err := supervisor.NewSupervisorCli(host.ToHttpAddress()).WithContext(ctx).Show(cluster, job, host.TaskId)
+		return NewTaskResult(host, prog, err)
+	}) {
+		if result.Err != nil {
+			taskDiffs = append(taskDiffs, &TaskDiff{Host: result.Host, Err: result.Err})
+			continue
+		}
+		prog, err := j.newProgram(c, jobPtr, result.Host)
+		if err != nil {
+			return nil, err
+		}
+		deployed := result.Prog
+		mergeExcludeEntries(c.excludeFiles(job), prog, deployed)
+		// Root dir of the deployed program is <agent-root-dir>/<cluster>/<job>.<task-id>
+		prog.RenderVars(path.Dir(path.Dir(deployed.RootDir)))
+		taskDiff := diffProgram(deployed, prog)
+		taskDiff.Host = result.Host
+		taskDiffs = append(taskDiffs, taskDiff)
+	}
+	return taskDiffs, nil
+}
+
+// List the yaml files of all clusters, which are placed as <config-root-dir>/<project>/<cluster>.yaml
+func (j *ConfigFileHukerJob) listClusterConfigs() ([]string, error) {
 	files, err := ioutil.ReadDir(j.configRootDir)
 	if err != nil {
 		return nil, err
 	}
-	var clusters []*Cluster
+	var clusterCfgs []string
 	for _, f := range files {
 		if f.IsDir() {
 			subFiles, err := ioutil.ReadDir(path.Join(j.configRootDir, f.Name()))
@@ -150,81 +384,170 @@ func (j *ConfigFileHukerJob) List() ([]*Cluster, error) {
 			}
 			for _, subFile := range subFiles {
 				if !subFile.IsDir() {
-					c, err := LoadClusterConfig(path.Join(j.configRootDir, f.Name(), subFile.Name()),
-						&EnvVariables{
-							ConfRootDir:  j.configRootDir,
-							PkgRootDir:   "unknown",
-							PkgConfDir:   "unknown",
-							PkgDataDir:   "unknown",
-							PkgLogDir:    "unknown",
-							PkgStdoutDir: "unknown",
-						})
-					if err != nil {
-						return nil, err
-					}
-					clusters = append(clusters, c)
+					clusterCfgs = append(clusterCfgs, path.Join(j.configRootDir, f.Name(), subFile.Name()))
 				}
 			}
 		}
 	}
+	return clusterCfgs, nil
+}
+
+func (j *ConfigFileHukerJob) loadCluster(clusterCfg string) (*Cluster, error) {
+	return LoadClusterConfig(clusterCfg, &EnvVariables{
+		ConfRootDir:  j.configRootDir,
+		PkgRootDir:   "unknown",
+		PkgConfDir:   "unknown",
+		PkgDataDir:   "unknown",
+		PkgLogDir:    "unknown",
+		PkgStdoutDir: "unknown",
+	})
+}
+
+func (j *ConfigFileHukerJob) List() ([]*Cluster, error) {
+	clusterCfgs, err := j.listClusterConfigs()
+	if err != nil {
+		return nil, err
+	}
+	var clusters []*Cluster
+	for _, clusterCfg := range clusterCfgs {
+		c, err := j.loadCluster(clusterCfg)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load %s: %v", clusterCfg, err)
+		}
+		clusters = append(clusters, c)
+	}
 	return clusters, nil
 }
 
+func (j *ConfigFileHukerJob) Validate() ([]*ValidateError, error) {
+	clusterCfgs, err := j.listClusterConfigs()
+	if err != nil {
+		return nil, err
+	}
+	var errs []*ValidateError
+	var clusters []*Cluster
+	for _, clusterCfg := range clusterCfgs {
+		c, err := j.loadCluster(clusterCfg)
+		if err != nil {
+			errs = append(errs, &ValidateError{File: clusterCfg, TaskId: -1, Err: err})
+			continue
+		}
+		errs = append(errs, c.Validate()...)
+		clusters = append(clusters, c)
+	}
+	for _, conflict := range FindConflicts(clusters) {
+		t := conflict.Tasks[0]
+		errs = append(errs, &ValidateError{File: t.ConfigPath, Job: t.Job, TaskId: t.Host.TaskId, Err: conflict})
+	}
+	return errs, nil
+}
+
+// Refuse to deploy the tasks which collide with the ports or directories of any other defined task. The conflicts
+// can't be checked if any cluster fails to load, so the deployment is refused too.
+func (j *ConfigFileHukerJob) checkConflicts(project, cluster, job string, taskId int) error {
+	clusters, err := j.List()
+	if err != nil {
+		return fmt.Errorf("Failed to check the port and directory conflicts, fix the cluster configs first please: %v", err)
+	}
+	clusterCfg := path.Join(j.configRootDir, project, cluster+".yaml")
+	for _, conflict := range FindConflicts(clusters) {
+		for _, t := range conflict.Tasks {
+			if t.ConfigPath == clusterCfg && t.Job == job && (taskId < 0 || taskId == t.Host.TaskId) {
+				return conflict
+			}
+		}
+	}
+	return nil
+}
+
 func (j *ConfigFileHukerJob) Install(project, cluster, job string, taskId int) ([]TaskResult, error) {
-	// TODO will implement this in #13
-	return nil, nil
+	if err := j.checkConflicts(project, cluster, job, taskId); err != nil {
+		return nil, err
+	}
+	return j.updateJob(project, cluster, job, taskId,
+		func(ctx context.Context, jobPtr *Job, host *Host, s *supervisor.SupervisorCli,
+			prog *supervisor.Program) (*supervisor.Program, error) {
+			if err := s.Install(prog); err != nil {
+				return nil, err
+			}
+			return s.Show(cluster, job, host.TaskId)
+		})
 }
 
 func (j *ConfigFileHukerJob) Shell(project, cluster, job string, extraArgs []string) error {
-	c, err := j.newCluster(project, cluster, job)
+	prog, err := j.localProgram(project, cluster, job)
 	if err != nil {
 		return err
 	}
+	// Start the command.
+	args := append(prog.Args, extraArgs...)
+	cmd := exec.Command(prog.Bin, args...)
+	cmd.Env, cmd.Dir = prog.ProcessEnv(), prog.RootDir
+	cmd.Stderr, cmd.Stdout, cmd.Stdin = os.Stderr, os.Stdout, os.Stdin
+	log.Debugf("%s %s", prog.Bin, strings.Join(args, " "))
+	return cmd.Run()
+}
+
+// Install the package and config files of job under the local huker dir, or update them if already installed, and
+// return the program to run locally.
+func (j *ConfigFileHukerJob) localProgram(project, cluster, job string) (*supervisor.Program, error) {
+	c, err := j.newCluster(project, cluster, job)
+	if err != nil {
+		return nil, err
+	}
 	jobPtr := c.Jobs[job]
 	cfgMap, err := c.RenderConfigFiles(jobPtr, defaultLocalTaskId, true)
 	if err != nil {
 		log.Errorf("Failed to render config file, project: %s, cluster:%s, job:%s, taskId:%d",
 			project, cluster, job, defaultLocalTaskId)
-		return err
+		return nil, err
+	}
+	env, err := c.RenderEnv(jobPtr, defaultLocalTaskId, true)
+	if err != nil {
+		return nil, err
 	}
 	prog := &supervisor.Program{
 		Name:       c.ClusterName,
 		Job:        job,
 		TaskId:     defaultLocalTaskId,
 		Bin:        c.MainProcess,
-		Args:       jobPtr.toShell(),
+		Args:       jobPtr.toShell(defaultLocalTaskId),
 		Configs:    cfgMap,
 		PkgAddress: j.pkgServerAddress + "/" + c.PackageName,
 		PkgName:    c.PackageName,
 		PkgMD5Sum:  c.PackageMd5sum,
 		Hooks:      jobPtr.Hooks,
+		Env:        env,
+		Type:       jobPtr.ProcessType,
 	}
 	agentRootDir := utils.LocalHukerDir()
 	prog.RenderVars(agentRootDir)
 	if err := prog.Install(agentRootDir); err != nil {
 		if !strings.Contains(err.Error(), "already exists, cleanup it first please.") {
-			return err
+			return nil, err
 		} else {
 			if err := prog.UpdatePackage(agentRootDir); err != nil {
-				return err
+				return nil, err
 			}
 			if err := prog.DumpConfigFiles(agentRootDir); err != nil {
-				return err
+				return nil, err
 			}
 		}
 	}
-	// Start the command.
-	args := append(prog.Args, extraArgs...)
-	cmd := exec.Command(prog.Bin, args...)
-	cmd.Stderr, cmd.Stdout, cmd.Stdin = os.Stderr, os.Stdout, os.Stdin
-	log.Debugf("%s %s", prog.Bin, strings.Join(args, " "))
-	return cmd.Run()
+	return prog, nil
 }
 
 func (j *ConfigFileHukerJob) Bootstrap(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	if err := j.checkConflicts(project, cluster, job, taskId); err != nil {
+		return nil, err
+	}
 	return j.updateJob(project, cluster, job, taskId,
-		func(jobPtr *Job, host *Host, s *supervisor.SupervisorCli, prog *supervisor.Program) error {
-			return s.Bootstrap(prog)
+		func(ctx context.Context, jobPtr *Job, host *Host, s *supervisor.SupervisorCli,
+			prog *supervisor.Program) (*supervisor.Program, error) {
+			if err := s.Bootstrap(prog); err != nil {
+				return nil, err
+			}
+			return waitReady(ctx, s, cluster, job, host.TaskId)
 		})
 }
 
@@ -244,11 +567,136 @@ func (j *ConfigFileHukerJob) Restart(project, cluster, job string, taskId int) (
 	return j.lookupJob(project, cluster, job, taskId, "Restart")
 }
 
-func (j *ConfigFileHukerJob) RollingUpdate(project, cluster, job string, taskId int) ([]TaskResult, error) {
-	return j.updateJob(project, cluster, job, taskId,
-		func(jobPtr *Job, host *Host, s *supervisor.SupervisorCli, prog *supervisor.Program) error {
-			return s.RollingUpdate(prog)
-		})
+// Rolling update the tasks batch by batch, the zero values of opts are filled by the rolling_update section of job.
+// The progress is saved under ~/.huker/rolling_update, so an aborted rollout can be continued with opts.Resume.
+func (j *ConfigFileHukerJob) RollingUpdate(project, cluster, job string, taskId int,
+	opts RollingUpdateOptions) ([]TaskResult, error) {
+	c, err := j.newCluster(project, cluster, job)
+	if err != nil {
+		return nil, err
+	}
+	jobPtr := c.Jobs[job]
+	hosts := selectHosts(jobPtr, taskId)
+	progs, err := j.newPrograms(c, jobPtr, hosts)
+	if err != nil {
+		return nil, err
+	}
+	stateName := fmt.Sprintf("%s.%s.%s", project, cluster, job)
+	if taskId >= 0 {
+		stateName = fmt.Sprintf("%s.%d", stateName, taskId)
+	}
+	return j.newRollout(c, jobPtr, hosts, progs, opts, stateName).execute(), nil
+}
+
+// Build the rollout of the hosts to the rendered programs, the progress is saved as <stateName>.json.
+func (j *ConfigFileHukerJob) newRollout(c *Cluster, jobPtr *Job, hosts []*Host,
+	progs map[*Host]*supervisor.Program, opts RollingUpdateOptions, stateName string) *rollout {
+	opts = opts.fillWith(jobPtr.RollingUpdate).fillWith(defaultRollingUpdateOptions)
+	if opts.MaxUnavailable == 0 {
+		opts.MaxUnavailable = opts.BatchSize
+	}
+	cluster, job := c.ClusterName, jobPtr.JobName
+	excludeFiles := c.excludeFiles(job)
+	fingerprints := make(map[int]string)
+	for host, prog := range progs {
+		fingerprints[host.TaskId] = programFingerprint(prog)
+	}
+	return &rollout{
+		opts:         opts,
+		jobHosts:     jobPtr.Hosts,
+		hosts:        hosts,
+		stateFile:    path.Join(j.rolloutStateDir, stateName+".json"),
+		fingerprints: fingerprints,
+		run:          j.runTasks,
+		update: func(ctx context.Context, host *Host) error {
+			supCli := supervisor.NewSupervisorCli(host.ToHttpAddress()).WithContext(ctx)
+			if err := keepExcludeEntries(supCli, excludeFiles, progs[host]); err != nil {
+				return err
+			}
+			return supCli.RollingUpdate(progs[host])
+		},
+		checkHealthy: func(ctx context.Context, host *Host) (*supervisor.Program, error) {
+			prog, err := supervisor.NewSupervisorCli(host.ToHttpAddress()).WithContext(ctx).Show(cluster, job, host.TaskId)
+			if err != nil {
+				return nil, err
+			}
+			if prog.Status == supervisor.StatusUnhealthy {
+				return prog, fmt.Errorf("Task %s is unhealthy: %s", host.ToKey(), prog.ProbeError)
+			}
+			if !supervisor.IsReadyStatus(prog.Status) {
+				return prog, fmt.Errorf("Status of task %s is %s", host.ToKey(), prog.Status)
+			}
+			return prog, nil
+		},
+		done: func(result TaskResult) {
+			j.notifyTask(cluster, job, result)
+		},
+		pollInterval: time.Second,
+	}
+}
+
+// Rolling update opts.Tasks canary tasks of the job, and watch them for opts.Soak before promoting the rest. The
+// canary tasks are rolled back to their deployed programs if they fail, crash or become unhealthy. The canaries are
+// the tasks with the smallest ids, and the job's rolling_update settings apply to both the canaries and the rest.
+func (j *ConfigFileHukerJob) Canary(project, cluster, job string, opts CanaryOptions) ([]TaskResult, error) {
+	c, err := j.newCluster(project, cluster, job)
+	if err != nil {
+		return nil, err
+	}
+	jobPtr := c.Jobs[job]
+	hosts := selectHosts(jobPtr, -1)
+	opts = opts.fillWith(defaultCanaryOptions)
+	if opts.Tasks < 0 || opts.Tasks >= len(hosts) {
+		return nil, fmt.Errorf("Invalid number of canary tasks %d, job `%s` has %d task(s)", opts.Tasks, job, len(hosts))
+	}
+	progs, err := j.newPrograms(c, jobPtr, hosts)
+	if err != nil {
+		return nil, err
+	}
+	canaries, others := hosts[:opts.Tasks], hosts[opts.Tasks:]
+
+	// Keep the deployed programs of canaries to roll back.
+	deployed := make(map[*Host]*supervisor.Program)
+	for _, result := range j.runTasks(canaries, func(ctx context.Context, host *Host) TaskResult {
+		prog, err := supervisor.NewSupervisorCli(host.ToHttpAddress()).WithContext(ctx).Show(cluster, job, host.TaskId)
+		return NewTaskResult(host, prog, err)
+	}) {
+		if result.Err != nil {
+			return nil, fmt.Errorf("Failed to get the deployed program of canary task %s: %v", result.Host.ToKey(), result.Err)
+		}
+		deployed[result.Host] = result.Prog
+	}
+
+	// The promotion shares the state with rolling_update of the job, so it can be resumed by rolling_update --resume.
+	stateName := fmt.Sprintf("%s.%s.%s", project, cluster, job)
+	cn := &canary{
+		opts:     opts,
+		canaries: canaries,
+		others:   others,
+		run:      j.runTasks,
+		update: func(hosts []*Host) []TaskResult {
+			return j.newRollout(c, jobPtr, hosts, progs, RollingUpdateOptions{}, stateName+".canary").execute()
+		},
+		promote: func(hosts []*Host) []TaskResult {
+			return j.newRollout(c, jobPtr, hosts, progs, RollingUpdateOptions{}, stateName).execute()
+		},
+		rollback: func(ctx context.Context, host *Host) error {
+			supCli := supervisor.NewSupervisorCli(host.ToHttpAddress()).WithContext(ctx)
+			if err := supCli.RollingUpdate(deployed[host]); err != nil {
+				return err
+			}
+			_, err := waitReady(ctx, supCli, cluster, job, host.TaskId)
+			return err
+		},
+		inspect: func(ctx context.Context, host *Host) (*supervisor.Program, error) {
+			return supervisor.NewSupervisorCli(host.ToHttpAddress()).WithContext(ctx).Show(cluster, job, host.TaskId)
+		},
+		done: func(result TaskResult) {
+			j.notifyTask(cluster, job, result)
+		},
+		pollInterval: 10 * time.Second,
+	}
+	return cn.execute(context.Background()), nil
 }
 
 func (j *ConfigFileHukerJob) Cleanup(project, cluster, job string, taskId int) ([]TaskResult, error) {
@@ -260,32 +708,36 @@ func (j *ConfigFileHukerJob) lookupJob(project, cluster, job string, taskId int,
 	if err != nil {
 		return nil, err
 	}
-	jobPtr := c.Jobs[job]
-	var taskResults []TaskResult
-	for _, host := range jobPtr.Hosts {
-		if taskId < 0 || taskId == host.TaskId {
-			supCli := supervisor.NewSupervisorCli(host.ToHttpAddress())
-			var err error
-			if action == "Show" {
-				prog, err := supCli.Show(cluster, job, host.TaskId)
-				taskResults = append(taskResults, NewTaskResult(host, prog, err))
-				continue
-			}
+	switch action {
+	case "Show", "Start", "Stop", "Restart", "Cleanup":
+	default:
+		return nil, fmt.Errorf("Unexpected action: %s", action)
+	}
+	task := func(ctx context.Context, host *Host) TaskResult {
+		supCli := supervisor.NewSupervisorCli(host.ToHttpAddress()).WithContext(ctx)
+		var err error
+		if action == "Show" {
+			prog, err := supCli.Show(cluster, job, host.TaskId)
+			return NewTaskResult(host, prog, err)
+		} else if action == "Start" || action == "Restart" {
 			if action == "Start" {
 				err = supCli.Start(cluster, job, host.TaskId)
-			} else if action == "Stop" {
-				err = supCli.Stop(cluster, job, host.TaskId)
-			} else if action == "Restart" {
-				err = supCli.Restart(cluster, job, host.TaskId)
-			} else if action == "Cleanup" {
-				err = supCli.Cleanup(cluster, job, host.TaskId)
 			} else {
-				return nil, fmt.Errorf("Unexpected action: %s", action)
+				err = supCli.Restart(cluster, job, host.TaskId)
 			}
-			taskResults = append(taskResults, NewTaskResult(host, nil, err))
+			if err != nil {
+				return NewTaskResult(host, nil, err)
+			}
+			prog, err := waitReady(ctx, supCli, cluster, job, host.TaskId)
+			return NewTaskResult(host, prog, err)
+		} else if action == "Stop" {
+			err = supCli.Stop(cluster, job, host.TaskId)
+		} else {
+			err = supCli.Cleanup(cluster, job, host.TaskId)
 		}
+		return NewTaskResult(host, nil, err)
 	}
-	return taskResults, nil
+	return j.runTasks(selectHosts(c.Jobs[job], taskId), j.notified(cluster, job, task)), nil
 }
 
 func (j *ConfigFileHukerJob) ListHosts() ([]string, error) {
@@ -308,3 +760,114 @@ func (j *ConfigFileHukerJob) ListHosts() ([]string, error) {
 	}
 	return hostStrings, nil
 }
+
+// Bootstrap the cluster with all its dependencies in order, the tasks already bootstrapped are started if stopped.
+// Stop at the first job which has any failed task, since the later jobs depend on it.
+func (j *ConfigFileHukerJob) BootstrapCluster(project, cluster string) ([]JobResult, error) {
+	return j.operateCluster(project, cluster, "bootstrap")
+}
+
+// Start the cluster with all its dependencies in order, the running tasks are skipped. Stop at the first job which
+// has any failed task, since the later jobs depend on it.
+func (j *ConfigFileHukerJob) StartCluster(project, cluster string) ([]JobResult, error) {
+	return j.operateCluster(project, cluster, "start")
+}
+
+// Stop the cluster with all its dependencies in the reverse order of starting, the tasks not running are skipped.
+func (j *ConfigFileHukerJob) StopCluster(project, cluster string) ([]JobResult, error) {
+	return j.operateCluster(project, cluster, "stop")
+}
+
+// List the jobs of the cluster and all its dependencies in the order to start them.
+func (j *ConfigFileHukerJob) ClusterSteps(project, cluster string) ([]ClusterStep, error) {
+	c, err := j.newProjectCluster(project, cluster, "")
+	if err != nil {
+		return nil, err
+	}
+	return clusterSteps(j.configRootDir, c, false)
+}
+
+func (j *ConfigFileHukerJob) operateCluster(project, cluster, action string) ([]JobResult, error) {
+	c, err := j.newProjectCluster(project, cluster, "")
+	if err != nil {
+		return nil, err
+	}
+	steps, err := clusterSteps(j.configRootDir, c, action == "stop")
+	if err != nil {
+		return nil, err
+	}
+	var jobResults []JobResult
+	for _, step := range steps {
+		log.Infof("%s job %s/%s/%s", action, step.Project, step.Cluster, step.Job)
+		results, err := j.ensureJob(step.Project, step.Cluster, step.Job, action)
+		if err != nil {
+			return jobResults, fmt.Errorf("Failed to %s job %s/%s/%s: %v", action, step.Project, step.Cluster, step.Job, err)
+		}
+		jobResults = append(jobResults, JobResult{ClusterStep: step, Results: results})
+		if action == "stop" {
+			continue
+		}
+		for _, result := range results {
+			if result.Err != nil {
+				return jobResults, fmt.Errorf("Abort to %s cluster %s, job %s/%s/%s failed", action, cluster,
+					step.Project, step.Cluster, step.Job)
+			}
+		}
+	}
+	return jobResults, nil
+}
+
+// Bring every task of the job to the state of action by its current status. The result of an untouched task
+// carries its program.
+func (j *ConfigFileHukerJob) ensureJob(project, cluster, job, action string) ([]TaskResult, error) {
+	c, err := j.newCluster(project, cluster, job)
+	if err != nil {
+		return nil, err
+	}
+	jobPtr := c.Jobs[job]
+	hosts := selectHosts(jobPtr, -1)
+	progs := make(map[*Host]*supervisor.Program)
+	if action == "bootstrap" {
+		if err := j.checkConflicts(project, cluster, job, -1); err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			if progs[host], err = j.newProgram(c, jobPtr, host); err != nil {
+				return nil, err
+			}
+		}
+	}
+	task := func(ctx context.Context, host *Host) TaskResult {
+		supCli := supervisor.NewSupervisorCli(host.ToHttpAddress()).WithContext(ctx)
+		prog, err := supCli.Show(cluster, job, host.TaskId)
+		if err != nil {
+			if action == "bootstrap" && supervisor.IsTaskNotFound(err) {
+				if err := supCli.Bootstrap(progs[host]); err != nil {
+					return NewTaskResult(host, nil, err)
+				}
+				prog, err := waitReady(ctx, supCli, cluster, job, host.TaskId)
+				return NewTaskResult(host, prog, err)
+			}
+			if action == "stop" && supervisor.IsTaskNotFound(err) {
+				return NewTaskResult(host, &supervisor.Program{Status: supervisor.StatusNotBootstrap}, nil)
+			}
+			return NewTaskResult(host, nil, err)
+		}
+		running := supervisor.IsRunningStatus(prog.Status)
+		if action == "stop" {
+			if running {
+				return NewTaskResult(host, nil, supCli.Stop(cluster, job, host.TaskId))
+			}
+			return NewTaskResult(host, prog, nil)
+		}
+		if !running {
+			if err := supCli.Start(cluster, job, host.TaskId); err != nil {
+				return NewTaskResult(host, nil, err)
+			}
+		}
+		// The later jobs depend on this one, so wait for the task to be ready even if it's already running.
+		prog, err = waitReady(ctx, supCli, cluster, job, host.TaskId)
+		return NewTaskResult(host, prog, err)
+	}
+	return j.runTasks(hosts, j.notified(cluster, job, task)), nil
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSelectHosts(t *testing.T) {
+	jobPtr := &Job{Hosts: []*Host{{TaskId: 2}, {TaskId: 0}, {TaskId: 1}}}
+	hosts := selectHosts(jobPtr, -1)
+	for i := range hosts {
+		if hosts[i].TaskId != i {
+			t.Errorf("Hosts should be in task id order, hosts[%d] is task %d", i, hosts[i].TaskId)
+		}
+	}
+	if hosts := selectHosts(jobPtr, 1); len(hosts) != 1 || hosts[0].TaskId != 1 {
+		t.Errorf("Only task 1 should be selected, %v", hosts)
+	}
+	if hosts := selectHosts(jobPtr, 3); len(hosts) != 0 {
+		t.Errorf("No task should be selected, %v", hosts)
+	}
+}
+
+func TestRunTasks(t *testing.T) {
+	j := &ConfigFileHukerJob{parallel: 3, taskTimeout: 500 * time.Millisecond}
+	var hosts []*Host
+	for i := 0; i < 10; i++ {
+		hosts = append(hosts, &Host{TaskId: i})
+	}
+
+	var running, maxRunning int32
+	var timedOut int32
+	results := j.runTasks(hosts, func(ctx context.Context, host *Host) TaskResult {
+		cur := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			prev := atomic.LoadInt32(&maxRunning)
+			if cur <= prev || atomic.CompareAndSwapInt32(&maxRunning, prev, cur) {
+				break
+			}
+		}
+		// The later task finishes earlier, and task 7 never finishes in time, it holds the slot until it returns.
+		if host.TaskId == 7 {
+			select {
+			case <-ctx.Done():
+				time.Sleep(100 * time.Millisecond)
+				atomic.StoreInt32(&timedOut, 1)
+				return NewTaskResult(host, nil, ctx.Err())
+			case <-time.After(2 * time.Second):
+			}
+		} else {
+			time.Sleep(time.Duration(10-host.TaskId) * 10 * time.Millisecond)
+		}
+		if host.TaskId%2 == 1 {
+			return NewTaskResult(host, nil, fmt.Errorf("task %d failed", host.TaskId))
+		}
+		return NewTaskResult(host, nil, nil)
+	})
+
+	if atomic.LoadInt32(&timedOut) != 1 {
+		t.Errorf("The timed out task should return before the results")
+	}
+	if maxRunning > 3 {
+		t.Errorf("At most 3 tasks should be running at the same time, instead of %d", maxRunning)
+	}
+	if len(results) != len(hosts) {
+		t.Fatalf("Result size mismatch, %d != %d", len(results), len(hosts))
+	}
+	for i := range results {
+		if results[i].Host != hosts[i] {
+			t.Errorf("Results should be in task id order, results[%d] is task %d", i, results[i].Host.TaskId)
+		}
+		var expected string
+		if i == 7 {
+			expected = "Timeout after 500ms: context deadline exceeded"
+		} else if i%2 == 1 {
+			expected = fmt.Sprintf("task %d failed", i)
+		}
+		if actual := fmt.Sprintf("%v", results[i].Err); (expected == "" && results[i].Err != nil) ||
+			(expected != "" && actual != expected) {
+			t.Errorf("Error of task %d mismatch, %s != %s", i, actual, expected)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/openinx/huker/pkg"
+	"github.com/openinx/huker/pkg/utils"
+	"github.com/qiniu/log"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// Origins of the audited operations.
+const (
+	OriginCLI       = "cli"
+	OriginDashboard = "dashboard"
+)
+
+// An operation of HukerJob, such as bootstrap, start, stop, restart, rolling_update, decommission, recommission or
+// cleanup.
+type AuditRecord struct {
+	Time     time.Time `json:"time"`
+	Operator string    `json:"operator"`
+	Origin   string    `json:"origin"`
+	Action   string    `json:"action"`
+	Project  string    `json:"project"`
+	Cluster  string    `json:"cluster"`
+	// Empty for the operations of the whole cluster.
+	Job string `json:"job,omitempty"`
+	// -1 if all tasks of the job are operated.
+	TaskId int `json:"task_id"`
+	// Package md5sum rendered from the yaml of the cluster when the operation starts, empty if it fails to load.
+	PkgMD5Sum string        `json:"pkg_md5sum,omitempty"`
+	Tasks     []AuditTask   `json:"tasks"`
+	Duration  time.Duration `json:"duration"`
+	// Error of the whole operation, the errors of tasks are kept in Tasks.
+	Err string `json:"error,omitempty"`
+}
+
+// Result of an operated task.
+type AuditTask struct {
+	Cluster string `json:"cluster"`
+	Job     string `json:"job"`
+	Host    string `json:"host"`
+	TaskId  int    `json:"task_id"`
+	// Package md5sum of the program deployed on the task, empty if the operation reports no program.
+	PkgMD5Sum string `json:"pkg_md5sum,omitempty"`
+	Err       string `json:"error,omitempty"`
+}
+
+// Number of failed tasks.
+func (r *AuditRecord) FailedTasks() int {
+	failed := 0
+	for _, t := range r.Tasks {
+		if t.Err != "" {
+			failed++
+		}
+	}
+	return failed
+}
+
+// True if the operation and all its tasks succeed.
+func (r *AuditRecord) Success() bool {
+	return r.Err == "" && r.FailedTasks() == 0
+}
+
+// Target of the operation, such as hdfs/test-hdfs/namenode/0, hdfs/test-hdfs/namenode or hdfs/test-hdfs.
+func (r *AuditRecord) Target() string {
+	target := r.Project + "/" + r.Cluster
+	if r.Job != "" {
+		target += "/" + r.Job
+	}
+	if r.TaskId >= 0 {
+		target += fmt.Sprintf("/%d", r.TaskId)
+	}
+	return target
+}
+
+// Conditions to query the audit log, the empty ones match all.
+type AuditFilter struct {
+	Project  string
+	Cluster  string
+	Job      string
+	Action   string
+	Operator string
+	Since    time.Time
+	// Max number of records to return, the latest ones are returned first. 0 means unlimited.
+	Limit int
+}
+
+func (f *AuditFilter) match(r *AuditRecord) bool {
+	if (f.Project != "" && f.Project != r.Project) || (f.Cluster != "" && f.Cluster != r.Cluster) ||
+		(f.Action != "" && f.Action != r.Action) || (f.Operator != "" && f.Operator != r.Operator) ||
+		r.Time.Before(f.Since) {
+		return false
+	}
+	if f.Job == "" || f.Job == r.Job {
+		return true
+	}
+	// The operation of whole cluster matches the jobs it operated.
+	for _, t := range r.Tasks {
+		if t.Job == f.Job {
+			return true
+		}
+	}
+	return false
+}
+
+// Append-only store of the audit records, one json record per line.
+type AuditLog struct {
+	file string
+	mu   sync.Mutex
+}
+
+func NewAuditLog(file string) *AuditLog {
+	return &AuditLog{file: file}
+}
+
+// The audit log of huker.audit.log.file in huker.yaml, default: ~/.huker/audit.log
+func NewDefaultAuditLog() *AuditLog {
+	file := path.Join(utils.LocalHukerDir(), "audit.log")
+	if cfg, err := pkg.NewHukerConfig(path.Join(utils.GetHukerDir(), "conf", "huker.yaml")); err != nil {
+		log.Warnf("Failed to load huker.yaml, use the audit log %s: %v", file, err)
+	} else if cfgFile := cfg.Get(pkg.HukerAuditLogFile); cfgFile != "" {
+		file = cfgFile
+	}
+	return NewAuditLog(file)
+}
+
+func (l *AuditLog) File() string {
+	return l.file
+}
+
+func (l *AuditLog) Append(r *AuditRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.MkdirAll(path.Dir(l.file), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Write the record with a single append, so the records of concurrent processes won't interleave.
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Query the records matching the filter, the latest ones come first.
+func (l *AuditLog) Query(filter AuditFilter) ([]*AuditRecord, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.Open(l.file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []*AuditRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		r := &AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			log.Warnf("Skip the invalid record at %s:%d: %v", l.file, lineNo, err)
+			continue
+		}
+		if filter.match(r) {
+			records = append(records, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i, k := 0, len(records)-1; i < k; i, k = i+1, k-1 {
+		records[i], records[k] = records[k], records[i]
+	}
+	if filter.Limit > 0 && len(records) > filter.Limit {
+		records = records[:filter.Limit]
+	}
+	return records, nil
+}
+
+// HukerJob which records every operation changing the tasks into the audit log. The failure of recording is
+// logged without failing the operation.
+type auditedHukerJob struct {
+	HukerJob
+	audit    *AuditLog
+	origin   string
+	operator string
+}
+
+func NewAuditedHukerJob(h HukerJob, audit *AuditLog, origin, operator string) HukerJob {
+	return &auditedHukerJob{HukerJob: h, audit: audit, origin: origin, operator: operator}
+}
+
+// Package md5sum rendered from the yaml of the cluster, which is the one to deploy.
+func (a *auditedHukerJob) pkgMD5Sum(project, cluster string) string {
+	clusters, err := a.HukerJob.List()
+	if err != nil {
+		log.Warnf("Failed to render the package md5sum of %s/%s for audit: %v", project, cluster, err)
+		return ""
+	}
+	for _, c := range clusters {
+		if c.Project == project && c.ClusterName == cluster {
+			return c.PackageMd5sum
+		}
+	}
+	return ""
+}
+
+func (a *auditedHukerJob) newRecord(action, project, cluster, job string, taskId int, md5sum string,
+	start time.Time, err error) *AuditRecord {
+	r := &AuditRecord{
+		Time:      start,
+		Operator:  a.operator,
+		Origin:    a.origin,
+		Action:    action,
+		Project:   project,
+		Cluster:   cluster,
+		Job:       job,
+		TaskId:    taskId,
+		PkgMD5Sum: md5sum,
+		Duration:  time.Since(start),
+	}
+	if err != nil {
+		r.Err = err.Error()
+	}
+	return r
+}
+
+func (a *auditedHukerJob) appendTasks(r *AuditRecord, project, cluster, job string, results []TaskResult) {
+	for _, result := range results {
+		t := AuditTask{Cluster: cluster, Job: job, Host: result.Host.ToKey(), TaskId: result.Host.TaskId}
+		// Only the program reported by the agent tells which package is deployed.
+		if result.Prog != nil {
+			t.PkgMD5Sum = result.Prog.PkgMD5Sum
+		}
+		if result.Err != nil {
+			t.Err = result.Err.Error()
+		}
+		r.Tasks = append(r.Tasks, t)
+	}
+}
+
+func (a *auditedHukerJob) save(r *AuditRecord) {
+	if err := a.audit.Append(r); err != nil {
+		log.Errorf("Failed to record %s %s into audit log %s: %v", r.Action, r.Target(), a.audit.File(), err)
+	}
+}
+
+func (a *auditedHukerJob) recordJob(action, project, cluster, job string, taskId int,
+	operate func() ([]TaskResult, error)) ([]TaskResult, error) {
+	start := time.Now()
+	md5sum := a.pkgMD5Sum(project, cluster)
+	results, err := operate()
+	r := a.newRecord(action, project, cluster, job, taskId, md5sum, start, err)
+	a.appendTasks(r, project, cluster, job, results)
+	a.save(r)
+	return results, err
+}
+
+func (a *auditedHukerJob) recordCluster(action, project, cluster string,
+	operate func() ([]JobResult, error)) ([]JobResult, error) {
+	start := time.Now()
+	md5sum := a.pkgMD5Sum(project, cluster)
+	jobResults, err := operate()
+	r := a.newRecord(action, project, cluster, "", -1, md5sum, start, err)
+	for _, jobResult := range jobResults {
+		a.appendTasks(r, jobResult.Project, jobResult.Cluster, jobResult.Job, jobResult.Results)
+	}
+	a.save(r)
+	return jobResults, err
+}
+
+func (a *auditedHukerJob) Install(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return a.recordJob("install", project, cluster, job, taskId, func() ([]TaskResult, error) {
+		return a.HukerJob.Install(project, cluster, job, taskId)
+	})
+}
+
+func (a *auditedHukerJob) Bootstrap(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return a.recordJob("bootstrap", project, cluster, job, taskId, func() ([]TaskResult, error) {
+		return a.HukerJob.Bootstrap(project, cluster, job, taskId)
+	})
+}
+
+func (a *auditedHukerJob) Start(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return a.recordJob("start", project, cluster, job, taskId, func() ([]TaskResult, error) {
+		return a.HukerJob.Start(project, cluster, job, taskId)
+	})
+}
+
+func (a *auditedHukerJob) Stop(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return a.recordJob("stop", project, cluster, job, taskId, func() ([]TaskResult, error) {
+		return a.HukerJob.Stop(project, cluster, job, taskId)
+	})
+}
+
+func (a *auditedHukerJob) Restart(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return a.recordJob("restart", project, cluster, job, taskId, func() ([]TaskResult, error) {
+		return a.HukerJob.Restart(project, cluster, job, taskId)
+	})
+}
+
+func (a *auditedHukerJob) RollingUpdate(project, cluster, job string, taskId int,
+	opts RollingUpdateOptions) ([]TaskResult, error) {
+	return a.recordJob("rolling_update", project, cluster, job, taskId, func() ([]TaskResult, error) {
+		return a.HukerJob.RollingUpdate(project, cluster, job, taskId, opts)
+	})
+}
+
+func (a *auditedHukerJob) Canary(project, cluster, job string, opts CanaryOptions) ([]TaskResult, error) {
+	return a.recordJob("canary", project, cluster, job, -1, func() ([]TaskResult, error) {
+		return a.HukerJob.Canary(project, cluster, job, opts)
+	})
+}
+
+func (a *auditedHukerJob) Decommission(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return a.recordJob("decommission", project, cluster, job, taskId, func() ([]TaskResult, error) {
+		return a.HukerJob.Decommission(project, cluster, job, taskId)
+	})
+}
+
+func (a *auditedHukerJob) Recommission(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return a.recordJob("recommission", project, cluster, job, taskId, func() ([]TaskResult, error) {
+		return a.HukerJob.Recommission(project, cluster, job, taskId)
+	})
+}
+
+func (a *auditedHukerJob) Cleanup(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return a.recordJob("cleanup", project, cluster, job, taskId, func() ([]TaskResult, error) {
+		return a.HukerJob.Cleanup(project, cluster, job, taskId)
+	})
+}
+
+func (a *auditedHukerJob) BootstrapCluster(project, cluster string) ([]JobResult, error) {
+	return a.recordCluster("bootstrap-cluster", project, cluster, func() ([]JobResult, error) {
+		return a.HukerJob.BootstrapCluster(project, cluster)
+	})
+}
+
+func (a *auditedHukerJob) StartCluster(project, cluster string) ([]JobResult, error) {
+	return a.recordCluster("start-cluster", project, cluster, func() ([]JobResult, error) {
+		return a.HukerJob.StartCluster(project, cluster)
+	})
+}
+
+func (a *auditedHukerJob) StopCluster(project, cluster string) ([]JobResult, error) {
+	return a.recordCluster("stop-cluster", project, cluster, func() ([]JobResult, error) {
+		return a.HukerJob.StopCluster(project, cluster)
+	})
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"fmt"
+	"github.com/openinx/huker/pkg/supervisor"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Fake HukerJob which fails the task 1 of every operation, the succeed tasks report their deployed programs.
+type fakeHukerJob struct {
+	HukerJob
+}
+
+func (f *fakeHukerJob) results(cluster string, taskId int) []TaskResult {
+	var results []TaskResult
+	for i := 0; i < 2; i++ {
+		if taskId < 0 || taskId == i {
+			host := &Host{Hostname: "127.0.0.1", SupervisorPort: 9001, TaskId: i}
+			if i == 1 {
+				results = append(results, NewTaskResult(host, nil, fmt.Errorf("task %d failed", i)))
+			} else {
+				results = append(results, NewTaskResult(host, &supervisor.Program{PkgMD5Sum: "md5-" + cluster}, nil))
+			}
+		}
+	}
+	return results
+}
+
+func (f *fakeHukerJob) Restart(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return f.results(cluster, taskId), nil
+}
+
+func (f *fakeHukerJob) Decommission(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return f.results(cluster, taskId), nil
+}
+
+// The datanode of test-hdfs is decommissioned by the exclude file of namenode.
+func (f *fakeHukerJob) List() ([]*Cluster, error) {
+	return []*Cluster{{Project: "hdfs", ClusterName: "test-hdfs", PackageMd5sum: "md5-desired", Jobs: map[string]*Job{
+		"datanode": {JobName: "datanode", Decommission: &DecommissionProcedure{ExcludeJob: "namenode",
+			ExcludeFile: "dfs_exclude"}},
+	}}}, nil
+}
+
+func (f *fakeHukerJob) StopCluster(project, cluster string) ([]JobResult, error) {
+	return []JobResult{
+		{ClusterStep{project, cluster, "regionserver"}, f.results(cluster, -1)},
+		{ClusterStep{"hdfs", "test-hdfs", "datanode"}, f.results("test-hdfs", 0)},
+	}, fmt.Errorf("stop-cluster failed")
+}
+
+func TestAuditedHukerJob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	audit := NewAuditLog(path.Join(dir, "audit", "audit.log"))
+
+	h := NewAuditedHukerJob(&fakeHukerJob{}, audit, OriginCLI, "alice@host0")
+	if _, err := h.Restart("hdfs", "test-hdfs", "namenode", 0); err != nil {
+		t.Fatal(err)
+	}
+	h = NewAuditedHukerJob(&fakeHukerJob{}, audit, OriginDashboard, "10.0.0.1")
+	if _, err := h.Restart("hdfs", "test-hdfs", "datanode", -1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.StopCluster("hbase", "test-hbase"); err == nil {
+		t.Fatal("Error of stop-cluster should be returned")
+	}
+
+	records, err := audit.Query(AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].Action != "stop-cluster" || records[2].Action != "restart" {
+		t.Fatalf("Records should be the latest first, %v", records)
+	}
+	r := records[2]
+	if r.Operator != "alice@host0" || r.Origin != OriginCLI || r.Target() != "hdfs/test-hdfs/namenode/0" ||
+		!r.Success() || len(r.Tasks) != 1 || r.Tasks[0].PkgMD5Sum != "md5-test-hdfs" {
+		t.Errorf("Record of restart mismatch, %+v", r)
+	}
+	r = records[1]
+	// The failed task reports no deployed program, the rendered md5sum of the operation is kept.
+	if r.Origin != OriginDashboard || r.Success() || r.FailedTasks() != 1 || r.Tasks[1].Err != "task 1 failed" ||
+		r.Tasks[1].PkgMD5Sum != "" || r.PkgMD5Sum != "md5-desired" {
+		t.Errorf("Record of failed restart mismatch, %+v", r)
+	}
+	r = records[0]
+	if r.Err != "stop-cluster failed" || r.Target() != "hbase/test-hbase" || r.PkgMD5Sum != "" || len(r.Tasks) != 3 ||
+		r.Tasks[2].Cluster != "test-hdfs" || r.Tasks[2].PkgMD5Sum != "md5-test-hdfs" {
+		t.Errorf("Record of stop-cluster mismatch, %+v", r)
+	}
+
+	for _, c := range []struct {
+		filter   AuditFilter
+		expected int
+	}{
+		{AuditFilter{Project: "hdfs"}, 2},
+		{AuditFilter{Job: "datanode"}, 2},
+		{AuditFilter{Job: "datanode", Cluster: "test-hdfs"}, 1},
+		{AuditFilter{Action: "restart", Operator: "10.0.0.1"}, 1},
+		{AuditFilter{Since: time.Now().Add(time.Hour)}, 0},
+		{AuditFilter{Limit: 2}, 2},
+	} {
+		if records, err := audit.Query(c.filter); err != nil || len(records) != c.expected {
+			t.Errorf("Query %+v should return %d record(s), records: %v, err: %v", c.filter, c.expected, records, err)
+		}
+	}
+
+	if records, err := NewAuditLog(path.Join(dir, "not-exist.log")).Query(AuditFilter{}); err != nil || records != nil {
+		t.Errorf("Query the audit log not exist should return nothing, records: %v, err: %v", records, err)
+	}
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/openinx/huker/pkg/supervisor"
+	"github.com/qiniu/log"
+	"time"
+)
+
+// Settings of canary deployment, the zero values are filled by the defaults.
+type CanaryOptions struct {
+	// Number of tasks to update first, default: 1
+	Tasks int
+	// Time to watch the canary tasks before promoting, default: 10m
+	Soak time.Duration
+	// Roll back once any canary task crashes more than so many times during the soak.
+	MaxCrashes int
+}
+
+var defaultCanaryOptions = CanaryOptions{
+	Tasks: 1,
+	Soak:  10 * time.Minute,
+}
+
+// Fill the zero values with the other's.
+func (o CanaryOptions) fillWith(other CanaryOptions) CanaryOptions {
+	if o.Tasks == 0 {
+		o.Tasks = other.Tasks
+	}
+	if o.Soak == 0 {
+		o.Soak = other.Soak
+	}
+	return o
+}
+
+// Canary deployment of a job: the canary tasks are rolling updated and watched for the soak time, then the new
+// program is promoted to the remaining tasks. The canary tasks are rolled back to the deployed program if any of them
+// fails to update, crashes or becomes unhealthy, and the remaining tasks are left untouched.
+type canary struct {
+	opts     CanaryOptions
+	canaries []*Host
+	others   []*Host
+	// Run the task function for the hosts concurrently, and return the results in the same order.
+	run func([]*Host, func(context.Context, *Host) TaskResult) []TaskResult
+	// Rolling update the canary tasks to the new program.
+	update func([]*Host) []TaskResult
+	// Rolling update the remaining tasks to the new program.
+	promote func([]*Host) []TaskResult
+	// Roll back the canary task to the program deployed before the canary.
+	rollback func(context.Context, *Host) error
+	inspect  func(context.Context, *Host) (*supervisor.Program, error)
+	// Called with the result of every rolled back task once it's done, may be nil.
+	done         func(TaskResult)
+	pollInterval time.Duration
+}
+
+// Watch the canary tasks until the soak time elapses. A crash is counted whenever the process is found not running
+// or with another PID since the last poll. The soak is aborted once ctx is done.
+func (c *canary) soak(ctx context.Context) error {
+	lastPID := make(map[int]int)
+	crashes := make(map[int]int)
+	deadline := time.Now().Add(c.opts.Soak)
+	for {
+		var soakErr error
+		for _, result := range c.run(c.canaries, func(ctx context.Context, host *Host) TaskResult {
+			prog, err := c.inspect(ctx, host)
+			return NewTaskResult(host, prog, err)
+		}) {
+			host, prog := result.Host, result.Prog
+			if result.Err != nil {
+				return fmt.Errorf("Failed to inspect canary task %s: %v", host.ToKey(), result.Err)
+			}
+			if prog.Status == supervisor.StatusUnhealthy {
+				return fmt.Errorf("Canary task %s is unhealthy: %s", host.ToKey(), prog.ProbeError)
+			}
+			running := supervisor.IsRunningStatus(prog.Status)
+			if pid, ok := lastPID[host.TaskId]; ok && pid != 0 && (!running || pid != prog.PID) {
+				crashes[host.TaskId]++
+				log.Warnf("Canary task %s crashed, status: %s, pid: %d -> %d", host.ToKey(), prog.Status, pid, prog.PID)
+			}
+			if crashes[host.TaskId] > c.opts.MaxCrashes {
+				return fmt.Errorf("Canary task %s crashed %d time(s), more than max crashes %d", host.ToKey(),
+					crashes[host.TaskId], c.opts.MaxCrashes)
+			}
+			if running {
+				lastPID[host.TaskId] = prog.PID
+			} else {
+				lastPID[host.TaskId] = 0
+			}
+			if !supervisor.IsReadyStatus(prog.Status) && soakErr == nil {
+				soakErr = fmt.Errorf("Canary task %s is %s after soak %v", host.ToKey(), prog.Status, c.opts.Soak)
+			}
+		}
+		if time.Now().After(deadline) {
+			return soakErr
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Soak aborted: %v", ctx.Err())
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// Results of the canary tasks come first, then the remaining ones.
+func (c *canary) execute(ctx context.Context) []TaskResult {
+	log.Infof("Update %d canary task(s)", len(c.canaries))
+	taskResults := c.update(c.canaries)
+	var canaryErr error
+	for _, result := range taskResults {
+		if result.Err != nil {
+			canaryErr = fmt.Errorf("Failed to update canary task %s: %v", result.Host.ToKey(), result.Err)
+			break
+		}
+	}
+	if canaryErr == nil {
+		log.Infof("Soak the canary task(s) for %v", c.opts.Soak)
+		canaryErr = c.soak(ctx)
+	}
+
+	if canaryErr == nil {
+		log.Infof("Canary passed, promote to the remaining %d task(s)", len(c.others))
+		return append(taskResults, c.promote(c.others)...)
+	}
+
+	log.Errorf("Canary failed, roll back the canary task(s): %v", canaryErr)
+	taskResults = c.run(c.canaries, func(ctx context.Context, host *Host) TaskResult {
+		result := NewTaskResult(host, nil, fmt.Errorf("Rolled back, %v", canaryErr))
+		if err := c.rollback(ctx, host); err != nil {
+			result = NewTaskResult(host, nil, fmt.Errorf("Failed to roll back (%v): %v", canaryErr, err))
+		}
+		if c.done != nil {
+			c.done(result)
+		}
+		return result
+	})
+	for _, host := range c.others {
+		taskResults = append(taskResults, NewTaskResult(host, nil, fmt.Errorf("Not promoted, %v", canaryErr)))
+	}
+	return taskResults
+}
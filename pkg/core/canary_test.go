@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/openinx/huker/pkg/supervisor"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake tasks for canary, inspect returns the next program of the task every time until the last one.
+type fakeCanaryTasks struct {
+	mu         sync.Mutex
+	progs      map[int][]*supervisor.Program
+	updated    []int
+	promoted   []int
+	rolledBack []int
+	updateErr  error
+}
+
+func (f *fakeCanaryTasks) newCanary(opts CanaryOptions, size int) *canary {
+	var hosts []*Host
+	for i := 0; i < size; i++ {
+		hosts = append(hosts, &Host{Hostname: "127.0.0.1", SupervisorPort: 9001, TaskId: i})
+	}
+	taskIds := func(hosts []*Host) []int {
+		var ids []int
+		for _, host := range hosts {
+			ids = append(ids, host.TaskId)
+		}
+		return ids
+	}
+	j := &ConfigFileHukerJob{parallel: 10, taskTimeout: time.Minute}
+	opts = opts.fillWith(defaultCanaryOptions)
+	return &canary{
+		opts:     opts,
+		canaries: hosts[:opts.Tasks],
+		others:   hosts[opts.Tasks:],
+		run:      j.runTasks,
+		update: func(hosts []*Host) []TaskResult {
+			f.updated = append(f.updated, taskIds(hosts)...)
+			var results []TaskResult
+			for _, host := range hosts {
+				results = append(results, NewTaskResult(host, nil, f.updateErr))
+			}
+			return results
+		},
+		promote: func(hosts []*Host) []TaskResult {
+			f.promoted = append(f.promoted, taskIds(hosts)...)
+			var results []TaskResult
+			for _, host := range hosts {
+				results = append(results, NewTaskResult(host, nil, nil))
+			}
+			return results
+		},
+		rollback: func(ctx context.Context, host *Host) error {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.rolledBack = append(f.rolledBack, host.TaskId)
+			return nil
+		},
+		inspect: func(ctx context.Context, host *Host) (*supervisor.Program, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			progs := f.progs[host.TaskId]
+			if len(progs) == 0 {
+				return nil, fmt.Errorf("task %d not found", host.TaskId)
+			}
+			prog := progs[0]
+			if len(progs) > 1 {
+				f.progs[host.TaskId] = progs[1:]
+			}
+			return prog, nil
+		},
+		pollInterval: 10 * time.Millisecond,
+	}
+}
+
+func TestCanary(t *testing.T) {
+	ready := func(pid int) *supervisor.Program {
+		return &supervisor.Program{Status: supervisor.StatusReady, PID: pid}
+	}
+	stopped := &supervisor.Program{Status: supervisor.StatusStopped}
+	opts := CanaryOptions{Soak: 50 * time.Millisecond}
+
+	// Promote to the rest once the canary survives the soak.
+	f := &fakeCanaryTasks{progs: map[int][]*supervisor.Program{0: {ready(100)}}}
+	results := f.newCanary(opts, 3).execute(context.Background())
+	if len(results) != 3 || failedTasks(results) != nil {
+		t.Errorf("All tasks should be updated, results: %v", results)
+	}
+	if !reflect.DeepEqual(f.updated, []int{0}) || !reflect.DeepEqual(f.promoted, []int{1, 2}) || f.rolledBack != nil {
+		t.Errorf("Canary task 0 should be promoted to 1, 2, updated: %v, promoted: %v", f.updated, f.promoted)
+	}
+
+	// Roll back the canaries once any of them crashed.
+	f = &fakeCanaryTasks{progs: map[int][]*supervisor.Program{0: {ready(100)}, 1: {ready(101), stopped}}}
+	results = f.newCanary(CanaryOptions{Tasks: 2, Soak: opts.Soak}, 3).execute(context.Background())
+	if !reflect.DeepEqual(failedTasks(results), []int{0, 1, 2}) || f.promoted != nil {
+		t.Errorf("No task should be promoted, results: %v, promoted: %v", results, f.promoted)
+	}
+	if len(f.rolledBack) != 2 {
+		t.Errorf("Canary tasks should be rolled back, %v", f.rolledBack)
+	}
+
+	// The crashes within MaxCrashes are tolerated if the canary is ready in the end.
+	f = &fakeCanaryTasks{progs: map[int][]*supervisor.Program{0: {ready(100), ready(200)}}}
+	results = f.newCanary(CanaryOptions{Soak: opts.Soak, MaxCrashes: 1}, 2).execute(context.Background())
+	if failedTasks(results) != nil {
+		t.Errorf("One crash should be tolerated, results: %v", results)
+	}
+
+	// Roll back the unhealthy canary.
+	unhealthy := &supervisor.Program{Status: supervisor.StatusUnhealthy, PID: 100, ProbeError: "Connection refused"}
+	f = &fakeCanaryTasks{progs: map[int][]*supervisor.Program{0: {unhealthy}}}
+	results = f.newCanary(opts, 2).execute(context.Background())
+	if !reflect.DeepEqual(failedTasks(results), []int{0, 1}) || !reflect.DeepEqual(f.rolledBack, []int{0}) {
+		t.Errorf("Unhealthy canary should be rolled back, results: %v, rolled back: %v", results, f.rolledBack)
+	}
+
+	// Roll back without soak if the canary fails to update.
+	f = &fakeCanaryTasks{progs: map[int][]*supervisor.Program{}, updateErr: fmt.Errorf("download failed")}
+	results = f.newCanary(opts, 2).execute(context.Background())
+	if !reflect.DeepEqual(failedTasks(results), []int{0, 1}) || !reflect.DeepEqual(f.rolledBack, []int{0}) {
+		t.Errorf("Canary failed to update should be rolled back, results: %v, rolled back: %v", results, f.rolledBack)
+	}
+
+	// Roll back once the soak is aborted by the context.
+	f = &fakeCanaryTasks{progs: map[int][]*supervisor.Program{0: {ready(100)}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	results = f.newCanary(CanaryOptions{Soak: time.Hour}, 2).execute(ctx)
+	if !reflect.DeepEqual(failedTasks(results), []int{0, 1}) || !reflect.DeepEqual(f.rolledBack, []int{0}) {
+		t.Errorf("Aborted canary should be rolled back, results: %v, rolled back: %v", results, f.rolledBack)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Soak should be aborted once the context is done, elapsed: %v", elapsed)
+	}
+}
@@ -2,7 +2,9 @@ package core
 
 import (
 	"fmt"
+	"github.com/openinx/huker/pkg/utils"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -10,11 +12,70 @@ import (
 // The abstract functions for configuration file.
 type ConfigFile interface {
 	mergeWith(c ConfigFile) ConfigFile
+	// Return a new configuration file with every line rendered by the function.
+	render(fn func(string) (string, error)) (ConfigFile, error)
 	ToString() string
 	ToKeyValue() map[string]string
 	GetConfigName() string
 }
 
+func splitKeyValue(kv string) (string, string) {
+	parts := strings.SplitN(kv, "=", 2)
+	if len(parts) != 2 {
+		panic(fmt.Sprintf("Invalid key value pair, key or value not found. %s", kv))
+	}
+	return parts[0], parts[1]
+}
+
+// Return the keys in the order of their first declaration, and the last declared value of each key.
+func orderedKeyValues(keyValues []string) ([]string, map[string]string) {
+	var keys []string
+	kvMap := make(map[string]string)
+	for _, kv := range keyValues {
+		key, val := splitKeyValue(kv)
+		if _, ok := kvMap[key]; !ok {
+			keys = append(keys, key)
+		}
+		kvMap[key] = val
+	}
+	return keys, kvMap
+}
+
+// Merge the key-values with the ones of parent, the keys of parent come first and the keys declared by both
+// will be overridden in place.
+func mergeKeyValues(this, parent []string) []string {
+	keys, kvMap := orderedKeyValues(this)
+	parentKeys, parentMap := orderedKeyValues(parent)
+	merged := make(map[string]bool)
+	keyValues := []string{}
+	for _, key := range parentKeys {
+		val, ok := kvMap[key]
+		if !ok {
+			val = parentMap[key]
+		}
+		keyValues = append(keyValues, fmt.Sprintf("%s=%s", key, val))
+		merged[key] = true
+	}
+	for _, key := range keys {
+		if !merged[key] {
+			keyValues = append(keyValues, fmt.Sprintf("%s=%s", key, kvMap[key]))
+		}
+	}
+	return keyValues
+}
+
+func renderLines(lines []string, fn func(string) (string, error)) ([]string, error) {
+	rendered := []string{}
+	for _, line := range lines {
+		newLine, err := fn(line)
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, newLine)
+	}
+	return rendered, nil
+}
+
 // Configuration file with .ini, .properties
 type INIConfigFile struct {
 	cfgName   string
@@ -30,80 +91,143 @@ func NewINIConfigFile(cfgName string, keyValues []string) INIConfigFile {
 }
 
 func (c INIConfigFile) mergeWith(other ConfigFile) ConfigFile {
-	cMap := c.ToKeyValue()
-	oMap := other.ToKeyValue()
-	// If key exist in both cMap and oMap, then use value of cMap.
-	for key, val := range cMap {
-		oMap[key] = val
+	var otherKeyValues []string
+	if o, ok := other.(INIConfigFile); ok {
+		otherKeyValues = o.keyValues
+	} else {
+		otherKeyValues = sortedKeyValues(other.ToKeyValue())
 	}
-	// convert oMap to []string
-	keyValues := []string{}
-	for key, val := range oMap {
-		keyValues = append(keyValues, fmt.Sprintf("%s=%s", key, val))
+	// If key exist in both c and other, then use value of c.
+	c.keyValues = mergeKeyValues(c.keyValues, otherKeyValues)
+	return c
+}
+
+func (c INIConfigFile) render(fn func(string) (string, error)) (ConfigFile, error) {
+	keyValues, err := renderLines(c.keyValues, fn)
+	if err != nil {
+		return nil, err
 	}
 	c.keyValues = keyValues
-	return c
+	return c, nil
 }
 
 func (c INIConfigFile) ToString() string {
-	return strings.Join(c.keyValues, "\n")
+	keys, kvMap := orderedKeyValues(c.keyValues)
+	var buf []string
+	for _, key := range keys {
+		buf = append(buf, fmt.Sprintf("%s=%s", key, kvMap[key]))
+	}
+	return strings.Join(buf, "\n")
 }
 
 func (c INIConfigFile) ToKeyValue() map[string]string {
-	ret := make(map[string]string)
-	for i := range c.keyValues {
-		parts := strings.SplitN(c.keyValues[i], "=", 2)
-		if len(parts) != 2 {
-			panic(fmt.Sprintf("Invalid key value pair, key or value not found. %s", c.keyValues[i]))
-		}
-		ret[parts[0]] = parts[1]
-	}
-	return ret
+	_, kvMap := orderedKeyValues(c.keyValues)
+	return kvMap
 }
 
 func (c INIConfigFile) GetConfigName() string {
 	return c.cfgName
 }
 
+// Sort the key-values by key, for the configuration file whose declaration order is unknown.
+func sortedKeyValues(kvMap map[string]string) []string {
+	var keys []string
+	for key := range kvMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	keyValues := []string{}
+	for _, key := range keys {
+		keyValues = append(keyValues, fmt.Sprintf("%s=%s", key, kvMap[key]))
+	}
+	return keyValues
+}
+
+// The optional attributes of a property in xml configuration file.
+type XMLProperty struct {
+	Final       bool
+	Description string
+}
+
 // Configuration file with xml format
 type XMLConfigFile struct {
-	cfgName   string
-	keyValues []string
+	cfgName    string
+	keyValues  []string
+	properties map[string]*XMLProperty
 }
 
 // Create a new xml config files.
 func NewXMLConfigFile(cfgName string, keyValues []string) XMLConfigFile {
 	return XMLConfigFile{
-		cfgName:   cfgName,
-		keyValues: keyValues,
+		cfgName:    cfgName,
+		keyValues:  keyValues,
+		properties: make(map[string]*XMLProperty),
 	}
 }
 
 func (c XMLConfigFile) mergeWith(other ConfigFile) ConfigFile {
-	cMap := c.ToKeyValue()
-	oMap := other.ToKeyValue()
-	// If key exist in both cMap and oMap, the use value of cMap.
-	for key, val := range cMap {
-		oMap[key] = val
+	properties := make(map[string]*XMLProperty)
+	var otherKeyValues []string
+	if o, ok := other.(XMLConfigFile); ok {
+		otherKeyValues = o.keyValues
+		for key, prop := range o.properties {
+			properties[key] = prop
+		}
+	} else {
+		otherKeyValues = sortedKeyValues(other.ToKeyValue())
 	}
-	// convert oMap to []string
-	keyValues := []string{}
-	for key, val := range oMap {
-		keyValues = append(keyValues, fmt.Sprintf("%s=%s", key, val))
+	// If key exist in both c and other, the use value and attributes of c.
+	for key, prop := range c.properties {
+		properties[key] = prop
 	}
-	c.keyValues = keyValues
+	c.keyValues = mergeKeyValues(c.keyValues, otherKeyValues)
+	c.properties = properties
 	return c
 }
 
+func (c XMLConfigFile) render(fn func(string) (string, error)) (ConfigFile, error) {
+	properties := make(map[string]*XMLProperty)
+	var keyValues []string
+	for _, kv := range c.keyValues {
+		newKv, err := fn(kv)
+		if err != nil {
+			return nil, err
+		}
+		keyValues = append(keyValues, newKv)
+		key, _ := splitKeyValue(kv)
+		if prop, ok := c.properties[key]; ok {
+			description, err := fn(prop.Description)
+			if err != nil {
+				return nil, err
+			}
+			newKey, _ := splitKeyValue(newKv)
+			properties[newKey] = &XMLProperty{Final: prop.Final, Description: description}
+		}
+	}
+	c.keyValues = keyValues
+	c.properties = properties
+	return c, nil
+}
+
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
 func (c XMLConfigFile) ToString() string {
 	var buf []string
 	buf = append(buf, "<configuration>")
 
-	kvMap := c.ToKeyValue()
-	for key := range kvMap {
+	keys, kvMap := orderedKeyValues(c.keyValues)
+	for _, key := range keys {
 		buf = append(buf, "  <property>")
-		buf = append(buf, fmt.Sprintf("    <name>%s</name>", key))
-		buf = append(buf, fmt.Sprintf("    <value>%s</value>", kvMap[key]))
+		buf = append(buf, fmt.Sprintf("    <name>%s</name>", xmlEscaper.Replace(key)))
+		buf = append(buf, fmt.Sprintf("    <value>%s</value>", xmlEscaper.Replace(kvMap[key])))
+		if prop, ok := c.properties[key]; ok {
+			if prop.Final {
+				buf = append(buf, "    <final>true</final>")
+			}
+			if prop.Description != "" {
+				buf = append(buf, fmt.Sprintf("    <description>%s</description>", xmlEscaper.Replace(prop.Description)))
+			}
+		}
 		buf = append(buf, "  </property>")
 	}
 	buf = append(buf, "</configuration>")
@@ -112,15 +236,8 @@ func (c XMLConfigFile) ToString() string {
 }
 
 func (c XMLConfigFile) ToKeyValue() map[string]string {
-	ret := make(map[string]string)
-	for i := range c.keyValues {
-		parts := strings.SplitN(c.keyValues[i], "=", 2)
-		if len(parts) != 2 {
-			panic(fmt.Sprintf("Invalid key value pair, key or value not found. %s", c.keyValues[i]))
-		}
-		ret[parts[0]] = parts[1]
-	}
-	return ret
+	_, kvMap := orderedKeyValues(c.keyValues)
+	return kvMap
 }
 
 func (c XMLConfigFile) GetConfigName() string {
@@ -142,12 +259,25 @@ func NewPlainConfigFile(cfgName string, lines []string) PlainConfigFile {
 }
 
 func (c PlainConfigFile) mergeWith(other ConfigFile) ConfigFile {
-	for _, line := range other.ToKeyValue() {
-		c.lines = append(c.lines, line)
+	lines := append([]string{}, c.lines...)
+	if o, ok := other.(PlainConfigFile); ok {
+		lines = append(lines, o.lines...)
+	} else {
+		lines = append(lines, strings.Split(other.ToString(), "\n")...)
 	}
+	c.lines = lines
 	return c
 }
 
+func (c PlainConfigFile) render(fn func(string) (string, error)) (ConfigFile, error) {
+	lines, err := renderLines(c.lines, fn)
+	if err != nil {
+		return nil, err
+	}
+	c.lines = lines
+	return c, nil
+}
+
 func (c PlainConfigFile) ToString() string {
 	var buf []string
 	for _, line := range c.lines {
@@ -168,15 +298,135 @@ func (c PlainConfigFile) GetConfigName() string {
 	return c.cfgName
 }
 
+// Every line of the key-value based configuration file should be format like: <key>=<value>.
+func checkKeyValues(cfgName string, keyValues []string) error {
+	for _, kv := range keyValues {
+		if !strings.Contains(kv, "=") {
+			return fmt.Errorf("Invalid key value pair in %s, should be format like: <key>=<value>. %s", cfgName, kv)
+		}
+	}
+	return nil
+}
+
+// True if every line of the configuration file is a <key>=<value> pair, such as .xml, .cfg and .properties.
+func isKeyValueConfig(cfgName string) bool {
+	switch filepath.Ext(cfgName) {
+	case ".cfg", ".properties", ".conf", ".xml", ".sh", ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// Parse the property of xml configuration file declared as a map, such as:
+//
+//	{name: dfs.permissions.enabled, value: false, final: true, description: Disable the permission check}
+func parseXMLProperty(m map[interface{}]interface{}) (string, string, *XMLProperty, error) {
+	var name, value string
+	prop := &XMLProperty{}
+	for key, obj := range m {
+		switch key {
+		case "name":
+			if !utils.IsStringType(obj) {
+				return "", "", nil, fmt.Errorf("`name` of property should be a string. %v", m)
+			}
+			name = obj.(string)
+		case "value":
+			if obj != nil {
+				value = fmt.Sprintf("%v", obj)
+			}
+		case "final":
+			final, ok := obj.(bool)
+			if !ok {
+				return "", "", nil, fmt.Errorf("`final` of property should be a bool. %v", m)
+			}
+			prop.Final = final
+		case "description":
+			if !utils.IsStringType(obj) {
+				return "", "", nil, fmt.Errorf("`description` of property should be a string. %v", m)
+			}
+			prop.Description = obj.(string)
+		default:
+			return "", "", nil, fmt.Errorf("Unknown field `%v` of property. %v", key, m)
+		}
+	}
+	if name == "" {
+		return "", "", nil, fmt.Errorf("`name` of property is required. %v", m)
+	}
+	return name, value, prop, nil
+}
+
+// Parse the configuration file from the yaml items, the property of xml configuration file can be either a
+// <key>=<value> string or a map with the optional final and description.
+func parseConfigItems(cfgName string, obj interface{}) (ConfigFile, error) {
+	items, ok := obj.([]interface{})
+	if !ok {
+		keyValues, err := ParseStringArray(obj)
+		if err != nil {
+			return nil, err
+		}
+		return ParseConfigFile(cfgName, keyValues)
+	}
+	var keyValues []string
+	properties := make(map[string]*XMLProperty)
+	for _, item := range items {
+		m, ok := item.(map[interface{}]interface{})
+		if !ok {
+			kvs, err := ParseStringArray([]interface{}{item})
+			if err != nil {
+				return nil, err
+			}
+			keyValues = append(keyValues, kvs...)
+			continue
+		}
+		if filepath.Ext(cfgName) != ".xml" {
+			return nil, fmt.Errorf("Property declared as a map is only supported by xml configuration file. %s", cfgName)
+		}
+		name, value, prop, err := parseXMLProperty(m)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid property in %s: %v", cfgName, err)
+		}
+		keyValues = append(keyValues, fmt.Sprintf("%s=%s", name, value))
+		properties[name] = prop
+	}
+	cf, err := ParseConfigFile(cfgName, keyValues)
+	if err != nil {
+		return nil, err
+	}
+	if xmlCf, ok := cf.(XMLConfigFile); ok {
+		xmlCf.properties = properties
+		return xmlCf, nil
+	}
+	return cf, nil
+}
+
 // Initialize the concrete configuration file by the suffix of cfgName.
 func ParseConfigFile(cfgName string, keyValues []string) (ConfigFile, error) {
 	fname := filepath.Base(cfgName)
-	if strings.HasSuffix(fname, ".cfg") || strings.HasSuffix(fname, ".properties") || strings.HasSuffix(fname, ".conf") {
-		return NewINIConfigFile(cfgName, keyValues), nil
-	} else if strings.HasSuffix(fname, ".xml") {
-		return NewXMLConfigFile(cfgName, keyValues), nil
+	if strings.HasSuffix(fname, ".tmpl") {
+		return NewTemplateConfigFile(cfgName, keyValues), nil
 	} else if !strings.Contains(fname, ".") || strings.HasSuffix(fname, ".txt") {
 		return NewPlainConfigFile(cfgName, keyValues), nil
+	} else if !isKeyValueConfig(fname) {
+		return nil, fmt.Errorf("Unsupported configuration file format. %s", cfgName)
+	}
+	if err := checkKeyValues(cfgName, keyValues); err != nil {
+		return nil, err
+	}
+	switch filepath.Ext(fname) {
+	case ".xml":
+		return NewXMLConfigFile(cfgName, keyValues), nil
+	case ".sh":
+		for _, kv := range keyValues {
+			if key, _ := splitKeyValue(kv); !envKeyPattern.MatchString(key) {
+				return nil, fmt.Errorf("Invalid environment variable name in %s: %s", cfgName, key)
+			}
+		}
+		return NewEnvConfigFile(cfgName, keyValues), nil
+	case ".yaml", ".yml", ".json":
+		if err := checkStructuredKeys(cfgName, keyValues); err != nil {
+			return nil, err
+		}
+		return NewStructuredConfigFile(cfgName, keyValues, filepath.Ext(fname) == ".json"), nil
 	}
-	return nil, fmt.Errorf("Unsupported configuration file format. %s", cfgName)
+	return NewINIConfigFile(cfgName, keyValues), nil
 }
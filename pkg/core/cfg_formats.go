@@ -0,0 +1,328 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/go-yaml/yaml"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Configuration file with shell environment variables, such as hadoop-env.sh. Every <key>=<value> pair will be
+// rendered as a line: export <key>=<value>
+type EnvConfigFile struct {
+	cfgName   string
+	keyValues []string
+}
+
+var envKeyPattern = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// Create a new shell environment config file.
+func NewEnvConfigFile(cfgName string, keyValues []string) EnvConfigFile {
+	return EnvConfigFile{
+		cfgName:   cfgName,
+		keyValues: keyValues,
+	}
+}
+
+func (c EnvConfigFile) mergeWith(other ConfigFile) ConfigFile {
+	var otherKeyValues []string
+	if o, ok := other.(EnvConfigFile); ok {
+		otherKeyValues = o.keyValues
+	} else {
+		otherKeyValues = sortedKeyValues(other.ToKeyValue())
+	}
+	c.keyValues = mergeKeyValues(c.keyValues, otherKeyValues)
+	return c
+}
+
+func (c EnvConfigFile) render(fn func(string) (string, error)) (ConfigFile, error) {
+	keyValues, err := renderLines(c.keyValues, fn)
+	if err != nil {
+		return nil, err
+	}
+	c.keyValues = keyValues
+	return c, nil
+}
+
+func (c EnvConfigFile) ToString() string {
+	keys, kvMap := orderedKeyValues(c.keyValues)
+	var buf []string
+	for _, key := range keys {
+		buf = append(buf, fmt.Sprintf("export %s=%s", key, kvMap[key]))
+	}
+	return strings.Join(buf, "\n")
+}
+
+func (c EnvConfigFile) ToKeyValue() map[string]string {
+	_, kvMap := orderedKeyValues(c.keyValues)
+	return kvMap
+}
+
+func (c EnvConfigFile) GetConfigName() string {
+	return c.cfgName
+}
+
+// Configuration file with yaml or json format. The key of every <key>=<value> pair is a dot-separated path of
+// the nested maps, and the value will be decoded as json if possible, so numbers, booleans and lists keep their
+// types. For example, server.port=8080 will be rendered as:
+//
+//	server:
+//	  port: 8080
+type StructuredConfigFile struct {
+	cfgName   string
+	keyValues []string
+	isJSON    bool
+}
+
+// Create a new yaml or json config file.
+func NewStructuredConfigFile(cfgName string, keyValues []string, isJSON bool) StructuredConfigFile {
+	return StructuredConfigFile{
+		cfgName:   cfgName,
+		keyValues: keyValues,
+		isJSON:    isJSON,
+	}
+}
+
+func (c StructuredConfigFile) mergeWith(other ConfigFile) ConfigFile {
+	var otherKeyValues []string
+	if o, ok := other.(StructuredConfigFile); ok {
+		otherKeyValues = o.keyValues
+	} else {
+		otherKeyValues = sortedKeyValues(other.ToKeyValue())
+	}
+	c.keyValues = mergeKeyValues(c.keyValues, otherKeyValues)
+	return c
+}
+
+func (c StructuredConfigFile) render(fn func(string) (string, error)) (ConfigFile, error) {
+	keyValues, err := renderLines(c.keyValues, fn)
+	if err != nil {
+		return nil, err
+	}
+	c.keyValues = keyValues
+	return c, nil
+}
+
+func parseStructuredValue(val string) interface{} {
+	var obj interface{}
+	if err := json.Unmarshal([]byte(val), &obj); err == nil {
+		return obj
+	}
+	return val
+}
+
+// Build the nested maps in declaration order, the later declared key will replace the conflicting one.
+func (c StructuredConfigFile) toMapSlice() yaml.MapSlice {
+	root := yaml.MapSlice{}
+	keys, kvMap := orderedKeyValues(c.keyValues)
+	for _, key := range keys {
+		root = setMapSlice(root, strings.Split(key, "."), parseStructuredValue(kvMap[key]))
+	}
+	return root
+}
+
+func setMapSlice(m yaml.MapSlice, path []string, val interface{}) yaml.MapSlice {
+	for i := range m {
+		if m[i].Key != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			m[i].Value = val
+		} else {
+			child, _ := m[i].Value.(yaml.MapSlice)
+			m[i].Value = setMapSlice(child, path[1:], val)
+		}
+		return m
+	}
+	if len(path) == 1 {
+		return append(m, yaml.MapItem{Key: path[0], Value: val})
+	}
+	return append(m, yaml.MapItem{Key: path[0], Value: setMapSlice(yaml.MapSlice{}, path[1:], val)})
+}
+
+// Encode the ordered map as json, since encoding/json will sort the keys of map.
+func writeJSON(buf *bytes.Buffer, obj interface{}) {
+	m, ok := obj.(yaml.MapSlice)
+	if !ok {
+		data, err := json.Marshal(obj)
+		if err != nil {
+			data, _ = json.Marshal(fmt.Sprintf("%v", obj))
+		}
+		buf.Write(data)
+		return
+	}
+	buf.WriteString("{")
+	for i, item := range m {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key, _ := json.Marshal(item.Key)
+		buf.Write(key)
+		buf.WriteString(":")
+		writeJSON(buf, item.Value)
+	}
+	buf.WriteString("}")
+}
+
+func (c StructuredConfigFile) ToString() string {
+	root := c.toMapSlice()
+	if c.isJSON {
+		var buf, out bytes.Buffer
+		writeJSON(&buf, root)
+		if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+			return buf.String()
+		}
+		return out.String()
+	}
+	if len(root) == 0 {
+		return ""
+	}
+	data, err := yaml.Marshal(root)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to marshal %s: %v", c.cfgName, err))
+	}
+	return strings.TrimSuffix(string(data), "\n")
+}
+
+func (c StructuredConfigFile) ToKeyValue() map[string]string {
+	_, kvMap := orderedKeyValues(c.keyValues)
+	return kvMap
+}
+
+func (c StructuredConfigFile) GetConfigName() string {
+	return c.cfgName
+}
+
+// Check that no key is both a value and a parent of other keys, such as a=1 and a.b=2.
+func checkStructuredKeys(cfgName string, keyValues []string) error {
+	keys, _ := orderedKeyValues(keyValues)
+	keySet := make(map[string]bool)
+	for _, key := range keys {
+		keySet[key] = true
+	}
+	for _, key := range keys {
+		path := strings.Split(key, ".")
+		for i := 1; i < len(path); i++ {
+			if parent := strings.Join(path[:i], "."); keySet[parent] {
+				return fmt.Errorf("Key `%s` conflicts with key `%s` in %s", key, parent, cfgName)
+			}
+		}
+	}
+	return nil
+}
+
+// Configuration file with go template format, such as zoo.cfg.tmpl, which will be written as zoo.cfg. The lines are
+// joined as the template, and the placeholders can be used in the lines directly or by the template function `ph`:
+//
+//	dataDir={{.PkgDataDir}}
+//	<% range split (ph "zkServer.server_list") "," %>server=<% . %>
+//	<% end %>
+//
+// The actions are delimited by <% and %>, because the {{ }} ones are the environment variables of yaml such as
+// {{.PkgDataDir}}, which are kept as they are. The templates of super_job are parsed before the job's own, so a job
+// can override the blocks defined by <% define %> of its super_job, and the whole content if its own template is not
+// empty.
+type TemplateConfigFile struct {
+	cfgName  string
+	sources  []string
+	rendered bool
+	output   string
+}
+
+// Create a new go template config file.
+func NewTemplateConfigFile(cfgName string, lines []string) TemplateConfigFile {
+	return TemplateConfigFile{
+		cfgName: cfgName,
+		sources: []string{strings.Join(lines, "\n")},
+	}
+}
+
+func (c TemplateConfigFile) mergeWith(other ConfigFile) ConfigFile {
+	var sources []string
+	if o, ok := other.(TemplateConfigFile); ok {
+		sources = append(sources, o.sources...)
+	} else {
+		sources = append(sources, other.ToString())
+	}
+	c.sources = append(sources, c.sources...)
+	return c
+}
+
+// The functions of template, ph is used to evaluate the placeholder expression.
+func templateFuncs(ph func(string) (string, error)) template.FuncMap {
+	return template.FuncMap{
+		"ph":    ph,
+		"split": strings.Split,
+		"join":  strings.Join,
+	}
+}
+
+// Delimiters of the template actions.
+const (
+	templateLeftDelim  = "<%"
+	templateRightDelim = "%>"
+)
+
+func (c TemplateConfigFile) parse(funcs template.FuncMap) (*template.Template, error) {
+	tmpl := template.New(c.cfgName).Delims(templateLeftDelim, templateRightDelim).Funcs(funcs).
+		Option("missingkey=error")
+	for _, src := range c.sources {
+		if _, err := tmpl.Parse(src); err != nil {
+			return nil, fmt.Errorf("Invalid template %s: %v", c.cfgName, err)
+		}
+	}
+	return tmpl, nil
+}
+
+func (c TemplateConfigFile) render(fn func(string) (string, error)) (ConfigFile, error) {
+	var sources []string
+	for _, src := range c.sources {
+		newSrc, err := fn(src)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, newSrc)
+	}
+	c.sources = sources
+	tmpl, err := c.parse(templateFuncs(func(expr string) (string, error) {
+		return fn("%{" + expr + "}")
+	}))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	// No data is given to the template, so any field like <% .PkgDataDir %> fails instead of rendering <no value>.
+	if err := tmpl.Execute(&buf, map[string]interface{}{}); err != nil {
+		return nil, fmt.Errorf("Failed to execute template %s: %v", c.cfgName, err)
+	}
+	c.rendered, c.output = true, buf.String()
+	return c, nil
+}
+
+func (c TemplateConfigFile) ToString() string {
+	if c.rendered {
+		return c.output
+	}
+	return strings.Join(c.sources, "\n")
+}
+
+func (c TemplateConfigFile) ToKeyValue() map[string]string {
+	ret := make(map[string]string)
+	for i, src := range c.sources {
+		ret[strconv.Itoa(i)] = src
+	}
+	return ret
+}
+
+func (c TemplateConfigFile) GetConfigName() string {
+	return c.cfgName
+}
+
+// The name of file which the config will be written as, the .tmpl suffix will be trimmed.
+func renderedConfigName(cfgName string) string {
+	return strings.TrimSuffix(cfgName, ".tmpl")
+}
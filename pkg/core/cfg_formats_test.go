@@ -0,0 +1,121 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvConfigFile(t *testing.T) {
+	parent, err := ParseConfigFile("hadoop-env.sh", []string{"JAVA_HOME=/opt/java", "HADOOP_HEAPSIZE=1024"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	this, err := ParseConfigFile("hadoop-env.sh", []string{"HADOOP_OPTS=\"-Xmx1g $HADOOP_OPTS\"", "HADOOP_HEAPSIZE=2048"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "export JAVA_HOME=/opt/java\nexport HADOOP_HEAPSIZE=2048\nexport HADOOP_OPTS=\"-Xmx1g $HADOOP_OPTS\""
+	if merged := this.mergeWith(parent).ToString(); merged != expected {
+		t.Errorf("Env config mismatch, `%s` != `%s`", merged, expected)
+	}
+	if _, err := ParseConfigFile("hadoop-env.sh", []string{"INVALID-KEY=1"}); err == nil {
+		t.Errorf("Invalid environment variable name should be failed.")
+	}
+}
+
+func TestStructuredConfigFile(t *testing.T) {
+	parent, err := ParseConfigFile("application.yaml", []string{"server.port=8080", "server.host=0.0.0.0", "debug=false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	this, err := ParseConfigFile("application.yaml", []string{"server.port=%{self.base_port}", "peers=[\"a\", \"b\"]", "name=a: b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := this.mergeWith(parent).render(func(s string) (string, error) {
+		return strings.Replace(s, "%{self.base_port}", "9090", -1), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "server:\n  port: 9090\n  host: 0.0.0.0\ndebug: false\npeers:\n- a\n- b\nname: 'a: b'"
+	if merged.ToString() != expected {
+		t.Errorf("Yaml config mismatch, `%s` != `%s`", merged.ToString(), expected)
+	}
+
+	cf, err := ParseConfigFile("config.json", []string{"b.y=2", "b.x=true", "a=text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "{\n  \"b\": {\n    \"y\": 2,\n    \"x\": true\n  },\n  \"a\": \"text\"\n}"
+	if cf.ToString() != expected {
+		t.Errorf("Json config mismatch, `%s` != `%s`", cf.ToString(), expected)
+	}
+
+	if _, err := ParseConfigFile("config.json", []string{"a=1", "a.b=2"}); err == nil {
+		t.Errorf("Conflicting keys should be failed.")
+	}
+}
+
+func TestTemplateConfigFile(t *testing.T) {
+	parent, err := ParseConfigFile("zoo.cfg.tmpl", []string{
+		`<% define "ports" %>clientPort=%{self.base_port}<% end -%>`,
+		`dataDir={{.PkgDataDir}}`,
+		`<% template "ports" %>`,
+		`<% range $i, $s := split (ph "zkServer.server_list") "," %>server.<% $i %>=<% $s %>`,
+		`<% end %>`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	this, err := ParseConfigFile("zoo.cfg.tmpl", []string{`<% define "ports" %>clientPort=2000<% end %>`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := func(s string) (string, error) {
+		s = strings.Replace(s, "%{self.base_port}", "2181", -1)
+		return strings.Replace(s, "%{zkServer.server_list}", "h1:2181,h2:2181", -1), nil
+	}
+	rendered, err := parent.render(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "dataDir={{.PkgDataDir}}\nclientPort=2181\nserver.0=h1:2181\nserver.1=h2:2181\n"
+	if rendered.ToString() != expected {
+		t.Errorf("Template config mismatch, `%s` != `%s`", rendered.ToString(), expected)
+	}
+
+	// The block defined by super_job is overridden.
+	rendered, err = this.mergeWith(parent).render(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "dataDir={{.PkgDataDir}}\nclientPort=2000\nserver.0=h1:2181\nserver.1=h2:2181\n"
+	if rendered.ToString() != expected {
+		t.Errorf("Template config mismatch, `%s` != `%s`", rendered.ToString(), expected)
+	}
+	if renderedConfigName("zoo.cfg.tmpl") != "zoo.cfg" {
+		t.Errorf("Rendered config name mismatch: %s", renderedConfigName("zoo.cfg.tmpl"))
+	}
+
+	// The actions are kept by the environment variables rendering of yaml.
+	line := `<% range split (ph "zkServer.server_list") "," %>dataDir={{.PkgDataDir}}<% end %>`
+	e := &EnvVariables{PkgDataDir: "/data"}
+	if ret, err := e.RenderTemplate(line); err != nil || ret != `<% range split (ph "zkServer.server_list") "," %>dataDir=/data<% end %>` {
+		t.Errorf("Template actions should be kept by yaml rendering, ret: %s, err: %v", ret, err)
+	}
+
+	// The fields are agent variables of yaml, which should not be used by the template actions.
+	missing, _ := ParseConfigFile("zoo.cfg.tmpl", []string{"dataDir=<% .PkgDataDir %>"})
+	if _, err := missing.render(fn); err == nil {
+		t.Errorf("Template with missing key should be failed.")
+	}
+
+	invalid, _ := ParseConfigFile("zoo.cfg.tmpl", []string{"<% if %>"})
+	if _, err := invalid.render(fn); err == nil {
+		t.Errorf("Invalid template should be failed.")
+	}
+	if _, err := invalid.(TemplateConfigFile).parse(templateFuncs(nil)); err == nil {
+		t.Errorf("Invalid template should be failed to parse.")
+	}
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -47,5 +48,76 @@ func TestConfigFile(t *testing.T) {
 }
 
 func TestMergeWith(t *testing.T) {
-	// TODO unit test for this.
+	testCases := []struct {
+		cfgName       string
+		this          []string
+		parent        []string
+		configuration string
+	}{
+		{"zoo.cfg", []string{"c=3", "a=10", "d=4"}, []string{"a=1", "b=2", "c=3"}, "a=10\nb=2\nc=3\nd=4"},
+		{"zoo.cfg", []string{"a=1", "a=2"}, []string{}, "a=2"},
+		{"test.xml", []string{"b=20"}, []string{"a=1", "b=2"},
+			"<configuration>\n  <property>\n    <name>a</name>\n    <value>1</value>\n  </property>\n" +
+				"  <property>\n    <name>b</name>\n    <value>20</value>\n  </property>\n</configuration>"},
+		{"myid", []string{"1"}, []string{"2", "3"}, "1\n2\n3"},
+	}
+	for caseId, cas := range testCases {
+		this, err := ParseConfigFile(cas.cfgName, cas.this)
+		if err != nil {
+			t.Fatalf("TestCase #%d failed, cause: %v", caseId, err)
+		}
+		parent, err := ParseConfigFile(cas.cfgName, cas.parent)
+		if err != nil {
+			t.Fatalf("TestCase #%d failed, cause: %v", caseId, err)
+		}
+		// Render for many times to make sure the order is deterministic.
+		for i := 0; i < 10; i++ {
+			if merged := this.mergeWith(parent).ToString(); merged != cas.configuration {
+				t.Fatalf("TestCase #%d failed, `%s` != `%s`", caseId, merged, cas.configuration)
+			}
+		}
+	}
+}
+
+func TestXMLProperty(t *testing.T) {
+	items := []interface{}{
+		"a=x&y<z",
+		map[interface{}]interface{}{"name": "b", "value": false, "final": true, "description": "Use <b> & %{cluster.name}"},
+		"c=%{cluster.name}",
+	}
+	cf, err := parseConfigItems("test.xml", items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err = cf.render(func(s string) (string, error) {
+		return strings.Replace(s, "%{cluster.name}", "a&b", -1), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"<configuration>",
+		"  <property>", "    <name>a</name>", "    <value>x&amp;y&lt;z</value>", "  </property>",
+		"  <property>", "    <name>b</name>", "    <value>false</value>", "    <final>true</final>",
+		"    <description>Use &lt;b&gt; &amp; a&amp;b</description>", "  </property>",
+		"  <property>", "    <name>c</name>", "    <value>a&amp;b</value>", "  </property>",
+		"</configuration>",
+	}, "\n")
+	if cf.ToString() != expected {
+		t.Errorf("XML configuration mismatch, `%s` != `%s`", cf.ToString(), expected)
+	}
+
+	// The overridden property will keep the attributes of parent.
+	this, _ := parseConfigItems("test.xml", []interface{}{"b=true"})
+	merged := this.mergeWith(cf).(XMLConfigFile)
+	if !merged.properties["b"].Final || merged.ToKeyValue()["b"] != "true" {
+		t.Errorf("Attributes of property should be inherited: %s", merged.ToString())
+	}
+
+	if _, err := parseConfigItems("zoo.cfg", items); err == nil {
+		t.Errorf("Property declared as a map should be failed for non-xml configuration file.")
+	}
+	if _, err := parseConfigItems("test.xml", []interface{}{map[interface{}]interface{}{"value": "1"}}); err == nil {
+		t.Errorf("Property without name should be failed.")
+	}
 }
@@ -5,18 +5,25 @@ import (
 	"github.com/go-yaml/yaml"
 	"github.com/openinx/huker/pkg/utils"
 	"io/ioutil"
+	"sort"
+	"strings"
 )
 
 // Cluster definition.
 type Cluster struct {
+	ConfigPath    string // Path of the yaml file which defines the cluster, empty if not loaded from file.
 	BaseConfig    string
 	Project       string
 	ClusterName   string
 	MainProcess   string
+	ProcessType   string // Process type of jobs without their own process_type.
 	PackageName   string
 	PackageMd5sum string
 	Jobs          map[string]*Job
 	Dependencies  []*Cluster
+	// Alias of each dependency, empty if not declared. So the dependency can be referenced by either its alias or
+	// its cluster name, such as %{deps.zk.zkServer.server_list}.
+	DependencyAliases []string
 }
 
 func getRequiredField(m map[interface{}]interface{}, key string) (string, error) {
@@ -65,6 +72,12 @@ func NewCluster(yamlConfigs []string, e *EnvVariables) (*Cluster, error) {
 	if c.MainProcess, err = getRequiredField(clusterMap, "main_process"); err != nil {
 		return nil, err
 	}
+	c.ProcessType = guessProcessType(c.MainProcess)
+	if obj, ok := clusterMap["process_type"]; ok && obj != nil {
+		if c.ProcessType, err = parseProcessType(obj); err != nil {
+			return nil, err
+		}
+	}
 	if c.PackageName, err = getRequiredField(clusterMap, "package_name"); err != nil {
 		return nil, err
 	}
@@ -72,15 +85,17 @@ func NewCluster(yamlConfigs []string, e *EnvVariables) (*Cluster, error) {
 		return nil, err
 	}
 	// Read `dependencies` section.
-	dependencies, err := ParseStringArray(clusterMap["dependencies"])
-	if err == nil && len(dependencies) > 0 {
-		for _, dep := range dependencies {
-			depCluster, err := LoadClusterConfig(dep, e)
-			if err != nil {
-				return nil, err
-			}
-			c.Dependencies = append(c.Dependencies, depCluster)
+	aliases, dependencies, err := parseDependencies(clusterMap["dependencies"])
+	if err != nil {
+		return nil, err
+	}
+	for i, dep := range dependencies {
+		depCluster, err := LoadClusterConfig(dep, e)
+		if err != nil {
+			return nil, fmt.Errorf("Load dependency `%s` failed: %v", dep, err)
 		}
+		c.Dependencies = append(c.Dependencies, depCluster)
+		c.DependencyAliases = append(c.DependencyAliases, aliases[i])
 	}
 
 	// Read `jobs` section.
@@ -100,77 +115,213 @@ func NewCluster(yamlConfigs []string, e *EnvVariables) (*Cluster, error) {
 			return nil, fmt.Errorf("Job `%s` section should be a map, %v", jobName, jobMap)
 		}
 		if job, err := NewJob(jobName.(string), jobMap.(map[interface{}]interface{})); err != nil {
-			return nil, err
+			return nil, fmt.Errorf("Invalid job `%s`: %v", jobName, err)
 		} else {
 			c.Jobs[jobName.(string)] = job
 		}
 	}
 
-	// Merge job with its parent job. Be careful that we only allow one layer of inheritance.
-	// The case A -> B -> C is not allowed.
-	newJobs := make(map[string]*Job)
-	for jobName, job := range c.Jobs {
-		if parentJob, ok := c.Jobs[job.SuperJob]; ok && parentJob != nil {
+	// Merge every job with its ancestors, the chain such as A -> B -> C is allowed.
+	if c.Jobs, err = resolveSuperJobs(c.Jobs); err != nil {
+		return nil, err
+	}
+	for _, job := range c.Jobs {
+		if job.ProcessType == "" {
+			job.ProcessType = c.ProcessType
+		}
+		if err := getProcessType(job.ProcessType).validate(job); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := c.JobOrder(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Parse the `dependencies` section, each dependency is either a path or a single-entry map from alias to path:
+//
+//	dependencies:
+//	  - /path/to/hdfs.yaml
+//	  - zk: /path/to/zookeeper.yaml
+func parseDependencies(obj interface{}) ([]string, []string, error) {
+	if obj == nil {
+		return nil, nil, nil
+	}
+	if !utils.IsSliceType(obj) {
+		return nil, nil, fmt.Errorf("`dependencies` section should be a list. %v", obj)
+	}
+	var aliases, paths []string
+	seen := make(map[string]bool)
+	for _, dep := range obj.([]interface{}) {
+		if utils.IsStringType(dep) {
+			aliases = append(aliases, "")
+			paths = append(paths, dep.(string))
+			continue
+		}
+		depMap, ok := dep.(map[interface{}]interface{})
+		if !ok || len(depMap) != 1 {
+			return nil, nil, fmt.Errorf("Dependency should be a path or a map from alias to path. %v", dep)
+		}
+		for alias, path := range depMap {
+			if !utils.IsStringType(alias) || !utils.IsStringType(path) {
+				return nil, nil, fmt.Errorf("Alias and path of dependency should be strings. %v", dep)
+			}
+			if seen[alias.(string)] {
+				return nil, nil, fmt.Errorf("Duplicated dependency alias `%s`", alias)
+			}
+			seen[alias.(string)] = true
+			aliases = append(aliases, alias.(string))
+			paths = append(paths, path.(string))
+		}
+	}
+	return aliases, paths, nil
+}
+
+// Find the dependency by its alias or cluster name, the name matching more than one dependency is ambiguous.
+func (c *Cluster) lookupDependency(name string) (*Cluster, error) {
+	var matched []*Cluster
+	for i, dep := range c.Dependencies {
+		if (i < len(c.DependencyAliases) && c.DependencyAliases[i] == name) || dep.ClusterName == name {
+			matched = append(matched, dep)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("Unknown dependency `%s` of cluster %s", name, c.ClusterName)
+	} else if len(matched) > 1 {
+		return nil, fmt.Errorf("Ambiguous dependency `%s` of cluster %s, matched %d dependencies", name, c.ClusterName, len(matched))
+	}
+	return matched[0], nil
+}
+
+// Resolve the super_job inheritance of all jobs in topological order, which means a job will always be merged
+// with a parent job whose own inheritance has already been resolved. Cycles and missing parents are rejected
+// with an error which contains the whole inheritance chain.
+func resolveSuperJobs(jobs map[string]*Job) (map[string]*Job, error) {
+	resolved := make(map[string]*Job)
+	visiting := make(map[string]bool)
+
+	var resolve func(jobName string, chain []string) (*Job, error)
+	resolve = func(jobName string, chain []string) (*Job, error) {
+		if job, ok := resolved[jobName]; ok {
+			return job, nil
+		}
+		chain = append(chain, jobName)
+		if visiting[jobName] {
+			return nil, fmt.Errorf("Cyclic super_job inheritance: %s", strings.Join(chain, " -> "))
+		}
+		job, ok := jobs[jobName]
+		if !ok || job == nil {
+			return nil, fmt.Errorf("super_job `%s` does not exist, inheritance chain: %s", jobName, strings.Join(chain, " -> "))
+		}
+		visiting[jobName] = true
+		if job.SuperJob != "" {
+			parentJob, err := resolve(job.SuperJob, chain)
+			if err != nil {
+				return nil, err
+			}
 			if job, err = job.mergeWith(parentJob); err != nil {
 				return nil, err
 			}
 		}
-		newJobs[jobName] = job
+		delete(visiting, jobName)
+		resolved[jobName] = job
+		return job, nil
 	}
-	c.Jobs = newJobs
 
-	return c, nil
+	// Iterate in a stable order so that the reported error is deterministic.
+	var jobNames []string
+	for jobName := range jobs {
+		jobNames = append(jobNames, jobName)
+	}
+	sort.Strings(jobNames)
+	for _, jobName := range jobNames {
+		if _, err := resolve(jobName, []string{}); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
 }
 
-func (s *Cluster) toShell(jobKey string) []string {
+func (s *Cluster) toShell(jobKey string, taskId int) []string {
 	if _, ok := s.Jobs[jobKey]; !ok {
 		return []string{}
 	}
 	var buf []string
 	buf = append(buf, s.MainProcess)
-	for _, arg := range s.Jobs[jobKey].toShell() {
+	for _, arg := range s.Jobs[jobKey].toShell(taskId) {
 		buf = append(buf, arg)
 	}
 	return buf
 }
 
-// If skipHostRender is true , will skip to render the HostRender
-func (c *Cluster) RenderConfigFiles(job *Job, taskId int, skipHostRender bool) (map[string]string, error) {
+// Return the job with overrides of the task applied, and the function to render every line of it. If skipHostRender
+// is true, the task overrides are not applied and the lines are rendered with the job variables only.
+func (c *Cluster) taskRenderer(job *Job, taskId int, skipHostRender bool) (*Job, func(string) (string, error), error) {
 	var ok bool
 	if job, ok = c.Jobs[job.JobName]; !ok {
-		return nil, fmt.Errorf("Job with name `%s` not exist in cluster %s", job.JobName, c.ClusterName)
+		return nil, nil, fmt.Errorf("Job with name `%s` not exist in cluster %s", job.JobName, c.ClusterName)
 	}
 
 	if !skipHostRender {
 		if _, ok = job.GetHost(taskId); !ok {
-			return nil, fmt.Errorf("TaskId `%d` not exist in job `%s` for cluster `%s`", taskId, job.JobName, c.ClusterName)
+			return nil, nil, fmt.Errorf("TaskId `%d` not exist in job `%s` for cluster `%s`", taskId, job.JobName, c.ClusterName)
 		}
+		job = job.forTask(taskId)
+	}
+
+	renderLine := func(line string) (string, error) {
+		return lineRender(c, job, taskId, line, skipHostRender)
+	}
+	return job, renderLine, nil
+}
+
+func (c *Cluster) RenderConfigFiles(job *Job, taskId int, skipHostRender bool) (map[string]string, error) {
+	job, renderLine, err := c.taskRenderer(job, taskId, skipHostRender)
+	if err != nil {
+		return nil, err
 	}
 
+	// Render every line before formatting, so the rendered values will also be escaped for xml.
 	cfgMap := make(map[string]string)
 	for fname, cfg := range job.ConfigFiles {
-		newCfg, err := GlobalRender(c, cfg.ToString())
+		newCfg, err := cfg.render(renderLine)
 		if err != nil {
 			return nil, err
 		}
-		if !skipHostRender {
-			newCfg, err = HostRender(c, taskId, newCfg)
-			if err != nil {
-				return nil, err
-			}
-		}
-		cfgMap[fname] = newCfg
+		cfgMap[renderedConfigName(fname)] = newCfg.ToString()
 	}
 
 	return cfgMap, nil
 }
 
+// Render the environment variables of the process for the task.
+func (c *Cluster) RenderEnv(job *Job, taskId int, skipHostRender bool) (map[string]string, error) {
+	job, renderLine, err := c.taskRenderer(job, taskId, skipHostRender)
+	if err != nil {
+		return nil, err
+	}
+	env := make(map[string]string)
+	for key, value := range job.Env {
+		if env[key], err = renderLine(value); err != nil {
+			return nil, fmt.Errorf("Failed to render env %s: %v", key, err)
+		}
+	}
+	return env, nil
+}
+
 func LoadClusterConfig(yamlCfgPath string, e *EnvVariables) (*Cluster, error) {
-	if cfgContents, err := readYamlConfig(yamlCfgPath, e); err != nil {
+	cfgContents, err := readYamlConfig(yamlCfgPath, e)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCluster(cfgContents, e)
+	if err != nil {
 		return nil, err
-	} else {
-		return NewCluster(cfgContents, e)
 	}
+	c.ConfigPath = yamlCfgPath
+	return c, nil
 }
 
 func readYamlConfig(yamlCfgPath string, e *EnvVariables) ([]string, error) {
@@ -205,6 +356,64 @@ func readYamlConfig(yamlCfgPath string, e *EnvVariables) ([]string, error) {
 	}
 }
 
+// Merge the items of key-value based config files with the base yaml map before the general map merging, the
+// items of base come first so that the keys declared by both will be overridden in place. The merged items are
+// removed from the base yaml map. The config files of task overrides are merged in the same way.
+func mergeConfigItems(this, base map[interface{}]interface{}) {
+	thisJobs, ok := this["jobs"].(map[interface{}]interface{})
+	if !ok {
+		return
+	}
+	baseJobs, ok := base["jobs"].(map[interface{}]interface{})
+	if !ok {
+		return
+	}
+	for jobName, thisJob := range thisJobs {
+		mergeConfigSection(thisJob, baseJobs[jobName])
+		thisOverrides, ok := getSection(thisJob, "overrides")
+		if !ok {
+			continue
+		}
+		baseOverrides, ok := getSection(baseJobs[jobName], "overrides")
+		if !ok {
+			continue
+		}
+		for key, thisOverride := range thisOverrides {
+			mergeConfigSection(thisOverride, baseOverrides[key])
+		}
+	}
+}
+
+func mergeConfigSection(this, base interface{}) {
+	thisCfg, ok := getSection(this, "config")
+	if !ok {
+		return
+	}
+	baseCfg, ok := getSection(base, "config")
+	if !ok {
+		return
+	}
+	for fname, thisItems := range thisCfg {
+		baseItems, ok := baseCfg[fname].([]interface{})
+		if !ok || !utils.IsStringType(fname) || !isKeyValueConfig(fname.(string)) {
+			continue
+		}
+		if items, ok := thisItems.([]interface{}); ok {
+			thisCfg[fname] = append(append([]interface{}{}, baseItems...), items...)
+			delete(baseCfg, fname)
+		}
+	}
+}
+
+func getSection(obj interface{}, name string) (map[interface{}]interface{}, bool) {
+	objMap, ok := obj.(map[interface{}]interface{})
+	if !ok {
+		return nil, false
+	}
+	section, ok := objMap[name].(map[interface{}]interface{})
+	return section, ok
+}
+
 // There are many .yaml configurations, for example yamlConfigs[0], yamlConfigs[1], ..., which the first conf will merge
 // with the second one (the first win if encounter a same key), the second will merge with the third one.
 func mergeYamlConfigs(yamlConfigs []string) (map[interface{}]interface{}, error) {
@@ -220,6 +429,7 @@ func mergeYamlConfigs(yamlConfigs []string) (map[interface{}]interface{}, error)
 	}
 	ret := yamlMaps[0]
 	for i := 1; i < len(yamlMaps); i++ {
+		mergeConfigItems(ret, yamlMaps[i])
 		ret = utils.MergeMap(ret, yamlMaps[i])
 	}
 	return ret, nil
@@ -3,8 +3,12 @@ package core
 import (
 	"fmt"
 	"github.com/openinx/huker/pkg/utils"
+	"io/ioutil"
+	"os"
+	"path"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -288,7 +292,284 @@ func TestToShell(t *testing.T) {
 		"org.apache.zk.QuorumPeerMain",
 		"conf/zoo_sample.cfg",
 	}
-	if err := assertSliceEquals(s.toShell("zookeeper"), expected, "Shell"); err != nil {
+	if err := assertSliceEquals(s.toShell("zookeeper", 0), expected, "Shell"); err != nil {
 		t.Errorf("%v", err)
 	}
 }
+
+func TestSuperJobInheritance(t *testing.T) {
+	clusterCfg := `
+    cluster:
+      project: hbase
+      cluster_name: tst-cluster
+      main_process: /usr/bin/java
+      package_name: hbase-1.2.6-bin.tar.gz
+      package_md5sum: e2b28a6a0bb1699f853bd9ad9a813b2c
+    `
+	cfg := clusterCfg + `
+    jobs:
+      job_common:
+        jvm_opts:
+          - -Xmx128m
+        config:
+          hbase-site.xml:
+            - a=job_common
+            - b=job_common
+      server_common:
+        super_job: job_common
+        jvm_properties:
+          - hbase.log.dir=/home/log
+        config:
+          hbase-site.xml:
+            - b=server_common
+            - c=server_common
+      regionserver:
+        super_job: server_common
+        jvm_properties:
+          - hbase.log.file=regionserver.log
+        config:
+          hbase-site.xml:
+            - c=regionserver
+    `
+	c, err := NewCluster([]string{cfg}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Job{
+		JobName:       "regionserver",
+		JvmOpts:       []string{"-Xmx128m"},
+		JvmProperties: []string{"hbase.log.file=regionserver.log", "hbase.log.dir=/home/log"},
+		Classpath:     []string{},
+		MainEntry:     &MainEntry{},
+	}
+	if err := assertJobEquals(c.Jobs["regionserver"], expected); err != nil {
+		t.Errorf("%v", err)
+	}
+	kv := c.Jobs["regionserver"].ConfigFiles["hbase-site.xml"].ToKeyValue()
+	if !reflect.DeepEqual(kv, map[string]string{"a": "job_common", "b": "server_common", "c": "regionserver"}) {
+		t.Errorf("hbase-site.xml mismatch: %v", kv)
+	}
+
+	failedCases := []struct {
+		jobs  string
+		chain string
+	}{
+		{`
+    jobs:
+      a:
+        super_job: b
+      b:
+        super_job: c
+      c:
+        super_job: a
+    `, "a -> b -> c -> a"},
+		{`
+    jobs:
+      a:
+        super_job: a
+    `, "a -> a"},
+		{`
+    jobs:
+      a:
+        super_job: b
+      b:
+        super_job: not_exist
+    `, "a -> b -> not_exist"},
+	}
+	for i, cas := range failedCases {
+		if _, err := NewCluster([]string{clusterCfg + cas.jobs}, nil); err == nil {
+			t.Errorf("test case #%d should be failed", i)
+		} else if !strings.Contains(err.Error(), cas.chain) {
+			t.Errorf("test case #%d, error should contain the chain `%s`: %v", i, cas.chain, err)
+		}
+	}
+}
+
+func TestDependencyAliases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "huker-deps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	depCfg := func(clusterName, jobName string) string {
+		return `
+cluster:
+  project: zookeeper
+  cluster_name: ` + clusterName + `
+  main_process: /usr/bin/java
+  package_name: zookeeper-3.4.11.tar.gz
+  package_md5sum: 55aec6196ed9fa4c451cb5ae4a1f42d8
+jobs:
+  ` + jobName + `:
+    hosts:
+      - 127.0.0.1:9001/id=0/base_port=2181
+`
+	}
+	for name, cfg := range map[string]string{
+		"zk0.yaml":  depCfg("test-zk", "zkServer"),
+		"zk1.yaml":  depCfg("test-zk", "zkServer"),
+		"hdfs.yaml": depCfg("test-hdfs", "namenode"),
+	} {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(cfg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clusterCfg := func(dependencies string) string {
+		return `
+    cluster:
+      project: hbase
+      cluster_name: test-hbase
+      main_process: /usr/bin/java
+      package_name: hbase-1.4.0-bin.tar.gz
+      package_md5sum: 6dc7ae5e2f1b2ea2f4ae1e1ae0d5a4b2
+      dependencies:` + dependencies + `
+    jobs:
+      master:
+        hosts:
+          - 127.0.0.1:9001/id=0/base_port=30000
+    `
+	}
+
+	c, err := NewCluster([]string{clusterCfg(`
+        - hdfs: ` + path.Join(dir, "hdfs.yaml") + `
+        - ` + path.Join(dir, "zk0.yaml"))}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var testCases = []struct {
+		input   string
+		success bool
+		output  string
+	}{
+		{"%{deps.hdfs.namenode.0.host}:%{deps.hdfs.namenode.0.base_port}", true, "127.0.0.1:2181"},
+		{"%{deps.'test-hdfs'.cluster_name}", true, "test-hdfs"},
+		{"%{deps.'test-zk'.zkServer.server_list}", true, "127.0.0.1:2181"},
+		{"%{dependencies.1.cluster_name}", true, "test-zk"},
+		{"%{deps.zk.zkServer.server_list}", false, ""},
+		{"%{deps.hdfs.zkServer.server_list}", false, ""},
+	}
+	for i := range testCases {
+		output, err := GlobalRender(c, testCases[i].input)
+		if testCases[i].success && (err != nil || output != testCases[i].output) {
+			t.Errorf("Test case #%d failed, output: %s, err: %v", i, output, err)
+		} else if !testCases[i].success && err == nil {
+			t.Errorf("Test case #%d failed, should failed but success, output: %s", i, output)
+		}
+	}
+
+	// Two dependencies with the same cluster name can only be referenced by alias.
+	c, err = NewCluster([]string{clusterCfg(`
+        - zk: ` + path.Join(dir, "zk0.yaml") + `
+        - ` + path.Join(dir, "zk1.yaml"))}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output, err := GlobalRender(c, "%{deps.zk.zkServer.0.host}"); err != nil || output != "127.0.0.1" {
+		t.Errorf("Reference by alias failed, output: %s, err: %v", output, err)
+	}
+	if _, err := GlobalRender(c, "%{deps.'test-zk'.zkServer.0.host}"); err == nil || !strings.Contains(err.Error(), "Ambiguous dependency") {
+		t.Errorf("Reference by cluster name should be ambiguous, but: %v", err)
+	}
+
+	if _, err = NewCluster([]string{clusterCfg(`
+        - zk: ` + path.Join(dir, "zk0.yaml") + `
+        - zk: ` + path.Join(dir, "zk1.yaml"))}, nil); err == nil || !strings.Contains(err.Error(), "Duplicated dependency alias") {
+		t.Errorf("Duplicated alias should be failed, but: %v", err)
+	}
+}
+
+func TestMergeConfigItemsWithBase(t *testing.T) {
+	base := `
+    cluster:
+      project: hdfs
+      cluster_name: tst-hdfs
+      main_process: /usr/bin/java
+      package_name: hadoop-2.6.5.tar.gz
+      package_md5sum: 967c24f3c15fcdd058f34923e92ce8ac
+    jobs:
+      namenode:
+        config:
+          hdfs-site.xml:
+            - a=1
+            - b=2
+            - c=3
+          myid:
+            - base
+    `
+	child := `
+    jobs:
+      namenode:
+        hosts:
+          - 127.0.0.1:9001/id=0/base_port=20100
+        config:
+          hdfs-site.xml:
+            - d=4
+            - b=20
+          myid:
+            - child
+    `
+	c, err := NewCluster([]string{child, base}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfgMap, err := c.RenderConfigFiles(c.Jobs["namenode"], 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, line := range strings.Split(cfgMap["hdfs-site.xml"], "\n") {
+		if strings.Contains(line, "<value>") {
+			names = append(names, strings.TrimSpace(line))
+		}
+	}
+	expected := []string{"<value>1</value>", "<value>20</value>", "<value>3</value>", "<value>4</value>"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Values of hdfs-site.xml mismatch, %v != %v", names, expected)
+	}
+	if cfgMap["myid"] != "child\nbase" {
+		t.Errorf("Plain config mismatch: %s", cfgMap["myid"])
+	}
+}
+
+func TestRenderEnv(t *testing.T) {
+	cfg := `
+    cluster:
+      project: hdfs
+      cluster_name: tst-hdfs
+      main_process: /usr/bin/java
+      package_name: hadoop-2.6.5.tar.gz
+      package_md5sum: 967c24f3c15fcdd058f34923e92ce8ac
+    jobs:
+      base:
+        env:
+          JAVA_HOME: /usr/lib/jvm/java-8
+          HADOOP_HEAPSIZE: 1024
+      datanode:
+        super_job: base
+        hosts:
+          - 127.0.0.1:9001/id=0/base_port=20100
+        env:
+          HADOOP_HEAPSIZE: 4096
+          HADOOP_IDENT_STRING: "%{cluster.name}-%{self.job}-%{self.base_port}"
+    `
+	c, err := NewCluster([]string{cfg}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := c.RenderEnv(c.Jobs["datanode"], 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"JAVA_HOME":           "/usr/lib/jvm/java-8",
+		"HADOOP_HEAPSIZE":     "4096",
+		"HADOOP_IDENT_STRING": "tst-hdfs-datanode-20100",
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("Rendered env mismatch, %v != %v", env, expected)
+	}
+
+	if _, err := NewCluster([]string{strings.Replace(cfg, "JAVA_HOME:", "JAVA-HOME:", 1)}, nil); err == nil {
+		t.Errorf("Invalid name of environment variable should be failed")
+	}
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ConflictPort      = "port"
+	ConflictDirectory = "directory"
+)
+
+// The ports and root directory that a task will occupy on its host.
+type TaskFootprint struct {
+	ConfigPath string
+	Cluster    string
+	Job        string
+	Host       *Host
+	Ports      []int
+	RootDir    string
+}
+
+func (t *TaskFootprint) String() string {
+	return fmt.Sprintf("%s/%s.%d (%s)", t.Cluster, t.Job, t.Host.TaskId, t.ConfigPath)
+}
+
+// True if the expression references the base_port of the given task, such as %{self.base_port+1}.
+func refersTaskPort(expr exprNode, jobName string, taskId int) bool {
+	for _, ref := range collectRefs(expr, nil) {
+		if len(ref.path) == 2 && ref.path[0] == "self" && ref.path[1] == "base_port" {
+			return true
+		}
+		if len(ref.path) == 3 && ref.path[0] == jobName && ref.path[2] == "base_port" &&
+			(ref.path[1] == "x" || ref.path[1] == strconv.Itoa(taskId)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Collect the ports of a task, which are the base_port and every integer value of the placeholders referencing
+// the base_port of the task itself in its config files.
+func (c *Cluster) taskFootprint(job *Job, host *Host) *TaskFootprint {
+	job = job.forTask(host.TaskId)
+	portSet := map[int]bool{host.BasePort: true}
+	ctx := &renderContext{c: c, job: job, taskId: host.TaskId}
+	for fname, cfg := range job.ConfigFiles {
+		for _, s := range []string{fname, cfg.ToString()} {
+			placeholders, err := parsePlaceholders(s)
+			if err != nil {
+				continue
+			}
+			for _, ph := range placeholders {
+				if exprScope(ph.expr) == scopeUnknown || !refersTaskPort(ph.expr, job.JobName, host.TaskId) {
+					continue
+				}
+				if val, err := ctx.eval(ph.expr); err == nil {
+					if port, ok := val.(int); ok {
+						portSet[port] = true
+					}
+				}
+			}
+		}
+	}
+	var ports []int
+	for port := range portSet {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return &TaskFootprint{
+		ConfigPath: c.ConfigPath,
+		Cluster:    c.ClusterName,
+		Job:        job.JobName,
+		Host:       host,
+		Ports:      ports,
+		// Same as the job root dir in supervisor agent: <agent-root-dir>/<cluster>/<job>.<taskId>
+		RootDir: path.Join(fmt.Sprintf("%s:%d", host.Hostname, host.SupervisorPort), c.ClusterName,
+			fmt.Sprintf("%s.%d", job.JobName, host.TaskId)),
+	}
+}
+
+// Conflict means that two or more tasks claim the same port or the same directory on a host.
+type Conflict struct {
+	Hostname string
+	Kind     string
+	Port     int
+	RootDir  string
+	Tasks    []*TaskFootprint
+}
+
+func (c *Conflict) Error() string {
+	var tasks []string
+	for _, t := range c.Tasks {
+		tasks = append(tasks, t.String())
+	}
+	if c.Kind == ConflictPort {
+		return fmt.Sprintf("Port %d on host %s is claimed by multiple tasks: %s", c.Port, c.Hostname, strings.Join(tasks, ", "))
+	}
+	return fmt.Sprintf("Directory %s on host %s is claimed by multiple tasks: %s", c.RootDir, c.Hostname, strings.Join(tasks, ", "))
+}
+
+// Find the port and directory collisions of all tasks among the given clusters, grouped by hostname.
+func FindConflicts(clusters []*Cluster) []*Conflict {
+	portTasks := make(map[string]map[int][]*TaskFootprint)
+	dirTasks := make(map[string]map[string][]*TaskFootprint)
+	for _, c := range clusters {
+		var jobNames []string
+		for jobName := range c.Jobs {
+			jobNames = append(jobNames, jobName)
+		}
+		sort.Strings(jobNames)
+		for _, jobName := range jobNames {
+			job := c.Jobs[jobName]
+			for _, host := range job.Hosts {
+				t := c.taskFootprint(job, host)
+				if _, ok := portTasks[host.Hostname]; !ok {
+					portTasks[host.Hostname] = make(map[int][]*TaskFootprint)
+					dirTasks[host.Hostname] = make(map[string][]*TaskFootprint)
+				}
+				for _, port := range t.Ports {
+					portTasks[host.Hostname][port] = append(portTasks[host.Hostname][port], t)
+				}
+				dirTasks[host.Hostname][t.RootDir] = append(dirTasks[host.Hostname][t.RootDir], t)
+			}
+		}
+	}
+
+	var hostnames []string
+	for hostname := range portTasks {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+
+	var conflicts []*Conflict
+	for _, hostname := range hostnames {
+		var ports []int
+		for port := range portTasks[hostname] {
+			ports = append(ports, port)
+		}
+		sort.Ints(ports)
+		for _, port := range ports {
+			if len(portTasks[hostname][port]) > 1 {
+				conflicts = append(conflicts, &Conflict{
+					Hostname: hostname,
+					Kind:     ConflictPort,
+					Port:     port,
+					Tasks:    portTasks[hostname][port],
+				})
+			}
+		}
+		var dirs []string
+		for dir := range dirTasks[hostname] {
+			dirs = append(dirs, dir)
+		}
+		sort.Strings(dirs)
+		for _, dir := range dirs {
+			if len(dirTasks[hostname][dir]) > 1 {
+				conflicts = append(conflicts, &Conflict{
+					Hostname: hostname,
+					Kind:     ConflictDirectory,
+					RootDir:  dir,
+					Tasks:    dirTasks[hostname][dir],
+				})
+			}
+		}
+	}
+	return conflicts
+}
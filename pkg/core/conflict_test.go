@@ -0,0 +1,124 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindConflicts(t *testing.T) {
+	clusterCfg := func(clusterName, hosts string) string {
+		return `
+    cluster:
+      project: hdfs
+      cluster_name: ` + clusterName + `
+      main_process: /usr/bin/java
+      package_name: hadoop-2.6.5.tar.gz
+      package_md5sum: 967c24f3c15fcdd058f34923e92ce8ac
+    jobs:
+      datanode:
+        hosts:` + hosts + `
+        config:
+          hdfs-site.xml:
+            - dfs.datanode.address=0.0.0.0:%{datanode.x.base_port}
+            - dfs.datanode.http.address=0.0.0.0:%{datanode.x.base_port+1}
+            - dfs.datanode.ipc.address=0.0.0.0:%{datanode.x.base_port+2}
+    `
+	}
+	c0, err := NewCluster([]string{clusterCfg("hdfs0", `
+          - 127.0.0.1:9001/id=0/base_port=21000
+          - 127.0.0.2:9001/id=1/base_port=21000`)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c0.ConfigPath = "hdfs0.yaml"
+	c1, err := NewCluster([]string{clusterCfg("hdfs1", `
+          - 127.0.0.1:9001/id=0/base_port=21002
+          - 127.0.0.2:9001/id=1/base_port=21010`)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1.ConfigPath = "hdfs1.yaml"
+
+	if ports := c0.taskFootprint(c0.Jobs["datanode"], c0.Jobs["datanode"].Hosts[0]).Ports; !reflect.DeepEqual(ports, []int{21000, 21001, 21002}) {
+		t.Errorf("Ports of task mismatch: %v", ports)
+	}
+
+	if conflicts := FindConflicts([]*Cluster{c0}); len(conflicts) != 0 {
+		t.Errorf("Should have no conflicts, but: %v", conflicts)
+	}
+
+	conflicts := FindConflicts([]*Cluster{c0, c1})
+	if len(conflicts) != 1 {
+		t.Fatalf("Size of conflicts should be 1, instead of %d: %v", len(conflicts), conflicts)
+	}
+	expected := "Port 21002 on host 127.0.0.1 is claimed by multiple tasks: hdfs0/datanode.0 (hdfs0.yaml), hdfs1/datanode.0 (hdfs1.yaml)"
+	if conflicts[0].Error() != expected {
+		t.Errorf("Conflict mismatch, [%s] != [%s]", conflicts[0].Error(), expected)
+	}
+
+	// The same cluster defined twice will share the same directories.
+	conflicts = FindConflicts([]*Cluster{c0, c0})
+	dirConflicts := 0
+	for _, conflict := range conflicts {
+		if conflict.Kind == ConflictDirectory {
+			dirConflicts++
+		}
+	}
+	if dirConflicts != 2 {
+		t.Errorf("Size of directory conflicts should be 2, instead of %d: %v", dirConflicts, conflicts)
+	}
+}
+
+func TestCheckConflicts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conflicts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(path.Join(dir, "zookeeper"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	clusterCfg := func(clusterName string) string {
+		return `
+cluster:
+  project: zookeeper
+  cluster_name: ` + clusterName + `
+  main_process: /usr/bin/java
+  package_name: zookeeper-3.4.11.tar.gz
+  package_md5sum: 55aec6196ed9fa4c451cb5ae4a1f42d8
+jobs:
+  zookeeper:
+    hosts:
+      - 127.0.0.1:9001/id=0/base_port=2181
+`
+	}
+	writeCfg := func(name, content string) {
+		if err := ioutil.WriteFile(path.Join(dir, "zookeeper", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeCfg("zk0.yaml", clusterCfg("zk0"))
+	j, err := NewConfigFileHukerJob(dir, "http://127.0.0.1:4000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.checkConflicts("zookeeper", "zk0", "zookeeper", -1); err != nil {
+		t.Errorf("Cluster without conflicts should pass, %v", err)
+	}
+
+	writeCfg("zk1.yaml", clusterCfg("zk1"))
+	if err := j.checkConflicts("zookeeper", "zk0", "zookeeper", 0); err == nil {
+		t.Errorf("Port conflict with cluster zk1 should be refused")
+	}
+
+	// The conflicts with a broken cluster can't be checked, so the deployment is refused too.
+	writeCfg("zk1.yaml", "cluster: [")
+	if err := j.checkConflicts("zookeeper", "zk0", "zookeeper", -1); err == nil ||
+		!strings.Contains(err.Error(), path.Join(dir, "zookeeper", "zk1.yaml")) {
+		t.Errorf("Broken cluster config should be reported, %v", err)
+	}
+}
@@ -0,0 +1,433 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/openinx/huker/pkg/supervisor"
+	"github.com/openinx/huker/pkg/utils"
+	"github.com/qiniu/log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default entry of the decommissioned task in the exclude file.
+const defaultExcludeEntry = "%{self.host}"
+
+// Procedure to decommission the tasks of job gracefully before stopping them, such as the datanode:
+//
+//	decommission:
+//	  exclude_job: namenode
+//	  exclude_file: dfs_exclude
+//	  exclude_entry: "%{self.host}:%{self.base_port}"
+//	  refresh_job: dfsadmin
+//	  refresh_args: -refreshNodes
+//	  completion_probe:
+//	    type: command
+//	    target: 'report=$($PROGRAM_BIN $PROGRAM_ARGS -report) && echo "$report" | grep -A3 "^Name: %{self.host}:%{self.base_port} " | grep -q "Decommission Status : Decommissioned"'
+//	    timeout_seconds: 86400
+//	    interval_seconds: 30
+//
+// Every step is optional. The refresh_args, exclude_entry and the target of completion_probe are rendered for the
+// decommissioned task, and the command probe runs in the local package of refresh_job just like huker shell.
+type DecommissionProcedure struct {
+	// Job of the same cluster whose config file ExcludeFile lists the decommissioned tasks, one entry per line.
+	ExcludeJob   string
+	ExcludeFile  string
+	ExcludeEntry string
+	// Job run by huker shell with RefreshArgs to make the ExcludeJob reload the exclude file.
+	RefreshJob  string
+	RefreshArgs []string
+	// Probe which succeeds once the task is decommissioned, the task is stopped after that.
+	CompletionProbe *supervisor.Probe
+}
+
+func parseDecommission(obj interface{}) (*DecommissionProcedure, error) {
+	if !utils.IsMapType(obj) {
+		return nil, fmt.Errorf("Invalid decommission, should be a map. %v", obj)
+	}
+	d := &DecommissionProcedure{ExcludeEntry: defaultExcludeEntry}
+	var err error
+	for key, value := range obj.(map[interface{}]interface{}) {
+		switch key {
+		case "exclude_job", "exclude_file", "exclude_entry", "refresh_job":
+			if !utils.IsStringType(value) || value.(string) == "" {
+				return nil, fmt.Errorf("Invalid decommission `%v`, should be a non-empty string. %v", key, value)
+			}
+			switch key {
+			case "exclude_job":
+				d.ExcludeJob = value.(string)
+			case "exclude_file":
+				d.ExcludeFile = value.(string)
+			case "exclude_entry":
+				d.ExcludeEntry = value.(string)
+			default:
+				d.RefreshJob = value.(string)
+			}
+		case "refresh_args":
+			if utils.IsStringType(value) {
+				d.RefreshArgs = strings.Fields(value.(string))
+			} else if d.RefreshArgs, err = ParseStringArray(value); err != nil {
+				return nil, fmt.Errorf("Invalid decommission `refresh_args`: %v", err)
+			}
+		case "completion_probe":
+			probes, err := parseReadinessProbes([]interface{}{value})
+			if err != nil {
+				return nil, fmt.Errorf("Invalid decommission `completion_probe`: %v", err)
+			}
+			d.CompletionProbe = &probes[0]
+		default:
+			return nil, fmt.Errorf("Unknown decommission `%v`", key)
+		}
+	}
+	if (d.ExcludeJob == "") != (d.ExcludeFile == "") {
+		return nil, fmt.Errorf("The exclude_job and exclude_file of decommission should be declared together")
+	}
+	if d.RefreshJob == "" && len(d.RefreshArgs) > 0 {
+		return nil, fmt.Errorf("The refresh_args of decommission has no refresh_job to run")
+	}
+	if d.RefreshJob == "" && d.CompletionProbe != nil && d.CompletionProbe.Type == supervisor.ProbeCommand {
+		return nil, fmt.Errorf("The command completion_probe of decommission runs in the package of refresh_job, " +
+			"declare the refresh_job please")
+	}
+	return d, nil
+}
+
+// The decommission procedure rendered for a task.
+type taskDecommission struct {
+	excludeEntry    string
+	refreshArgs     []string
+	completionProbe *supervisor.Probe
+}
+
+func (c *Cluster) renderDecommission(job *Job, taskId int) (*taskDecommission, error) {
+	job, renderLine, err := c.taskRenderer(job, taskId, false)
+	if err != nil {
+		return nil, err
+	}
+	d := job.Decommission
+	td := &taskDecommission{}
+	if d.ExcludeJob != "" {
+		if td.excludeEntry, err = renderLine(d.ExcludeEntry); err != nil {
+			return nil, fmt.Errorf("Failed to render exclude_entry: %v", err)
+		}
+	}
+	for _, arg := range d.RefreshArgs {
+		arg, err := renderLine(arg)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to render refresh_args: %v", err)
+		}
+		td.refreshArgs = append(td.refreshArgs, arg)
+	}
+	if d.CompletionProbe != nil {
+		probe := *d.CompletionProbe
+		if probe.Target, err = renderLine(probe.Target); err != nil {
+			return nil, fmt.Errorf("Failed to render completion_probe: %v", err)
+		}
+		td.completionProbe = &probe
+	}
+	return td, nil
+}
+
+// Append the entries missing in the exclude file, one entry per line.
+func addExcludeEntries(content string, entries []string) string {
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	if content == "" {
+		lines = nil
+	}
+	for _, entry := range entries {
+		if !utils.StringSliceContains(lines, entry) {
+			lines = append(lines, entry)
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Remove the entries from the exclude file, the file becomes empty once no line is left.
+func removeExcludeEntries(content string, entries []string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+		if line != "" && !utils.StringSliceContains(entries, line) {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// The entries of the exclude file, blank lines and comments are skipped.
+func excludeEntries(content string) []string {
+	var entries []string
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			entries = append(entries, line)
+		}
+	}
+	return entries
+}
+
+// Names of the exclude files of job, which is the exclude_job of decommission procedures in the cluster.
+func (c *Cluster) excludeFiles(job string) []string {
+	var files []string
+	for _, jobPtr := range c.Jobs {
+		d := jobPtr.Decommission
+		if d != nil && d.ExcludeJob == job && !utils.StringSliceContains(files, d.ExcludeFile) {
+			files = append(files, d.ExcludeFile)
+		}
+	}
+	return files
+}
+
+// Merge the entries of the deployed exclude files into the program rendered from yaml, so deploying the exclude_job
+// again won't recommission the decommissioned tasks. Return the merged entries.
+func mergeExcludeEntries(files []string, prog, deployed *supervisor.Program) []string {
+	var merged []string
+	for _, file := range files {
+		entries := excludeEntries(deployed.Configs[file])
+		if len(entries) == 0 {
+			continue
+		}
+		if prog.Configs == nil {
+			prog.Configs = make(map[string]string)
+		}
+		prog.Configs[file] = addExcludeEntries(prog.Configs[file], entries)
+		merged = append(merged, entries...)
+	}
+	return merged
+}
+
+// Merge the entries of the exclude files deployed on the task of prog before updating it, nothing is merged if the
+// task is not deployed yet.
+func keepExcludeEntries(supCli *supervisor.SupervisorCli, files []string, prog *supervisor.Program) error {
+	if len(files) == 0 {
+		return nil
+	}
+	deployed, err := supCli.Show(prog.Name, prog.Job, prog.TaskId)
+	if err != nil {
+		if supervisor.IsTaskNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("Failed to keep the decommissioned entries of %v: %v", files, err)
+	}
+	if entries := mergeExcludeEntries(files, prog, deployed); len(entries) > 0 {
+		log.Warnf("Keep the decommissioned entries %v in %v of task %s.%s.%d, recommission them to remove please",
+			entries, files, prog.Name, prog.Job, prog.TaskId)
+	}
+	return nil
+}
+
+// Edit the exclude file of every task of the exclude job, and push the config files to the agents without
+// restarting the tasks. If any push fails, the pushed tasks are reverted to their previous exclude files.
+func (j *ConfigFileHukerJob) pushExcludeFile(c *Cluster, d *DecommissionProcedure, edit func(string) string) error {
+	excludeJob, ok := c.Jobs[d.ExcludeJob]
+	if !ok {
+		return fmt.Errorf("The exclude_job `%s` of decommission does not exist in %s", d.ExcludeJob, c.ConfigPath)
+	}
+	var mu sync.Mutex
+	previous := make(map[*Host]string)
+	push := func(hosts []*Host, edit func(*Host, string) string) (pushed []*Host, errs []string) {
+		for _, result := range j.runTasks(hosts, func(ctx context.Context, host *Host) TaskResult {
+			supCli := supervisor.NewSupervisorCli(host.ToHttpAddress()).WithContext(ctx)
+			prog, err := supCli.Show(c.ClusterName, d.ExcludeJob, host.TaskId)
+			if err != nil {
+				return NewTaskResult(host, nil, err)
+			}
+			if prog.Configs == nil {
+				prog.Configs = make(map[string]string)
+			}
+			content := prog.Configs[d.ExcludeFile]
+			prog.Configs[d.ExcludeFile] = edit(host, content)
+			if err := supCli.PushConfig(prog); err != nil {
+				return NewTaskResult(host, nil, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if _, ok := previous[host]; !ok {
+				previous[host] = content
+			}
+			return NewTaskResult(host, prog, nil)
+		}) {
+			if result.Err != nil {
+				errs = append(errs, fmt.Sprintf("task %s: %v", result.Host.ToKey(), result.Err))
+			} else {
+				pushed = append(pushed, result.Host)
+			}
+		}
+		return pushed, errs
+	}
+
+	pushed, errs := push(selectHosts(excludeJob, -1), func(host *Host, content string) string {
+		return edit(content)
+	})
+	if len(errs) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("Failed to push %s to job `%s`, %s", d.ExcludeFile, d.ExcludeJob, strings.Join(errs, "; "))
+	if len(pushed) == 0 {
+		return err
+	}
+	reverted, revertErrs := push(pushed, func(host *Host, content string) string {
+		return previous[host]
+	})
+	if len(revertErrs) > 0 {
+		isReverted := make(map[*Host]bool)
+		for _, host := range reverted {
+			isReverted[host] = true
+		}
+		var keys []string
+		for _, host := range pushed {
+			if !isReverted[host] {
+				keys = append(keys, host.ToKey())
+			}
+		}
+		return fmt.Errorf("%v. The new %s is left on task(s) %v, which failed to revert: %s", err,
+			d.ExcludeFile, keys, strings.Join(revertErrs, "; "))
+	}
+	log.Warnf("Reverted %s of %d task(s) of job `%s` after the failed push", d.ExcludeFile, len(reverted),
+		d.ExcludeJob)
+	return err
+}
+
+// The decommission procedure of the selected tasks of job, with the local package of the refresh_job prepared.
+type decommissionRun struct {
+	c       *Cluster
+	d       *DecommissionProcedure
+	hosts   []*Host
+	tds     map[*Host]*taskDecommission
+	entries []string
+	local   *supervisor.Program
+}
+
+func (j *ConfigFileHukerJob) newDecommissionRun(project, cluster, job string, taskId int) (*decommissionRun, error) {
+	c, err := j.newCluster(project, cluster, job)
+	if err != nil {
+		return nil, err
+	}
+	jobPtr := c.Jobs[job]
+	r := &decommissionRun{c: c, d: jobPtr.Decommission, hosts: selectHosts(jobPtr, taskId),
+		tds: make(map[*Host]*taskDecommission), local: &supervisor.Program{}}
+	if r.d == nil {
+		return nil, fmt.Errorf("Job `%s` declares no decommission procedure in %s", job, c.ConfigPath)
+	}
+	for _, host := range r.hosts {
+		if r.tds[host], err = c.renderDecommission(jobPtr, host.TaskId); err != nil {
+			return nil, err
+		}
+		r.entries = append(r.entries, r.tds[host].excludeEntry)
+	}
+	// The local package of the refresh job is shared by the refresh commands and probes.
+	if r.d.RefreshJob != "" {
+		if r.local, err = j.localProgram(project, cluster, r.d.RefreshJob); err != nil {
+			return nil, fmt.Errorf("Failed to prepare the refresh_job `%s` of decommission: %v", r.d.RefreshJob, err)
+		}
+	}
+	return r, nil
+}
+
+// Run the refresh_job by the local package shell for the task.
+func (r *decommissionRun) refresh(ctx context.Context, host *Host) error {
+	args := append(append([]string{}, r.local.Args...), r.tds[host].refreshArgs...)
+	cmd := exec.CommandContext(ctx, r.local.Bin, args...)
+	cmd.Env, cmd.Dir = r.local.ProcessEnv(), r.local.RootDir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Failed to refresh by job `%s`: %v, output: %s", r.d.RefreshJob, err, out)
+	}
+	log.Infof("Refreshed by job `%s` for task %s: %s", r.d.RefreshJob, host.ToKey(), out)
+	return nil
+}
+
+// Decommission the tasks of job by the decommission procedure declared in yaml:
+//
+//  1. Add the tasks into the exclude file of the exclude_job, and push it to the agents.
+//  2. Run the refresh_job by the local package shell for every task.
+//  3. Poll the completion_probe of every task until the task is decommissioned.
+//  4. Stop the task.
+//
+// The tasks are decommissioned concurrently, the exclude file is pushed once for all of them. The entries are kept
+// in the deployed exclude file, and merged back whenever the exclude_job is deployed from yaml again, until the
+// tasks are recommissioned.
+func (j *ConfigFileHukerJob) Decommission(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	r, err := j.newDecommissionRun(project, cluster, job, taskId)
+	if err != nil {
+		return nil, err
+	}
+	d := r.d
+	if d.ExcludeJob != "" {
+		if err := j.pushExcludeFile(r.c, d, func(content string) string {
+			return addExcludeEntries(content, r.entries)
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	// The decommission takes a long time to move the data, so the timeout of task covers the completion probe.
+	dj := *j
+	if d.CompletionProbe != nil {
+		dj.taskTimeout += time.Duration(d.CompletionProbe.TimeoutSeconds) * time.Second
+	}
+	return dj.runTasks(r.hosts, j.notified(cluster, job, func(ctx context.Context, host *Host) TaskResult {
+		if d.RefreshJob != "" {
+			if err := r.refresh(ctx, host); err != nil {
+				return NewTaskResult(host, nil, err)
+			}
+		}
+		if probe := r.tds[host].completionProbe; probe != nil {
+			if err := probe.Poll(ctx, r.local); err != nil {
+				return NewTaskResult(host, nil, fmt.Errorf("Decommission is not completed: %v", err))
+			}
+		}
+		supCli := supervisor.NewSupervisorCli(host.ToHttpAddress()).WithContext(ctx)
+		prog, err := supCli.Show(cluster, job, host.TaskId)
+		if err != nil {
+			return NewTaskResult(host, nil, err)
+		}
+		if supervisor.IsRunningStatus(prog.Status) {
+			if err := supCli.Stop(cluster, job, host.TaskId); err != nil {
+				return NewTaskResult(host, nil, err)
+			}
+		}
+		prog, err = supCli.Show(cluster, job, host.TaskId)
+		return NewTaskResult(host, prog, err)
+	})), nil
+}
+
+// Recommission the decommissioned tasks of job: remove them from the exclude file of the exclude_job, push it to the
+// agents and run the refresh_job for every task, then start the tasks. The refresh_job only runs with the exclude
+// file, since the refresh_args without it drain the task again, such as the unload of region_mover.
+func (j *ConfigFileHukerJob) Recommission(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	r, err := j.newDecommissionRun(project, cluster, job, taskId)
+	if err != nil {
+		return nil, err
+	}
+	d := r.d
+	if d.ExcludeJob != "" {
+		if err := j.pushExcludeFile(r.c, d, func(content string) string {
+			return removeExcludeEntries(content, r.entries)
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return j.runTasks(r.hosts, j.notified(cluster, job, func(ctx context.Context, host *Host) TaskResult {
+		if d.ExcludeJob != "" && d.RefreshJob != "" {
+			if err := r.refresh(ctx, host); err != nil {
+				return NewTaskResult(host, nil, err)
+			}
+		}
+		supCli := supervisor.NewSupervisorCli(host.ToHttpAddress()).WithContext(ctx)
+		prog, err := supCli.Show(cluster, job, host.TaskId)
+		if err != nil {
+			return NewTaskResult(host, nil, err)
+		}
+		if !supervisor.IsRunningStatus(prog.Status) {
+			if err := supCli.Start(cluster, job, host.TaskId); err != nil {
+				return NewTaskResult(host, nil, err)
+			}
+		}
+		prog, err = waitReady(ctx, supCli, cluster, job, host.TaskId)
+		return NewTaskResult(host, prog, err)
+	})), nil
+}
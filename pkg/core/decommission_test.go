@@ -0,0 +1,277 @@
+package core
+
+import (
+	"encoding/json"
+	"github.com/openinx/huker/pkg/supervisor"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRenderDecommission(t *testing.T) {
+	cfg := `
+    cluster:
+      project: hdfs
+      cluster_name: tst-hdfs
+      main_process: /usr/bin/java
+      package_name: hadoop-2.6.5.tar.gz
+      package_md5sum: 967c24f3c15fcdd058f34923e92ce8ac
+    jobs:
+      base:
+        decommission:
+          exclude_job: namenode
+          exclude_file: dfs_exclude
+          refresh_job: dfsadmin
+          refresh_args: -refreshNodes %{namenode.0.host}:%{namenode.0.base_port}
+          completion_probe:
+            type: command
+            target: 'report=$($PROGRAM_BIN $PROGRAM_ARGS -report) && echo "$report" | grep -A3 "^Name: %{self.host}:%{self.base_port} " | grep -q Decommissioned'
+            interval_seconds: 30
+      datanode:
+        super_job: base
+        hosts:
+          - 127.0.0.1:9001/id=0/base_port=20100
+      namenode:
+        hosts:
+          - 127.0.0.2:9001/id=0/base_port=20200
+      dfsadmin:
+        main_entry:
+          java_class: org.apache.hadoop.hdfs.tools.DFSAdmin
+    `
+	c, err := NewCluster([]string{cfg}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Jobs["namenode"].Decommission != nil {
+		t.Errorf("Namenode should not be decommissioned")
+	}
+	td, err := c.renderDecommission(c.Jobs["datanode"], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &taskDecommission{
+		excludeEntry: "127.0.0.1",
+		refreshArgs:  []string{"-refreshNodes", "127.0.0.2:20200"},
+		completionProbe: &supervisor.Probe{Type: "command", TimeoutSeconds: 60, IntervalSeconds: 30,
+			Target: `report=$($PROGRAM_BIN $PROGRAM_ARGS -report) && echo "$report" | grep -A3 "^Name: 127.0.0.1:20100 " | ` +
+				`grep -q Decommissioned`},
+	}
+	if !reflect.DeepEqual(td, expected) {
+		t.Errorf("Decommission of datanode mismatch, %+v != %+v", td, expected)
+	}
+	if errs := c.Validate(); len(errs) != 0 {
+		t.Errorf("Cluster should be valid, %v", errs)
+	}
+
+	delete(c.Jobs, "dfsadmin")
+	if errs := c.Validate(); len(errs) != 1 || errs[0].Key != "decommission" {
+		t.Errorf("The missing refresh_job should be reported, %v", errs)
+	}
+}
+
+func TestParseDecommission(t *testing.T) {
+	for _, obj := range []interface{}{
+		[]interface{}{"namenode"},
+		map[interface{}]interface{}{"exclude_job": "namenode"},
+		map[interface{}]interface{}{"exclude_file": "dfs_exclude"},
+		map[interface{}]interface{}{"refresh_args": "-refreshNodes"},
+		map[interface{}]interface{}{"refresh_job": ""},
+		map[interface{}]interface{}{"completion_probe": map[interface{}]interface{}{"type": "command", "target": "true"}},
+		map[interface{}]interface{}{"completion_probe": map[interface{}]interface{}{"type": "udp", "target": "localhost"}},
+		map[interface{}]interface{}{"exclude_hosts": "localhost"},
+	} {
+		if _, err := parseDecommission(obj); err == nil {
+			t.Errorf("Decommission %v should be invalid", obj)
+		}
+	}
+	d, err := parseDecommission(map[interface{}]interface{}{
+		"completion_probe": map[interface{}]interface{}{"type": "tcp", "target": "%{self.host}:%{self.base_port}"},
+	})
+	if err != nil || d.ExcludeJob != "" || d.CompletionProbe == nil {
+		t.Errorf("Decommission with completion probe only should be valid, %+v, err: %v", d, err)
+	}
+}
+
+func TestAddExcludeEntries(t *testing.T) {
+	for _, c := range []struct {
+		content  string
+		entries  []string
+		expected string
+	}{
+		{"", []string{"host0:20100"}, "host0:20100\n"},
+		{"# Decommissioned datanodes", []string{"host0:20100", "host1:20100"},
+			"# Decommissioned datanodes\nhost0:20100\nhost1:20100\n"},
+		{"host0:20100\nhost1:20100\n", []string{"host1:20100", "host2:20100"}, "host0:20100\nhost1:20100\nhost2:20100\n"},
+	} {
+		if content := addExcludeEntries(c.content, c.entries); content != c.expected {
+			t.Errorf("Exclude file of %q with %v should be %q, instead of %q", c.content, c.entries, c.expected, content)
+		}
+	}
+}
+
+func TestRemoveExcludeEntries(t *testing.T) {
+	for _, c := range []struct {
+		content  string
+		entries  []string
+		expected string
+	}{
+		{"host0:20100\n", []string{"host0:20100"}, ""},
+		{"# Decommissioned datanodes\nhost0:20100\nhost1:20100\n", []string{"host0:20100", "host2:20100"},
+			"# Decommissioned datanodes\nhost1:20100\n"},
+	} {
+		if content := removeExcludeEntries(c.content, c.entries); content != c.expected {
+			t.Errorf("Exclude file of %q without %v should be %q, instead of %q", c.content, c.entries, c.expected, content)
+		}
+	}
+}
+
+func TestMergeExcludeEntries(t *testing.T) {
+	cfg := `
+    cluster:
+      project: hdfs
+      cluster_name: tst-hdfs
+      main_process: /usr/bin/java
+      package_name: hadoop-2.6.5.tar.gz
+      package_md5sum: 967c24f3c15fcdd058f34923e92ce8ac
+    jobs:
+      datanode:
+        hosts:
+          - 127.0.0.1:9001/id=0/base_port=20100
+        decommission:
+          exclude_job: namenode
+          exclude_file: dfs_exclude
+      namenode:
+        hosts:
+          - 127.0.0.2:9001/id=0/base_port=20200
+    `
+	c, err := NewCluster([]string{cfg}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := c.excludeFiles("namenode"); !reflect.DeepEqual(files, []string{"dfs_exclude"}) {
+		t.Errorf("Exclude files of namenode mismatch: %v", files)
+	}
+	if files := c.excludeFiles("datanode"); len(files) != 0 {
+		t.Errorf("Datanode should have no exclude file: %v", files)
+	}
+
+	prog := &supervisor.Program{Configs: map[string]string{"dfs_exclude": "# Decommissioned datanodes"}}
+	deployed := &supervisor.Program{Configs: map[string]string{
+		"dfs_exclude": "# Decommissioned datanodes\n127.0.0.1:20100\n\n# Old comment\n"}}
+	mergeExcludeEntries([]string{"dfs_exclude"}, prog, deployed)
+	if exclude := prog.Configs["dfs_exclude"]; exclude != "# Decommissioned datanodes\n127.0.0.1:20100\n" {
+		t.Errorf("Decommissioned entries should be merged, instead of %q", exclude)
+	}
+
+	// The exclude file without any entry is untouched.
+	prog = &supervisor.Program{}
+	mergeExcludeEntries([]string{"dfs_exclude"}, prog, &supervisor.Program{Configs: map[string]string{
+		"dfs_exclude": "# Decommissioned datanodes\n"}})
+	if len(prog.Configs) != 0 {
+		t.Errorf("Exclude file without entries should not be merged, %v", prog.Configs)
+	}
+}
+
+// Fake agent serving one program, whose push_config fails if fail is true.
+type fakeExcludeAgent struct {
+	mu   sync.Mutex
+	prog supervisor.Program
+	fail bool
+}
+
+func (a *fakeExcludeAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if r.Method == "GET" {
+		if a.prog.Name == "" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"status":1,"message":"error: not found."}`))
+			return
+		}
+		json.NewEncoder(w).Encode(a.prog)
+		return
+	}
+	if a.fail {
+		w.Write([]byte(`{"status":1,"message":"error: disk is full"}`))
+		return
+	}
+	json.NewDecoder(r.Body).Decode(&a.prog)
+	w.Write([]byte(`{"status":0,"message":"` + supervisor.MESSAGE_SUCCESS + `"}`))
+}
+
+func TestPushExcludeFileReverted(t *testing.T) {
+	c := &Cluster{ClusterName: "tst-hdfs", ConfigPath: "tst-hdfs.yaml", Jobs: map[string]*Job{"namenode": {}}}
+	var agents []*fakeExcludeAgent
+	for i := 0; i < 2; i++ {
+		agent := &fakeExcludeAgent{prog: supervisor.Program{Name: "tst-hdfs", Job: "namenode", TaskId: i,
+			Configs: map[string]string{"dfs_exclude": "# Decommissioned datanodes\n"}}, fail: i == 1}
+		server := httptest.NewServer(agent)
+		defer server.Close()
+		port, _ := strconv.Atoi(server.URL[strings.LastIndex(server.URL, ":")+1:])
+		c.Jobs["namenode"].Hosts = append(c.Jobs["namenode"].Hosts,
+			&Host{Hostname: "127.0.0.1", SupervisorPort: port, TaskId: i})
+		agents = append(agents, agent)
+	}
+	j := &ConfigFileHukerJob{parallel: 10, taskTimeout: time.Minute}
+	d := &DecommissionProcedure{ExcludeJob: "namenode", ExcludeFile: "dfs_exclude"}
+	add := func(content string) string {
+		return addExcludeEntries(content, []string{"127.0.0.1:20100"})
+	}
+	if err := j.pushExcludeFile(c, d, add); err == nil || !strings.Contains(err.Error(), "disk is full") {
+		t.Fatalf("Push to task 1 should fail, err: %v", err)
+	}
+	if exclude := agents[0].prog.Configs["dfs_exclude"]; exclude != "# Decommissioned datanodes\n" {
+		t.Errorf("Exclude file of task 0 should be reverted, instead of %q", exclude)
+	}
+
+	agents[1].fail = false
+	if err := j.pushExcludeFile(c, d, add); err != nil {
+		t.Fatal(err)
+	}
+	for _, agent := range agents {
+		if exclude := agent.prog.Configs["dfs_exclude"]; exclude != "# Decommissioned datanodes\n127.0.0.1:20100\n" {
+			t.Errorf("Exclude file of task %d mismatch: %q", agent.prog.TaskId, exclude)
+		}
+	}
+}
+
+func TestKeepExcludeEntries(t *testing.T) {
+	agent := &fakeExcludeAgent{}
+	server := httptest.NewServer(agent)
+	defer server.Close()
+	supCli := supervisor.NewSupervisorCli(server.URL)
+	prog := &supervisor.Program{Name: "tst-hdfs", Job: "namenode", TaskId: 0,
+		Configs: map[string]string{"dfs_exclude": "# Decommissioned datanodes\n"}}
+
+	// Nothing to keep for the task not deployed yet.
+	if err := keepExcludeEntries(supCli, []string{"dfs_exclude"}, prog); err != nil {
+		t.Errorf("Task not deployed should be updated: %v", err)
+	}
+	if exclude := prog.Configs["dfs_exclude"]; exclude != "# Decommissioned datanodes\n" {
+		t.Errorf("Nothing should be kept, instead of %q", exclude)
+	}
+
+	agent.prog = supervisor.Program{Name: "tst-hdfs", Job: "namenode", TaskId: 0,
+		Configs: map[string]string{"dfs_exclude": "# Decommissioned datanodes\n127.0.0.1:20100\n"}}
+	if err := keepExcludeEntries(supCli, []string{"dfs_exclude"}, prog); err != nil {
+		t.Fatal(err)
+	}
+	if exclude := prog.Configs["dfs_exclude"]; exclude != "# Decommissioned datanodes\n127.0.0.1:20100\n" {
+		t.Errorf("Decommissioned entries should be kept, instead of %q", exclude)
+	}
+
+	// Any other failure is reported, even if it mentions "not found".
+	failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":1,"message":"error: supervisor db not found"}`))
+	}))
+	defer failed.Close()
+	err := keepExcludeEntries(supervisor.NewSupervisorCli(failed.URL), []string{"dfs_exclude"}, prog)
+	if err == nil || !strings.Contains(err.Error(), "Failed to keep the decommissioned entries") {
+		t.Errorf("Failure of the agent should be reported, err: %v", err)
+	}
+}
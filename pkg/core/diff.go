@@ -0,0 +1,83 @@
+package core
+
+import (
+	"fmt"
+	"github.com/openinx/huker/pkg/supervisor"
+	"github.com/openinx/huker/pkg/utils"
+	"sort"
+	"strings"
+)
+
+// Lines of context around the changed lines in the unified diff.
+const diffContextLines = 3
+
+// The differences between the program rendered from the cluster yaml and the one deployed on the agent. The task
+// needs a rolling_update if any difference is found.
+type TaskDiff struct {
+	Host *Host
+	// Unified diff of every changed config file, keyed by the file name.
+	Configs map[string]string
+	// Unified diff of the main process and its arguments, one per line.
+	Command string
+	// Unified diff of the environment variables, one <key>=<value> per line.
+	Env string
+	// Package change, such as: hadoop-2.6.5.tar.gz (md5sum: xxx) -> hadoop-2.7.1.tar.gz (md5sum: yyy)
+	Package string
+	Err     error
+}
+
+// True if the deployed program is not the same as the desired one.
+func (t *TaskDiff) NeedRollingUpdate() bool {
+	return len(t.Configs) > 0 || t.Command != "" || t.Env != "" || t.Package != ""
+}
+
+// Names of the changed config files in alphabetical order.
+func (t *TaskDiff) ConfigNames() []string {
+	var fnames []string
+	for fname := range t.Configs {
+		fnames = append(fnames, fname)
+	}
+	sort.Strings(fnames)
+	return fnames
+}
+
+// Compare the deployed program with the desired one, both of them should be rendered with the same agent root dir.
+func diffProgram(deployed, desired *supervisor.Program) *TaskDiff {
+	t := &TaskDiff{Configs: make(map[string]string)}
+	fnameSet := make(map[string]bool)
+	for fname := range deployed.Configs {
+		fnameSet[fname] = true
+	}
+	for fname := range desired.Configs {
+		fnameSet[fname] = true
+	}
+	for fname := range fnameSet {
+		diff := utils.UnifiedDiff(fname+" (deployed)", fname+" (desired)",
+			deployed.Configs[fname], desired.Configs[fname], diffContextLines)
+		if diff != "" {
+			t.Configs[fname] = diff
+		}
+	}
+
+	command := func(p *supervisor.Program) string {
+		return strings.Join(append([]string{p.Bin}, p.Args...), "\n")
+	}
+	t.Command = utils.UnifiedDiff("command (deployed)", "command (desired)",
+		command(deployed), command(desired), diffContextLines)
+
+	env := func(p *supervisor.Program) string {
+		var lines []string
+		for key, value := range p.Env {
+			lines = append(lines, key+"="+value)
+		}
+		sort.Strings(lines)
+		return strings.Join(lines, "\n")
+	}
+	t.Env = utils.UnifiedDiff("env (deployed)", "env (desired)", env(deployed), env(desired), diffContextLines)
+
+	if deployed.PkgName != desired.PkgName || deployed.PkgMD5Sum != desired.PkgMD5Sum {
+		t.Package = fmt.Sprintf("%s (md5sum: %s) -> %s (md5sum: %s)",
+			deployed.PkgName, deployed.PkgMD5Sum, desired.PkgName, desired.PkgMD5Sum)
+	}
+	return t
+}
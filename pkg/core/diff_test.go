@@ -0,0 +1,54 @@
+package core
+
+import (
+	"github.com/openinx/huker/pkg/supervisor"
+	"reflect"
+	"testing"
+)
+
+func TestDiffProgram(t *testing.T) {
+	deployed := &supervisor.Program{
+		Bin:       "java",
+		Args:      []string{"-Xmx1g", "Main"},
+		Configs:   map[string]string{"a.cfg": "k1=v1\nk2=v2", "b.cfg": "k=v", "c.cfg": "k=v"},
+		PkgName:   "hadoop-2.6.5.tar.gz",
+		PkgMD5Sum: "md5-0",
+	}
+	desired := &supervisor.Program{
+		Bin:       "java",
+		Args:      []string{"-Xmx1g", "Main"},
+		Configs:   map[string]string{"a.cfg": "k1=v1\nk2=v2", "b.cfg": "k=v", "c.cfg": "k=v"},
+		PkgName:   "hadoop-2.6.5.tar.gz",
+		PkgMD5Sum: "md5-0",
+	}
+	if d := diffProgram(deployed, desired); d.NeedRollingUpdate() {
+		t.Errorf("Same programs should have no difference: %v", d)
+	}
+
+	desired.Args = []string{"-Xmx2g", "Main"}
+	desired.Configs = map[string]string{"a.cfg": "k1=v1\nk2=v3", "c.cfg": "k=v", "d.cfg": "k=v"}
+	desired.PkgName, desired.PkgMD5Sum = "hadoop-2.7.1.tar.gz", "md5-1"
+	d := diffProgram(deployed, desired)
+	if !d.NeedRollingUpdate() {
+		t.Fatalf("Programs should have difference")
+	}
+	if names := d.ConfigNames(); !reflect.DeepEqual(names, []string{"a.cfg", "b.cfg", "d.cfg"}) {
+		t.Errorf("Changed config files mismatch: %v", names)
+	}
+	expected := "--- a.cfg (deployed)\n+++ a.cfg (desired)\n@@ -1,2 +1,2 @@\n k1=v1\n-k2=v2\n+k2=v3\n"
+	if d.Configs["a.cfg"] != expected {
+		t.Errorf("Diff of a.cfg mismatch, [%s] != [%s]", d.Configs["a.cfg"], expected)
+	}
+	expected = "--- b.cfg (deployed)\n+++ b.cfg (desired)\n@@ -1,1 +0,0 @@\n-k=v\n"
+	if d.Configs["b.cfg"] != expected {
+		t.Errorf("Diff of b.cfg mismatch, [%s] != [%s]", d.Configs["b.cfg"], expected)
+	}
+	expected = "--- command (deployed)\n+++ command (desired)\n@@ -1,3 +1,3 @@\n java\n--Xmx1g\n+-Xmx2g\n Main\n"
+	if d.Command != expected {
+		t.Errorf("Diff of command mismatch, [%s] != [%s]", d.Command, expected)
+	}
+	expected = "hadoop-2.6.5.tar.gz (md5sum: md5-0) -> hadoop-2.7.1.tar.gz (md5sum: md5-1)"
+	if d.Package != expected {
+		t.Errorf("Diff of package mismatch, [%s] != [%s]", d.Package, expected)
+	}
+}
@@ -3,9 +3,11 @@ package core
 import (
 	"bytes"
 	"fmt"
+	"github.com/openinx/huker/pkg/supervisor"
 	"github.com/openinx/huker/pkg/utils"
 	"github.com/qiniu/log"
 	"io/ioutil"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -15,10 +17,16 @@ const (
 	DEFAULT_SUPERVISOR_PORT = 9001
 	DEFAULT_BASE_PORT       = 10000
 	DEFAULT_TASK_ID         = 0
+	// Task id of host declared with id=auto, which will be assigned by NewJob.
+	AUTO_TASK_ID = -1
 )
 
 type MainEntry struct {
 	JavaClass string
+	// Module to run by python -m, such as SimpleHTTPServer.
+	Module string
+	// Script to run by python or script interpreter, relative to the job root directory.
+	Script    string
 	ExtraArgs string
 }
 
@@ -34,6 +42,18 @@ func parseMainEntry(s interface{}) (*MainEntry, error) {
 		}
 		mainEntry.JavaClass = strings.Trim(obj.(string), " ")
 	}
+	for _, key := range []string{"module", "script"} {
+		if obj, ok := meMap[key]; ok && obj != nil {
+			if !utils.IsStringType(obj) {
+				return nil, fmt.Errorf("Invalid main entry, %s is not a string. %v", key, s)
+			}
+			if key == "module" {
+				mainEntry.Module = strings.TrimSpace(obj.(string))
+			} else {
+				mainEntry.Script = strings.TrimSpace(obj.(string))
+			}
+		}
+	}
 	if obj, ok := meMap["extra_args"]; ok && obj != nil {
 		if !utils.IsStringType(obj) {
 			return nil, fmt.Errorf("Invalid main_entry, extra_args is not a string. %v", s)
@@ -48,6 +68,11 @@ func (m *MainEntry) toShell() []string {
 	if len(m.JavaClass) > 0 {
 		buf = append(buf, m.JavaClass)
 	}
+	return append(buf, m.extraArgs()...)
+}
+
+func (m *MainEntry) extraArgs() []string {
+	var buf []string
 	// TODO need to consider tab ?
 	for _, arg := range strings.Split(m.ExtraArgs, " ") {
 		if len(arg) > 0 {
@@ -83,6 +108,9 @@ func NewHost(hostKey string) (*Host, error) {
 	if len(hostAndPort) != 2 {
 		return nil, fmt.Errorf("Invalid supervisor address: %s . should be format: <hostname>:<port>", splits[0])
 	}
+	if strings.ContainsAny(hostAndPort[0], "[]") {
+		return nil, fmt.Errorf("Invalid hostname: %s, host range should be expanded by NewHosts.", hostAndPort[0])
+	}
 	host.Hostname = hostAndPort[0]
 	host.SupervisorPort, err = strconv.Atoi(hostAndPort[1])
 	if err != nil {
@@ -97,10 +125,12 @@ func NewHost(hostKey string) (*Host, error) {
 			return nil, fmt.Errorf("Invalid key-value pair: %s . shoud be format like: <key>=<value>.", hostKey)
 		}
 		host.Attributes[keyValues[0]] = keyValues[1]
-		if keyValues[0] == "id" {
+		if keyValues[0] == "id" && keyValues[1] == "auto" {
+			host.TaskId = AUTO_TASK_ID
+		} else if keyValues[0] == "id" {
 			host.TaskId, err = strconv.Atoi(keyValues[1])
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("Invalid taskId, should be integer. %s", hostKey)
 			}
 			if host.TaskId < 0 {
 				return nil, fmt.Errorf("Invalid taskId, shouldn't be negative. %s", hostKey)
@@ -109,17 +139,86 @@ func NewHost(hostKey string) (*Host, error) {
 		if keyValues[0] == "base_port" {
 			host.BasePort, err = strconv.Atoi(keyValues[1])
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("Invalid basePort, should be integer. %s", hostKey)
 			}
 			if host.BasePort <= 0 {
 				return nil, fmt.Errorf("Invalid basePort, should be positive integer. %s", hostKey)
 			}
 		}
+		if keyValues[0] == "base_port_step" {
+			if step, err := strconv.Atoi(keyValues[1]); err != nil || step < 0 {
+				return nil, fmt.Errorf("Invalid base_port_step, should be non-negative integer. %s", hostKey)
+			}
+		}
 	}
 
 	return host, nil
 }
 
+var hostRangePattern = regexp.MustCompile(`\[([0-9]+)-([0-9]+)\]`)
+
+// Expand the ranges in hostname, such as dn[01-03].example.com to dn01.example.com, dn02.example.com and
+// dn03.example.com. The numbers are padded with zeros if the lower bound starts with zero.
+func expandHostRange(hostname string) ([]string, error) {
+	loc := hostRangePattern.FindStringSubmatchIndex(hostname)
+	if loc == nil {
+		if strings.ContainsAny(hostname, "[]") {
+			return nil, fmt.Errorf("Invalid host range: %s . should be format like: dn[001-200].example.com", hostname)
+		}
+		return []string{hostname}, nil
+	}
+	lower, upper := hostname[loc[2]:loc[3]], hostname[loc[4]:loc[5]]
+	begin, _ := strconv.Atoi(lower)
+	end, _ := strconv.Atoi(upper)
+	if begin > end {
+		return nil, fmt.Errorf("Invalid host range: %s . lower bound is greater than upper bound", hostname)
+	}
+	width := 0
+	if len(lower) > 1 && lower[0] == '0' {
+		width = len(lower)
+	}
+	suffixes, err := expandHostRange(hostname[loc[1]:])
+	if err != nil {
+		return nil, err
+	}
+	var hostnames []string
+	for i := begin; i <= end; i++ {
+		for _, suffix := range suffixes {
+			hostnames = append(hostnames, fmt.Sprintf("%s%0*d%s", hostname[:loc[0]], width, i, suffix))
+		}
+	}
+	return hostnames, nil
+}
+
+// Create the hosts of a host key with range, such as dn[001-200].example.com:9001/id=auto/base_port=21000. The
+// base_port of the i-th host is stepped by i*base_port_step, and the task id is stepped by i unless it's auto.
+func NewHosts(hostKey string) ([]*Host, error) {
+	splits := strings.SplitN(hostKey, "/", 2)
+	hostnames, err := expandHostRange(splits[0])
+	if err != nil {
+		return nil, err
+	}
+	var hosts []*Host
+	for idx, hostname := range hostnames {
+		splits[0] = hostname
+		host, err := NewHost(strings.Join(splits, "/"))
+		if err != nil {
+			return nil, err
+		}
+		if host.TaskId != AUTO_TASK_ID {
+			host.TaskId += idx
+			host.Attributes["id"] = strconv.Itoa(host.TaskId)
+		}
+		if step, ok := host.Attributes["base_port_step"]; ok {
+			stepVal, _ := strconv.Atoi(step)
+			host.BasePort += idx * stepVal
+			host.Attributes["base_port"] = strconv.Itoa(host.BasePort)
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
 func (h *Host) ToHttpAddress() string {
 	return fmt.Sprintf("http://%s:%d", h.Hostname, h.SupervisorPort)
 }
@@ -142,13 +241,22 @@ func mergeConfigFiles(this, other map[string]ConfigFile) map[string]ConfigFile {
 type Job struct {
 	JobName       string
 	SuperJob      string
+	DependsOn     []string // Jobs of the same cluster to start before this one, not inherited from super_job.
+	ProcessType   string
 	Hosts         []*Host
 	JvmOpts       []string
 	JvmProperties []string
 	Classpath     []string
 	MainEntry     *MainEntry
 	ConfigFiles   map[string]ConfigFile
+	Overrides     []*TaskOverride
 	Hooks         map[string]string
+	Env           map[string]string
+	RollingUpdate RollingUpdateOptions
+	// Probes evaluated by the agent after start, inherited from super_job if the job declares none.
+	ReadinessProbes []supervisor.Probe
+	// Nil if the job can't be decommissioned, inherited from super_job if the job declares none.
+	Decommission *DecommissionProcedure
 }
 
 func NewJob(jobName string, jobMap map[interface{}]interface{}) (*Job, error) {
@@ -159,6 +267,7 @@ func NewJob(jobName string, jobMap map[interface{}]interface{}) (*Job, error) {
 		MainEntry:   &MainEntry{},
 		ConfigFiles: make(map[string]ConfigFile),
 		Hooks:       make(map[string]string),
+		Env:         make(map[string]string),
 	}
 	var err error
 	if obj, ok := jobMap["super_job"]; ok && obj != nil {
@@ -167,6 +276,18 @@ func NewJob(jobName string, jobMap map[interface{}]interface{}) (*Job, error) {
 		}
 		job.SuperJob = obj.(string)
 	}
+	if obj, ok := jobMap["depends_on"]; ok && obj != nil {
+		if utils.IsStringType(obj) {
+			job.DependsOn = []string{obj.(string)}
+		} else if job.DependsOn, err = ParseStringArray(obj); err != nil {
+			return nil, err
+		}
+	}
+	if obj, ok := jobMap["process_type"]; ok && obj != nil {
+		if job.ProcessType, err = parseProcessType(obj); err != nil {
+			return nil, err
+		}
+	}
 	if obj, ok := jobMap["jvm_opts"]; ok && obj != nil {
 		if job.JvmOpts, err = ParseStringArray(obj); err != nil {
 			return nil, err
@@ -192,6 +313,31 @@ func NewJob(jobName string, jobMap map[interface{}]interface{}) (*Job, error) {
 			}
 		}
 	}
+	if obj, ok := jobMap["env"]; ok && obj != nil {
+		if job.Env, err = parseEnv(obj); err != nil {
+			return nil, fmt.Errorf("Invalid env of job `%s`: %v", jobName, err)
+		}
+	}
+	if obj, ok := jobMap["overrides"]; ok && obj != nil {
+		if job.Overrides, err = parseOverrides(obj); err != nil {
+			return nil, err
+		}
+	}
+	if obj, ok := jobMap["rolling_update"]; ok && obj != nil {
+		if job.RollingUpdate, err = parseRollingUpdateOptions(obj); err != nil {
+			return nil, fmt.Errorf("Invalid job `%s`: %v", jobName, err)
+		}
+	}
+	if obj, ok := jobMap["readiness_probes"]; ok && obj != nil {
+		if job.ReadinessProbes, err = parseReadinessProbes(obj); err != nil {
+			return nil, fmt.Errorf("Invalid job `%s`: %v", jobName, err)
+		}
+	}
+	if obj, ok := jobMap["decommission"]; ok && obj != nil {
+		if job.Decommission, err = parseDecommission(obj); err != nil {
+			return nil, fmt.Errorf("Invalid job `%s`: %v", jobName, err)
+		}
+	}
 
 	if obj, ok := jobMap["hosts"]; ok && obj != nil {
 		hostKeys, err := ParseStringArray(obj)
@@ -199,12 +345,33 @@ func NewJob(jobName string, jobMap map[interface{}]interface{}) (*Job, error) {
 			return nil, err
 		}
 		hosts := []*Host{}
+		taskHosts := make(map[int]*Host)
+		maxTaskId := AUTO_TASK_ID
 		for _, hostKey := range hostKeys {
-			host, err := NewHost(hostKey)
+			expandedHosts, err := NewHosts(hostKey)
 			if err != nil {
 				return nil, err
 			}
-			hosts = append(hosts, host)
+			for _, host := range expandedHosts {
+				// The auto id only depends on the hosts declared before, so appending hosts won't change the ids.
+				if host.TaskId == AUTO_TASK_ID {
+					host.TaskId = maxTaskId + 1
+					host.Attributes["id"] = strconv.Itoa(host.TaskId)
+				}
+				if other, ok := taskHosts[host.TaskId]; ok && other.ToKey() == host.ToKey() {
+					// The hosts of child config are merged before the base config, so the first one overrides.
+					log.Warnf("Task %s of job `%s` is declared multiple times, use the first one.", host.ToKey(), jobName)
+					continue
+				} else if ok {
+					return nil, fmt.Errorf("Duplicated task id %d in job `%s`: %s:%d and %s:%d", host.TaskId, jobName,
+						other.Hostname, other.SupervisorPort, host.Hostname, host.SupervisorPort)
+				}
+				taskHosts[host.TaskId] = host
+				if host.TaskId > maxTaskId {
+					maxTaskId = host.TaskId
+				}
+				hosts = append(hosts, host)
+			}
 		}
 		// Sort by taskId increase.
 		sort.Slice(hosts, func(i, j int) bool {
@@ -234,37 +401,93 @@ func NewJob(jobName string, jobMap map[interface{}]interface{}) (*Job, error) {
 	return job, nil
 }
 
-func (job *Job) toShell() []string {
-	var buf []string
-	for i := range job.JvmOpts {
-		jvmOpt := strings.TrimSpace(job.JvmOpts[i])
-		if len(jvmOpt) > 0 {
-			buf = append(buf, jvmOpt)
-		}
+var jvmSizeOptPattern = regexp.MustCompile(`^-X(mx|ms|mn|ss)`)
+
+// The key of jvm option which identifies the conflicting options, such as -Xmx for -Xmx4g, -XX:UseG1GC for both
+// -XX:+UseG1GC and -XX:-UseG1GC, -XX:MaxGCPauseMillis for -XX:MaxGCPauseMillis=200, and -Dkey for -Dkey=value.
+func jvmOptKey(opt string) string {
+	if prefix := jvmSizeOptPattern.FindString(opt); prefix != "" {
+		return prefix
 	}
-	for i := range job.JvmProperties {
-		jvmPro := strings.TrimSpace(job.JvmProperties[i])
-		if len(jvmPro) > 0 {
-			buf = append(buf, fmt.Sprintf("-D%s", jvmPro))
-		}
+	if strings.HasPrefix(opt, "-XX:") {
+		name := strings.TrimLeft(strings.TrimPrefix(opt, "-XX:"), "+-")
+		return "-XX:" + strings.SplitN(name, "=", 2)[0]
+	}
+	if strings.HasPrefix(opt, "-D") {
+		return strings.SplitN(opt, "=", 2)[0]
 	}
+	return opt
+}
 
-	var classpath []string
-	for i := range job.Classpath {
-		cp := strings.TrimSpace(job.Classpath[i])
-		if len(cp) > 0 {
-			classpath = append(classpath, cp)
+// The key of jvm property, such as key for key=value.
+func jvmPropertyKey(prop string) string {
+	return strings.TrimSpace(strings.SplitN(prop, "=", 2)[0])
+}
+
+// Merge the jvm options or properties with the ones of parent by key. The items of this come first and win the
+// conflicting ones of parent, and the later one wins if a key is declared multiple times in the same list, just like
+// the jvm does for the command line.
+func mergeByKey(this, parent []string, keyFn func(string) string) []string {
+	ret := []string{}
+	index := make(map[string]int)
+	add := func(items []string) {
+		// Keys declared by the previous lists, which win the ones of this list.
+		declared := make(map[string]bool)
+		for key := range index {
+			declared[key] = true
+		}
+		for _, item := range items {
+			item = strings.TrimSpace(item)
+			if len(item) == 0 {
+				continue
+			}
+			key := keyFn(item)
+			if i, ok := index[key]; ok {
+				if !declared[key] {
+					ret[i] = item
+				}
+				continue
+			}
+			index[key] = len(ret)
+			ret = append(ret, item)
 		}
 	}
-	if len(classpath) > 0 {
-		buf = append(buf, "-cp")
-		buf = append(buf, strings.Join(classpath, ":"))
-	}
+	add(this)
+	add(parent)
+	return ret
+}
 
-	for _, s := range job.MainEntry.toShell() {
-		buf = append(buf, s)
+// Parse the environment variables of the process, such as:
+//
+//	env:
+//	  JAVA_HOME: /usr/lib/jvm/java-8
+//	  HADOOP_CONF_DIR: {{.PkgConfDir}}
+//	  HADOOP_IDENT_STRING: %{self.cluster}-%{self.job}
+func parseEnv(obj interface{}) (map[string]string, error) {
+	if !utils.IsMapType(obj) {
+		return nil, fmt.Errorf("should be a map, now: %v", obj)
 	}
-	return buf
+	env := make(map[string]string)
+	for key, value := range obj.(map[interface{}]interface{}) {
+		if !utils.IsStringType(key) || !envKeyPattern.MatchString(key.(string)) {
+			return nil, fmt.Errorf("invalid name of environment variable: %v", key)
+		}
+		if value == nil {
+			env[key.(string)] = ""
+		} else if utils.IsStringType(value) || utils.IsIntegerType(value) {
+			env[key.(string)] = fmt.Sprintf("%v", value)
+		} else {
+			return nil, fmt.Errorf("value of %s should be a string, now: %v", key, value)
+		}
+	}
+	return env, nil
+}
+
+// Build the arguments of the main process for the task by the builder of its process type, with the overrides of
+// the task applied. The overrides are skipped if the task does not exist, such as the shell job.
+func (job *Job) toShell(taskId int) []string {
+	job = job.forTask(taskId)
+	return getProcessType(job.ProcessType).buildArgs(job)
 }
 
 func (job *Job) toConfigMap() map[string]string {
@@ -290,17 +513,48 @@ func (job *Job) mergeWith(other *Job) (*Job, error) {
 		return a
 	}
 
-	// merge jvm opts
-	job.JvmOpts = mergeStringArray(job.JvmOpts, other.JvmOpts)
+	if job.ProcessType == "" {
+		job.ProcessType = other.ProcessType
+	}
 
-	// merge jvm properties.
-	job.JvmProperties = mergeStringArray(job.JvmProperties, other.JvmProperties)
+	// merge jvm opts, such as -Xmx4g of job overrides -Xmx128m of the super job.
+	job.JvmOpts = mergeByKey(job.JvmOpts, other.JvmOpts, jvmOptKey)
+
+	// merge jvm properties by key.
+	job.JvmProperties = mergeByKey(job.JvmProperties, other.JvmProperties, jvmPropertyKey)
 
 	// merge jvm classpath
 	job.Classpath = mergeStringArray(job.Classpath, other.Classpath)
 
 	// merge config files
 	job.ConfigFiles = mergeConfigFiles(job.ConfigFiles, other.ConfigFiles)
+
+	// merge env, the variables of job win.
+	if job.Env == nil {
+		job.Env = make(map[string]string)
+	}
+	for key, value := range other.Env {
+		if _, ok := job.Env[key]; !ok {
+			job.Env[key] = value
+		}
+	}
+
+	// merge rolling update settings, the ones of job win.
+	job.RollingUpdate = job.RollingUpdate.fillWith(other.RollingUpdate)
+
+	// readiness probes of the super job are used if the job declares none.
+	if len(job.ReadinessProbes) == 0 {
+		job.ReadinessProbes = other.ReadinessProbes
+	}
+	if job.Decommission == nil {
+		job.Decommission = other.Decommission
+	}
+
+	// merge overrides, the ones of job are applied after the super job's.
+	job.Overrides = append(append([]*TaskOverride{}, other.Overrides...), job.Overrides...)
+	sort.SliceStable(job.Overrides, func(i, j int) bool {
+		return !job.Overrides[i].byTaskId() && job.Overrides[j].byTaskId()
+	})
 	return job, nil
 }
 
@@ -325,14 +579,10 @@ func parseConfigFileArray(obj interface{}) ([]ConfigFile, error) {
 			log.Warnf("Configuration file has no key-value pairs. %s", key)
 			continue
 		}
-		if keyValues, err := ParseStringArray(cfMap[key]); err != nil {
+		if cf, err := parseConfigItems(cfgName, cfMap[key]); err != nil {
 			return nil, err
 		} else {
-			if cf, err := ParseConfigFile(cfgName, keyValues); err != nil {
-				return nil, err
-			} else {
-				cfgFiles = append(cfgFiles, cf)
-			}
+			cfgFiles = append(cfgFiles, cf)
 		}
 	}
 	return cfgFiles, nil
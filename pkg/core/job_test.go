@@ -1,6 +1,10 @@
 package core
 
-import "testing"
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
 
 func TestHost(t *testing.T) {
 	testCases := []struct {
@@ -44,3 +48,151 @@ func TestHost(t *testing.T) {
 		}
 	}
 }
+
+func TestNewHosts(t *testing.T) {
+	hosts, err := NewHosts("dn[008-011].example.com:9001/id=auto/base_port=21000/base_port_step=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedNames := []string{"dn008.example.com", "dn009.example.com", "dn010.example.com", "dn011.example.com"}
+	if len(hosts) != len(expectedNames) {
+		t.Fatalf("Size of hosts mismatch, %d != %d", len(hosts), len(expectedNames))
+	}
+	for idx, host := range hosts {
+		if host.Hostname != expectedNames[idx] || host.Attributes["host"] != expectedNames[idx] {
+			t.Errorf("Hostname of host#%d mismatch, %s != %s", idx, host.Hostname, expectedNames[idx])
+		}
+		if host.BasePort != 21000+idx*10 || host.Attributes["base_port"] != strconv.Itoa(21000+idx*10) {
+			t.Errorf("Base port of host#%d mismatch: %d", idx, host.BasePort)
+		}
+		if host.TaskId != AUTO_TASK_ID {
+			t.Errorf("Task id of host#%d should be auto, instead of %d", idx, host.TaskId)
+		}
+	}
+
+	// Task ids are stepped from the given id, and the range without leading zero is not padded.
+	if hosts, err = NewHosts("r[1-2]c[8-10]:9001/id=5"); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, host := range hosts {
+		keys = append(keys, host.ToKey())
+	}
+	expectedKeys := []string{"r1c8:9001/id=5", "r1c9:9001/id=6", "r1c10:9001/id=7", "r2c8:9001/id=8", "r2c9:9001/id=9", "r2c10:9001/id=10"}
+	if !reflect.DeepEqual(keys, expectedKeys) {
+		t.Errorf("Keys of hosts mismatch, %v != %v", keys, expectedKeys)
+	}
+
+	for _, hostKey := range []string{"dn[3-1]:9001", "dn[a-b]:9001", "dn[1-2:9001", "dn[1-2]:9001/base_port_step=-1"} {
+		if _, err := NewHosts(hostKey); err == nil {
+			t.Errorf("Host key %s should be invalid", hostKey)
+		}
+	}
+	if _, err := NewHost("dn[1-2]:9001"); err == nil {
+		t.Errorf("NewHost should not accept host range")
+	}
+}
+
+func TestJobHostsAutoTaskId(t *testing.T) {
+	newJob := func(hostKeys ...string) (*Job, error) {
+		var hosts []interface{}
+		for _, hostKey := range hostKeys {
+			hosts = append(hosts, hostKey)
+		}
+		return NewJob("datanode", map[interface{}]interface{}{"hosts": hosts})
+	}
+	taskIds := func(job *Job) map[string]int {
+		ret := make(map[string]int)
+		for _, host := range job.Hosts {
+			ret[host.Hostname] = host.TaskId
+			if host.Attributes["id"] != strconv.Itoa(host.TaskId) {
+				t.Errorf("Attribute id of %s mismatch, %s != %d", host.Hostname, host.Attributes["id"], host.TaskId)
+			}
+		}
+		return ret
+	}
+
+	job, err := newJob("a:9001/id=3", "dn[1-2]:9001/id=auto", "b:9001/id=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"a": 3, "dn1": 4, "dn2": 5, "b": 0}
+	if ids := taskIds(job); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Task ids mismatch, %v != %v", ids, expected)
+	}
+	if job.Hosts[0].Hostname != "b" {
+		t.Errorf("Hosts should be sorted by task id, first: %s", job.Hosts[0].Hostname)
+	}
+
+	// Appending hosts won't change the task ids of existing hosts.
+	if job, err = newJob("a:9001/id=3", "dn[1-2]:9001/id=auto", "b:9001/id=0", "dn[3-4]:9001/id=auto"); err != nil {
+		t.Fatal(err)
+	}
+	expected["dn3"], expected["dn4"] = 6, 7
+	if ids := taskIds(job); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Task ids mismatch, %v != %v", ids, expected)
+	}
+
+	_, err = newJob("dn[1-2]:9001/id=auto", "c:9001/id=1")
+	if err == nil || err.Error() != "Duplicated task id 1 in job `datanode`: dn2:9001 and c:9001" {
+		t.Errorf("Duplicated task id should be detected, but: %v", err)
+	}
+	if _, err = newJob("a:9001", "b:9001"); err == nil {
+		t.Errorf("Hosts with default task id should be duplicated")
+	}
+}
+
+func TestMergeJvmSettings(t *testing.T) {
+	parent := &Job{
+		JobName:       "base",
+		JvmOpts:       []string{"-Xmx128m", "-Xms128m", "-XX:+UseG1GC", "-XX:MaxGCPauseMillis=100", "-verbose:gc", "-Dx=1"},
+		JvmProperties: []string{"log.dir=/home/log", "java.net.preferIPv4Stack=true"},
+		MainEntry:     &MainEntry{},
+		ConfigFiles:   make(map[string]ConfigFile),
+	}
+	child := &Job{
+		JobName:       "regionserver",
+		SuperJob:      "base",
+		JvmOpts:       []string{"-Xmx4g", "-XX:-UseG1GC", "-XX:MaxGCPauseMillis=200", "-Xss1m", "-Xss2m"},
+		JvmProperties: []string{"log.dir=/data/log", "x=2"},
+		MainEntry:     &MainEntry{JavaClass: "Main"},
+		ConfigFiles:   make(map[string]ConfigFile),
+	}
+	job, err := child.mergeWith(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"-Xmx4g", "-XX:-UseG1GC", "-XX:MaxGCPauseMillis=200", "-Xss2m", "-Xms128m", "-verbose:gc", "-Dx=1"}
+	if !reflect.DeepEqual(job.JvmOpts, expected) {
+		t.Errorf("Jvm opts mismatch, %v != %v", job.JvmOpts, expected)
+	}
+	expected = []string{"log.dir=/data/log", "x=2", "java.net.preferIPv4Stack=true"}
+	if !reflect.DeepEqual(job.JvmProperties, expected) {
+		t.Errorf("Jvm properties mismatch, %v != %v", job.JvmProperties, expected)
+	}
+	// The property x=2 wins the -Dx=1 of jvm opts.
+	expected = []string{"-Xmx4g", "-XX:-UseG1GC", "-XX:MaxGCPauseMillis=200", "-Xss2m", "-Xms128m", "-verbose:gc", "-Dx=2",
+		"-Dlog.dir=/data/log", "-Djava.net.preferIPv4Stack=true", "Main"}
+	if args := job.toShell(-1); !reflect.DeepEqual(args, expected) {
+		t.Errorf("Args mismatch, %v != %v", args, expected)
+	}
+}
+
+func TestMergeByKey(t *testing.T) {
+	testCases := []struct {
+		this     []string
+		parent   []string
+		expected []string
+	}{
+		{[]string{"a=1", "b=1"}, []string{"b=2", "c=2"}, []string{"a=1", "b=1", "c=2"}},
+		// The later one wins in the same list, and the ones of this win the parent.
+		{[]string{"a=1", "a=2"}, []string{"a=3", "a=4"}, []string{"a=2"}},
+		{[]string{"a=1"}, []string{"b=1", "c=1", "b=2", " ", "b=3"}, []string{"a=1", "b=3", "c=1"}},
+		{nil, []string{"b=1", "b=2"}, []string{"b=2"}},
+	}
+	for _, tc := range testCases {
+		if merged := mergeByKey(tc.this, tc.parent, jvmPropertyKey); !reflect.DeepEqual(merged, tc.expected) {
+			t.Errorf("Merge %v with parent %v, %v != %v", tc.this, tc.parent, merged, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openinx/huker/pkg"
+	"github.com/openinx/huker/pkg/utils"
+	"github.com/qiniu/log"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The lease expires if the holder doesn't renew it in time, such as the process is killed.
+const defaultLeaseTTL = 60 * time.Second
+
+// Key of the lock, every job of a cluster is locked separately.
+type JobKey struct {
+	Project string
+	Cluster string
+	Job     string
+}
+
+func (k JobKey) String() string {
+	return k.Project + "/" + k.Cluster + "/" + k.Job
+}
+
+// The lock of job held by an operation, which is renewed by the holder until released.
+type Lease struct {
+	JobKey
+	Holder   string    `json:"holder"`
+	Action   string    `json:"action"`
+	Token    string    `json:"token"`
+	Acquired time.Time `json:"acquired"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Locks of the jobs, every lease is a <project>.<cluster>.<job>.json file under the lock dir. The leases are read and
+// written with the flock of <lock-dir>/.lock held, so the processes sharing the lock dir won't race.
+type LockManager struct {
+	dir string
+	ttl time.Duration
+}
+
+func NewLockManager(dir string) *LockManager {
+	return &LockManager{dir: dir, ttl: defaultLeaseTTL}
+}
+
+// The lock manager of huker.lock.dir in huker.yaml, default: ~/.huker/locks
+func NewDefaultLockManager() *LockManager {
+	dir := path.Join(utils.LocalHukerDir(), "locks")
+	if cfg, err := pkg.NewHukerConfig(path.Join(utils.GetHukerDir(), "conf", "huker.yaml")); err != nil {
+		log.Warnf("Failed to load huker.yaml, use the lock dir %s: %v", dir, err)
+	} else if cfgDir := cfg.Get(pkg.HukerLockDir); cfgDir != "" {
+		dir = cfgDir
+	}
+	return NewLockManager(dir)
+}
+
+func (m *LockManager) leaseFile(key JobKey) string {
+	return path.Join(m.dir, fmt.Sprintf("%s.%s.%s.json", key.Project, key.Cluster, key.Job))
+}
+
+func (m *LockManager) withDirLock(fn func() error) error {
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path.Join(m.dir, ".lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("Failed to lock %s: %v", m.dir, err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return fn()
+}
+
+// Return nil if the job is not locked.
+func (m *LockManager) readLease(key JobKey) (*Lease, error) {
+	data, err := ioutil.ReadFile(m.leaseFile(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	lease := &Lease{}
+	if err := json.Unmarshal(data, lease); err != nil {
+		return nil, fmt.Errorf("Invalid lease %s: %v", m.leaseFile(key), err)
+	}
+	return lease, nil
+}
+
+func (m *LockManager) writeLease(lease *Lease) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	tmpFile := m.leaseFile(lease.JobKey) + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, m.leaseFile(lease.JobKey))
+}
+
+// Lock all the jobs for the action, or none of them if any job is locked by an unexpired lease of others. The lease
+// of others is broken if force is true. The leases are renewed in background until the returned function is called
+// to release them.
+func (m *LockManager) Acquire(keys []JobKey, holder, action string, force bool) (func(), error) {
+	keys = append([]JobKey{}, keys...)
+	sort.Slice(keys, func(i, k int) bool {
+		return keys[i].String() < keys[k].String()
+	})
+	now := time.Now()
+	token := fmt.Sprintf("%d-%d", os.Getpid(), now.UnixNano())
+	err := m.withDirLock(func() error {
+		for _, key := range keys {
+			lease, err := m.readLease(key)
+			if err != nil {
+				return err
+			}
+			if lease == nil || now.After(lease.Expires) {
+				continue
+			}
+			if !force {
+				return fmt.Errorf("Job %s is locked by %s for %s since %s until %s, retry later or run with "+
+					"--force-unlock if the holder is gone", key, lease.Holder, lease.Action,
+					lease.Acquired.Format("2006-01-02 15:04:05"), lease.Expires.Format("2006-01-02 15:04:05"))
+			}
+			log.Warnf("Force to unlock job %s, which is locked by %s for %s", key, lease.Holder, lease.Action)
+		}
+		for _, key := range keys {
+			lease := &Lease{JobKey: key, Holder: holder, Action: action, Token: token, Acquired: now,
+				Expires: now.Add(m.ttl)}
+			if err := m.writeLease(lease); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(m.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.renew(keys, token); err != nil {
+					log.Errorf("Failed to renew the locks of %s: %v", action, err)
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		wg.Wait()
+		if err := m.release(keys, token); err != nil {
+			log.Errorf("Failed to release the locks of %s: %v", action, err)
+		}
+	}, nil
+}
+
+// Extend the leases still held by the token.
+func (m *LockManager) renew(keys []JobKey, token string) error {
+	return m.withDirLock(func() error {
+		for _, key := range keys {
+			lease, err := m.readLease(key)
+			if err != nil {
+				return err
+			}
+			if lease == nil || lease.Token != token {
+				log.Errorf("Lost the lock of job %s, which may be broken by --force-unlock", key)
+				continue
+			}
+			lease.Expires = time.Now().Add(m.ttl)
+			if err := m.writeLease(lease); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Remove the leases still held by the token.
+func (m *LockManager) release(keys []JobKey, token string) error {
+	return m.withDirLock(func() error {
+		for _, key := range keys {
+			lease, err := m.readLease(key)
+			if err != nil {
+				return err
+			}
+			if lease != nil && lease.Token == token {
+				if err := os.Remove(m.leaseFile(key)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// HukerJob which locks the operated jobs during every operation changing the tasks, so the conflicting operations of
+// other operators fail fast.
+type lockedHukerJob struct {
+	HukerJob
+	locks  *LockManager
+	holder string
+	force  bool
+}
+
+func NewLockedHukerJob(h HukerJob, locks *LockManager, holder string, force bool) HukerJob {
+	return &lockedHukerJob{HukerJob: h, locks: locks, holder: holder, force: force}
+}
+
+func (l *lockedHukerJob) lockJob(action, project, cluster, job string,
+	operate func() ([]TaskResult, error)) ([]TaskResult, error) {
+	release, err := l.locks.Acquire([]JobKey{{project, cluster, job}}, l.holder, action, l.force)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return operate()
+}
+
+// Lock the job and the exclude_job of its decommission procedure, whose deployed exclude file is rewritten by the
+// operation.
+func (l *lockedHukerJob) lockDecommission(action, project, cluster, job string,
+	operate func() ([]TaskResult, error)) ([]TaskResult, error) {
+	keys := []JobKey{{project, cluster, job}}
+	clusters, err := l.HukerJob.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range clusters {
+		if c.Project != project || c.ClusterName != cluster || c.Jobs[job] == nil {
+			continue
+		}
+		if d := c.Jobs[job].Decommission; d != nil && d.ExcludeJob != "" && d.ExcludeJob != job {
+			keys = append(keys, JobKey{project, cluster, d.ExcludeJob})
+		}
+	}
+	release, err := l.locks.Acquire(keys, l.holder, action, l.force)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return operate()
+}
+
+// Lock all jobs of the cluster and its dependencies.
+func (l *lockedHukerJob) lockCluster(action, project, cluster string,
+	operate func() ([]JobResult, error)) ([]JobResult, error) {
+	steps, err := l.HukerJob.ClusterSteps(project, cluster)
+	if err != nil {
+		return nil, err
+	}
+	var keys []JobKey
+	for _, step := range steps {
+		keys = append(keys, JobKey{step.Project, step.Cluster, step.Job})
+	}
+	release, err := l.locks.Acquire(keys, l.holder, action, l.force)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return operate()
+}
+
+func (l *lockedHukerJob) Install(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return l.lockJob("install", project, cluster, job, func() ([]TaskResult, error) {
+		return l.HukerJob.Install(project, cluster, job, taskId)
+	})
+}
+
+func (l *lockedHukerJob) Bootstrap(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return l.lockJob("bootstrap", project, cluster, job, func() ([]TaskResult, error) {
+		return l.HukerJob.Bootstrap(project, cluster, job, taskId)
+	})
+}
+
+func (l *lockedHukerJob) Start(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return l.lockJob("start", project, cluster, job, func() ([]TaskResult, error) {
+		return l.HukerJob.Start(project, cluster, job, taskId)
+	})
+}
+
+func (l *lockedHukerJob) Stop(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return l.lockJob("stop", project, cluster, job, func() ([]TaskResult, error) {
+		return l.HukerJob.Stop(project, cluster, job, taskId)
+	})
+}
+
+func (l *lockedHukerJob) Restart(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return l.lockJob("restart", project, cluster, job, func() ([]TaskResult, error) {
+		return l.HukerJob.Restart(project, cluster, job, taskId)
+	})
+}
+
+func (l *lockedHukerJob) RollingUpdate(project, cluster, job string, taskId int,
+	opts RollingUpdateOptions) ([]TaskResult, error) {
+	return l.lockJob("rolling_update", project, cluster, job, func() ([]TaskResult, error) {
+		return l.HukerJob.RollingUpdate(project, cluster, job, taskId, opts)
+	})
+}
+
+func (l *lockedHukerJob) Canary(project, cluster, job string, opts CanaryOptions) ([]TaskResult, error) {
+	return l.lockJob("canary", project, cluster, job, func() ([]TaskResult, error) {
+		return l.HukerJob.Canary(project, cluster, job, opts)
+	})
+}
+
+func (l *lockedHukerJob) Decommission(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return l.lockDecommission("decommission", project, cluster, job, func() ([]TaskResult, error) {
+		return l.HukerJob.Decommission(project, cluster, job, taskId)
+	})
+}
+
+func (l *lockedHukerJob) Recommission(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return l.lockDecommission("recommission", project, cluster, job, func() ([]TaskResult, error) {
+		return l.HukerJob.Recommission(project, cluster, job, taskId)
+	})
+}
+
+func (l *lockedHukerJob) Cleanup(project, cluster, job string, taskId int) ([]TaskResult, error) {
+	return l.lockJob("cleanup", project, cluster, job, func() ([]TaskResult, error) {
+		return l.HukerJob.Cleanup(project, cluster, job, taskId)
+	})
+}
+
+func (l *lockedHukerJob) BootstrapCluster(project, cluster string) ([]JobResult, error) {
+	return l.lockCluster("bootstrap-cluster", project, cluster, func() ([]JobResult, error) {
+		return l.HukerJob.BootstrapCluster(project, cluster)
+	})
+}
+
+func (l *lockedHukerJob) StartCluster(project, cluster string) ([]JobResult, error) {
+	return l.lockCluster("start-cluster", project, cluster, func() ([]JobResult, error) {
+		return l.HukerJob.StartCluster(project, cluster)
+	})
+}
+
+func (l *lockedHukerJob) StopCluster(project, cluster string) ([]JobResult, error) {
+	return l.lockCluster("stop-cluster", project, cluster, func() ([]JobResult, error) {
+		return l.HukerJob.StopCluster(project, cluster)
+	})
+}
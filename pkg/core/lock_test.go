@@ -0,0 +1,97 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "locks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m := NewLockManager(dir)
+	m.ttl = 300 * time.Millisecond
+
+	namenode := JobKey{"hdfs", "test-hdfs", "namenode"}
+	datanode := JobKey{"hdfs", "test-hdfs", "datanode"}
+	release, err := m.Acquire([]JobKey{namenode}, "alice@host0 (cli)", "rolling_update", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The lease is renewed in background, so it's still held after the ttl.
+	time.Sleep(2 * m.ttl)
+	_, err = m.Acquire([]JobKey{datanode, namenode}, "10.0.0.1 (dashboard)", "stop-cluster", false)
+	if err == nil || !strings.Contains(err.Error(), "Job hdfs/test-hdfs/namenode is locked by alice@host0 (cli) for rolling_update") {
+		t.Fatalf("Conflicting operation should fail, err: %v", err)
+	}
+	if lease, err := m.readLease(datanode); err != nil || lease != nil {
+		t.Errorf("No job should be locked by the failed operation, lease: %v, err: %v", lease, err)
+	}
+
+	// Break the lock by force, the previous holder won't release the lock of others.
+	releaseByForce, err := m.Acquire([]JobKey{namenode}, "bob@host1 (cli)", "stop", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if lease, err := m.readLease(namenode); err != nil || lease == nil || lease.Holder != "bob@host1 (cli)" {
+		t.Errorf("Job should be locked by bob, lease: %v, err: %v", lease, err)
+	}
+	releaseByForce()
+	if lease, err := m.readLease(namenode); err != nil || lease != nil {
+		t.Errorf("Job should be unlocked after released, lease: %v, err: %v", lease, err)
+	}
+
+	// The expired lease of a gone holder is taken over.
+	if err := m.writeLease(&Lease{JobKey: namenode, Holder: "gone", Token: "0-0", Acquired: time.Now(),
+		Expires: time.Now().Add(-time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	if release, err = m.Acquire([]JobKey{namenode}, "bob@host1 (cli)", "start", false); err != nil {
+		t.Fatalf("Expired lease should be taken over, err: %v", err)
+	}
+	release()
+}
+
+func TestLockedHukerJob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "locks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m := NewLockManager(dir)
+
+	release, err := m.Acquire([]JobKey{{"hdfs", "test-hdfs", "namenode"}}, "alice@host0 (cli)", "rolling_update", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	h := NewLockedHukerJob(&fakeHukerJob{}, m, "10.0.0.1 (dashboard)", false)
+	if _, err := h.Restart("hdfs", "test-hdfs", "namenode", 0); err == nil {
+		t.Errorf("Restart the locked job should fail")
+	}
+	if results, err := h.Restart("hdfs", "test-hdfs", "datanode", 0); err != nil || len(results) != 1 {
+		t.Errorf("Restart the other job should succeed, results: %v, err: %v", results, err)
+	}
+	if lease, err := m.readLease(JobKey{"hdfs", "test-hdfs", "datanode"}); err != nil || lease != nil {
+		t.Errorf("Lock should be released after the operation, lease: %v, err: %v", lease, err)
+	}
+	// Decommission the datanode rewrites the exclude file of namenode, so both of them are locked.
+	if _, err := h.Decommission("hdfs", "test-hdfs", "datanode", 0); err == nil ||
+		!strings.Contains(err.Error(), "Job hdfs/test-hdfs/namenode is locked") {
+		t.Errorf("Decommission with the exclude_job locked should fail, err: %v", err)
+	}
+	if _, err := h.Decommission("hdfs", "test-hbase", "regionserver", 0); err != nil {
+		t.Errorf("Decommission without exclude_job should succeed, err: %v", err)
+	}
+	if _, err := NewLockedHukerJob(&fakeHukerJob{}, m, "bob@host1 (cli)", true).Restart("hdfs", "test-hdfs",
+		"namenode", 0); err != nil {
+		t.Errorf("Restart with force unlock should succeed, err: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Sort the jobs of cluster topologically by their depends_on, so a job always comes after the jobs it depends on.
+// The jobs without dependency between each other are sorted by name. Cycles and unknown jobs are rejected.
+func (c *Cluster) JobOrder() ([]string, error) {
+	var jobNames []string
+	for jobName := range c.Jobs {
+		jobNames = append(jobNames, jobName)
+	}
+	sort.Strings(jobNames)
+
+	var order []string
+	done := make(map[string]bool)
+	visiting := make(map[string]bool)
+	var visit func(jobName string, chain []string) error
+	visit = func(jobName string, chain []string) error {
+		if done[jobName] {
+			return nil
+		}
+		chain = append(chain, jobName)
+		if visiting[jobName] {
+			return fmt.Errorf("Cyclic depends_on of jobs: %s", strings.Join(chain, " -> "))
+		}
+		visiting[jobName] = true
+		for _, dep := range c.Jobs[jobName].DependsOn {
+			if _, ok := c.Jobs[dep]; !ok {
+				return fmt.Errorf("Job `%s` depends on unknown job `%s`", jobName, dep)
+			}
+			if err := visit(dep, chain); err != nil {
+				return err
+			}
+		}
+		delete(visiting, jobName)
+		done[jobName] = true
+		order = append(order, jobName)
+		return nil
+	}
+	for _, jobName := range jobNames {
+		if err := visit(jobName, []string{}); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// A job to operate when bootstrap, start or stop the whole cluster.
+type ClusterStep struct {
+	Project string
+	Cluster string
+	Job     string
+}
+
+// Project and cluster name of the cluster, which is defined by <config-root-dir>/<project>/<cluster>.yaml
+func clusterKey(configRootDir string, c *Cluster) (string, string, error) {
+	relPath, err := filepath.Rel(configRootDir, c.ConfigPath)
+	parts := strings.Split(filepath.ToSlash(relPath), "/")
+	if err != nil || len(parts) != 2 || !strings.HasSuffix(parts[1], ".yaml") {
+		return "", "", fmt.Errorf("Cluster %s is not defined under %s", c.ConfigPath, configRootDir)
+	}
+	return parts[0], strings.TrimSuffix(parts[1], ".yaml"), nil
+}
+
+// List the jobs of the cluster and all its dependencies in the order to start them: the dependencies come before
+// the cluster, and the jobs of a cluster are in the order of depends_on. The jobs without hosts, such as shell jobs,
+// are skipped. The order is reversed for stopping.
+func clusterSteps(configRootDir string, c *Cluster, reverse bool) ([]ClusterStep, error) {
+	var steps []ClusterStep
+	done := make(map[string]bool)
+	visiting := make(map[string]bool)
+	var visit func(c *Cluster) error
+	visit = func(c *Cluster) error {
+		project, cluster, err := clusterKey(configRootDir, c)
+		if err != nil {
+			return err
+		}
+		key := project + "/" + cluster
+		if done[key] {
+			return nil
+		}
+		if visiting[key] {
+			return fmt.Errorf("Cyclic dependencies of cluster %s", key)
+		}
+		visiting[key] = true
+		for _, dep := range c.Dependencies {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		jobNames, err := c.JobOrder()
+		if err != nil {
+			return err
+		}
+		for _, jobName := range jobNames {
+			if len(c.Jobs[jobName].Hosts) > 0 {
+				steps = append(steps, ClusterStep{Project: project, Cluster: cluster, Job: jobName})
+			}
+		}
+		delete(visiting, key)
+		done[key] = true
+		return nil
+	}
+	if err := visit(c); err != nil {
+		return nil, err
+	}
+	if reverse {
+		for i, k := 0, len(steps)-1; i < k; i, k = i+1, k-1 {
+			steps[i], steps[k] = steps[k], steps[i]
+		}
+	}
+	return steps, nil
+}
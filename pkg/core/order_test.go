@@ -0,0 +1,114 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJobOrder(t *testing.T) {
+	newCluster := func(jobs string) (*Cluster, error) {
+		return NewCluster([]string{`
+    cluster:
+      project: hbase
+      cluster_name: test-hbase
+      main_process: /usr/bin/java
+      package_name: hbase-1.2.6-bin.tar.gz
+      package_md5sum: 0e1e2aacbc6a5a8ef06e6a9d26e3ae31
+    jobs:` + jobs}, nil)
+	}
+	c, err := newCluster(`
+      regionserver:
+        depends_on:
+          - master
+      thrift:
+        depends_on:
+          - regionserver
+          - master
+      master:
+        depends_on: zkproxy
+      zkproxy:
+        hosts: []
+      backup:
+        hosts: []`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := c.JobOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"backup", "zkproxy", "master", "regionserver", "thrift"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Job order mismatch, %v != %v", order, expected)
+	}
+
+	if _, err := newCluster(`
+      regionserver:
+        depends_on:
+          - master
+      master:
+        depends_on:
+          - regionserver`); err == nil || err.Error() != "Cyclic depends_on of jobs: master -> regionserver -> master" {
+		t.Errorf("Cyclic depends_on should be rejected, err: %v", err)
+	}
+	if _, err := newCluster(`
+      regionserver:
+        depends_on:
+          - hmaster`); err == nil || err.Error() != "Job `regionserver` depends on unknown job `hmaster`" {
+		t.Errorf("Unknown depends_on should be rejected, err: %v", err)
+	}
+}
+
+func TestClusterSteps(t *testing.T) {
+	newJob := func(hosts int, dependsOn ...string) *Job {
+		job := &Job{DependsOn: dependsOn}
+		for i := 0; i < hosts; i++ {
+			job.Hosts = append(job.Hosts, &Host{TaskId: i})
+		}
+		return job
+	}
+	zk := &Cluster{
+		ConfigPath: "/conf/zookeeper/test-zk.yaml",
+		Jobs:       map[string]*Job{"zkServer": newJob(3), "shell": newJob(0)},
+	}
+	hdfs := &Cluster{
+		ConfigPath:   "/conf/hdfs/test-hdfs.yaml",
+		Jobs:         map[string]*Job{"datanode": newJob(3, "namenode"), "namenode": newJob(1)},
+		Dependencies: []*Cluster{zk},
+	}
+	hbase := &Cluster{
+		ConfigPath:   "/conf/hbase/test-hbase.yaml",
+		Jobs:         map[string]*Job{"regionserver": newJob(3, "master"), "master": newJob(1)},
+		Dependencies: []*Cluster{zk, hdfs},
+	}
+
+	steps, err := clusterSteps("/conf", hbase, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ClusterStep{
+		{"zookeeper", "test-zk", "zkServer"},
+		{"hdfs", "test-hdfs", "namenode"},
+		{"hdfs", "test-hdfs", "datanode"},
+		{"hbase", "test-hbase", "master"},
+		{"hbase", "test-hbase", "regionserver"},
+	}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("Steps to start mismatch, %v != %v", steps, expected)
+	}
+
+	steps, err = clusterSteps("/conf", hbase, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range steps {
+		if steps[i] != expected[len(expected)-1-i] {
+			t.Errorf("Steps to stop should be reversed, %v", steps)
+			break
+		}
+	}
+
+	if _, err := clusterSteps("/other", hbase, false); err == nil {
+		t.Errorf("Cluster not defined under config root dir should be rejected")
+	}
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"fmt"
+	"github.com/openinx/huker/pkg/utils"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Override of the config keys and jvm settings for the tasks of a job, which is keyed by task id or by a selector
+// of host attributes, such as:
+//
+//	overrides:
+//	  3:
+//	    jvm_opts:
+//	      - -Xmx8g
+//	  disk=ssd,rack=r1:
+//	    config:
+//	      hdfs-site.xml:
+//	        - dfs.datanode.data.dir=/ssd0,/ssd1
+//
+// The keys of config files, jvm options and properties replace the same keys of the job, and the others extend them.
+type TaskOverride struct {
+	Key           string
+	TaskId        int
+	Selector      map[string]string
+	JvmOpts       []string
+	JvmProperties []string
+	ConfigFiles   map[string]ConfigFile
+}
+
+func (o *TaskOverride) byTaskId() bool {
+	return o.Selector == nil
+}
+
+func (o *TaskOverride) match(host *Host) bool {
+	if o.byTaskId() {
+		return o.TaskId == host.TaskId
+	}
+	for key, value := range o.Selector {
+		if host.Attributes[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func parseOverrideKey(o *TaskOverride, key interface{}) error {
+	if utils.IsIntegerType(key) {
+		o.Key, o.TaskId = strconv.Itoa(key.(int)), key.(int)
+		return nil
+	}
+	if !utils.IsStringType(key) {
+		return fmt.Errorf("Invalid override key %v, should be task id or host attribute selector", key)
+	}
+	o.Key = strings.TrimSpace(key.(string))
+	if taskId, err := strconv.Atoi(o.Key); err == nil {
+		o.TaskId = taskId
+		return nil
+	}
+	o.Selector = make(map[string]string)
+	for _, cond := range strings.Split(o.Key, ",") {
+		parts := strings.SplitN(strings.TrimSpace(cond), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("Invalid override selector `%s`, should be format like: <key>=<value>,<key>=<value>", o.Key)
+		}
+		o.Selector[parts[0]] = parts[1]
+	}
+	return nil
+}
+
+// Parse the overrides section of a job. The overrides by selector are applied before the ones by task id, so the
+// more specific one wins.
+func parseOverrides(obj interface{}) ([]*TaskOverride, error) {
+	if !utils.IsMapType(obj) {
+		return nil, fmt.Errorf("Invalid overrides, should be a map. %v", obj)
+	}
+	var overrides []*TaskOverride
+	for key, value := range obj.(map[interface{}]interface{}) {
+		o := &TaskOverride{ConfigFiles: make(map[string]ConfigFile)}
+		if err := parseOverrideKey(o, key); err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		if !utils.IsMapType(value) {
+			return nil, fmt.Errorf("Invalid override `%s`, should be a map. %v", o.Key, value)
+		}
+		valueMap := value.(map[interface{}]interface{})
+		var err error
+		if obj, ok := valueMap["jvm_opts"]; ok && obj != nil {
+			if o.JvmOpts, err = ParseStringArray(obj); err != nil {
+				return nil, err
+			}
+		}
+		if obj, ok := valueMap["jvm_properties"]; ok && obj != nil {
+			if o.JvmProperties, err = ParseStringArray(obj); err != nil {
+				return nil, err
+			}
+		}
+		if obj, ok := valueMap["config"]; ok && obj != nil {
+			confFiles, err := parseConfigFileArray(obj)
+			if err != nil {
+				return nil, err
+			}
+			for i := range confFiles {
+				o.ConfigFiles[confFiles[i].GetConfigName()] = confFiles[i]
+			}
+		}
+		overrides = append(overrides, o)
+	}
+	sort.Slice(overrides, func(i, j int) bool {
+		if overrides[i].byTaskId() != overrides[j].byTaskId() {
+			return !overrides[i].byTaskId()
+		}
+		if overrides[i].byTaskId() {
+			return overrides[i].TaskId < overrides[j].TaskId
+		}
+		return overrides[i].Key < overrides[j].Key
+	})
+	return overrides, nil
+}
+
+// Return the job with the matched overrides of the given task applied. The job itself is returned if no override
+// matches, and it's never modified.
+func (job *Job) forTask(taskId int) *Job {
+	host, ok := job.GetHost(taskId)
+	if !ok || len(job.Overrides) == 0 {
+		return job
+	}
+	taskJob := *job
+	taskJob.ConfigFiles = make(map[string]ConfigFile)
+	for fname, cfg := range job.ConfigFiles {
+		taskJob.ConfigFiles[fname] = cfg
+	}
+	for _, o := range job.Overrides {
+		if !o.match(host) {
+			continue
+		}
+		// Keep the order of the job, the conflicting ones are replaced in place.
+		taskJob.JvmOpts = mergeByKey(append(append([]string{}, taskJob.JvmOpts...), o.JvmOpts...), nil, jvmOptKey)
+		taskJob.JvmProperties = mergeByKey(append(append([]string{}, taskJob.JvmProperties...), o.JvmProperties...),
+			nil, jvmPropertyKey)
+		for fname, cfg := range o.ConfigFiles {
+			if base, ok := taskJob.ConfigFiles[fname]; ok {
+				taskJob.ConfigFiles[fname] = cfg.mergeWith(base)
+			} else {
+				taskJob.ConfigFiles[fname] = cfg
+			}
+		}
+	}
+	return &taskJob
+}
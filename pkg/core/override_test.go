@@ -0,0 +1,109 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTaskOverrides(t *testing.T) {
+	base := `
+    cluster:
+      project: hdfs
+      cluster_name: tst-hdfs
+      main_process: /usr/bin/java
+      package_name: hadoop-2.6.5.tar.gz
+      package_md5sum: 967c24f3c15fcdd058f34923e92ce8ac
+    jobs:
+      datanode:
+        jvm_opts:
+          - -Xmx4g
+        jvm_properties:
+          - log.dir=/home/log
+        config:
+          hdfs-site.cfg:
+            - dfs.datanode.data.dir=/data0
+            - dfs.replication=3
+        overrides:
+          disk=ssd:
+            config:
+              hdfs-site.cfg:
+                - dfs.datanode.data.dir=/ssd0
+    `
+	child := `
+    jobs:
+      datanode:
+        hosts:
+          - 127.0.0.1:9001/id=0/base_port=20100
+          - 127.0.0.2:9001/id=1/base_port=20100/disk=ssd
+          - 127.0.0.3:9001/id=2/base_port=20100/disk=ssd
+        overrides:
+          disk=ssd:
+            config:
+              hdfs-site.cfg:
+                - dfs.datanode.data.dir=/ssd0,/ssd1
+          2:
+            jvm_opts:
+              - -XX:+UseG1GC
+              - -Xmx8g
+            jvm_properties:
+              - log.dir=/ssd0/log
+              - gc.log=true
+            config:
+              hdfs-site.cfg:
+                - dfs.datanode.data.dir=/ssd0,/ssd1,/ssd2
+                - dfs.datanode.du.reserved=%{self.base_port}
+    `
+	c, err := NewCluster([]string{child, base}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := c.Jobs["datanode"]
+	expectedCfgs := []string{
+		"dfs.datanode.data.dir=/data0\ndfs.replication=3",
+		"dfs.datanode.data.dir=/ssd0,/ssd1\ndfs.replication=3",
+		"dfs.datanode.data.dir=/ssd0,/ssd1,/ssd2\ndfs.replication=3\ndfs.datanode.du.reserved=20100",
+	}
+	expectedArgs := [][]string{
+		{"-Xmx4g", "-Dlog.dir=/home/log"},
+		{"-Xmx4g", "-Dlog.dir=/home/log"},
+		{"-Xmx8g", "-XX:+UseG1GC", "-Dlog.dir=/ssd0/log", "-Dgc.log=true"},
+	}
+	for taskId := 0; taskId < 3; taskId++ {
+		cfgMap, err := c.RenderConfigFiles(job, taskId, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfgMap["hdfs-site.cfg"] != expectedCfgs[taskId] {
+			t.Errorf("Config of task %d mismatch, [%s] != [%s]", taskId, cfgMap["hdfs-site.cfg"], expectedCfgs[taskId])
+		}
+		if args := job.toShell(taskId); !reflect.DeepEqual(args, expectedArgs[taskId]) {
+			t.Errorf("Args of task %d mismatch, %v != %v", taskId, args, expectedArgs[taskId])
+		}
+	}
+	// The job itself should not be modified by overrides.
+	if len(job.JvmOpts) != 1 || len(job.ConfigFiles["hdfs-site.cfg"].ToKeyValue()) != 2 {
+		t.Errorf("Job should not be modified, jvm_opts: %v, config: %v", job.JvmOpts, job.ConfigFiles["hdfs-site.cfg"])
+	}
+	if errs := c.Validate(); len(errs) != 0 {
+		t.Errorf("Validate should have no errors, but: %v", errs)
+	}
+
+	c, err = NewCluster([]string{child + `
+          5:
+            jvm_opts:
+              - -Xmx8g`, base}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := c.Validate()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "Override `5` matches no task") {
+		t.Errorf("Override of non-existent task should be invalid, but: %v", errs)
+	}
+
+	for _, overrides := range []string{"a:\n            jvm_opts: []", "=b:\n            jvm_opts: []"} {
+		if _, err := NewCluster([]string{child + "\n          " + overrides, base}, nil); err == nil {
+			t.Errorf("Invalid override selector should be failed: %s", overrides)
+		}
+	}
+}
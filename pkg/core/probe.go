@@ -0,0 +1,126 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/openinx/huker/pkg/supervisor"
+	"github.com/openinx/huker/pkg/utils"
+	"time"
+)
+
+const (
+	defaultProbeTimeoutSeconds  = 60
+	defaultProbeIntervalSeconds = 1
+)
+
+// Parse the readiness probes of job, which are evaluated by the agent in order after the process started, such as:
+//
+//	readiness_probes:
+//	  - type: tcp
+//	    target: %{self.host}:%{self.base_port}
+//	  - type: http
+//	    target: http://%{self.host}:%{self.base_port+1}/jmx
+//	    http_status: 200
+//	    timeout_seconds: 120
+//	  - type: command
+//	    target: bin/hdfs dfsadmin -safemode get | grep OFF
+//	    interval_seconds: 5
+//
+// The timeout_seconds is 60 and the interval_seconds is 1 by default.
+func parseReadinessProbes(obj interface{}) ([]supervisor.Probe, error) {
+	if !utils.IsArrayType(obj) && !utils.IsSliceType(obj) {
+		return nil, fmt.Errorf("Invalid readiness_probes, should be a list. %v", obj)
+	}
+	var probes []supervisor.Probe
+	for _, item := range obj.([]interface{}) {
+		if !utils.IsMapType(item) {
+			return nil, fmt.Errorf("Invalid readiness probe, should be a map. %v", item)
+		}
+		probe := supervisor.Probe{
+			TimeoutSeconds:  defaultProbeTimeoutSeconds,
+			IntervalSeconds: defaultProbeIntervalSeconds,
+		}
+		for key, value := range item.(map[interface{}]interface{}) {
+			switch key {
+			case "type", "target":
+				if !utils.IsStringType(value) || value.(string) == "" {
+					return nil, fmt.Errorf("Invalid readiness probe `%v`, should be a non-empty string. %v", key, value)
+				}
+				if key == "type" {
+					probe.Type = value.(string)
+				} else {
+					probe.Target = value.(string)
+				}
+			case "http_status", "timeout_seconds", "interval_seconds":
+				if !utils.IsIntegerType(value) || value.(int) <= 0 {
+					return nil, fmt.Errorf("Invalid readiness probe `%v`, should be a positive int. %v", key, value)
+				}
+				if key == "http_status" {
+					probe.HTTPStatus = value.(int)
+				} else if key == "timeout_seconds" {
+					probe.TimeoutSeconds = value.(int)
+				} else {
+					probe.IntervalSeconds = value.(int)
+				}
+			default:
+				return nil, fmt.Errorf("Unknown readiness probe `%v`", key)
+			}
+		}
+		switch probe.Type {
+		case supervisor.ProbeTCP, supervisor.ProbeHTTP, supervisor.ProbeCommand:
+		default:
+			return nil, fmt.Errorf("Invalid readiness probe type `%s`, should be one of tcp, http and command", probe.Type)
+		}
+		if probe.Target == "" {
+			return nil, fmt.Errorf("Readiness probe %s has no target", probe.Type)
+		}
+		if probe.HTTPStatus != 0 && probe.Type != supervisor.ProbeHTTP {
+			return nil, fmt.Errorf("The http_status is only for the http readiness probe")
+		}
+		probes = append(probes, probe)
+	}
+	return probes, nil
+}
+
+// Render the targets of readiness probes for the task.
+func (c *Cluster) RenderProbes(job *Job, taskId int, skipHostRender bool) ([]supervisor.Probe, error) {
+	job, renderLine, err := c.taskRenderer(job, taskId, skipHostRender)
+	if err != nil {
+		return nil, err
+	}
+	var probes []supervisor.Probe
+	for _, probe := range job.ReadinessProbes {
+		if probe.Target, err = renderLine(probe.Target); err != nil {
+			return nil, fmt.Errorf("Failed to render readiness probe %s: %v", probe.Type, err)
+		}
+		probes = append(probes, probe)
+	}
+	return probes, nil
+}
+
+// Poll the task after it's started until its readiness probes are evaluated by the agent or ctx is done, and return
+// the program. The program without readiness probes is ready once it's running.
+func waitReady(ctx context.Context, supCli *supervisor.SupervisorCli, cluster, job string,
+	taskId int) (*supervisor.Program, error) {
+	for {
+		prog, err := supCli.Show(cluster, job, taskId)
+		if err != nil {
+			return nil, err
+		}
+		switch prog.Status {
+		case supervisor.StatusStarting:
+			select {
+			case <-ctx.Done():
+				return prog, fmt.Errorf("Task is still starting: %v", ctx.Err())
+			case <-time.After(time.Second):
+			}
+		case supervisor.StatusUnhealthy:
+			return prog, fmt.Errorf("Task is unhealthy: %s", prog.ProbeError)
+		default:
+			if !supervisor.IsReadyStatus(prog.Status) {
+				return prog, fmt.Errorf("Task is %s after started", prog.Status)
+			}
+			return prog, nil
+		}
+	}
+}
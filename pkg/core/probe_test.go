@@ -0,0 +1,81 @@
+package core
+
+import (
+	"github.com/openinx/huker/pkg/supervisor"
+	"reflect"
+	"testing"
+)
+
+func TestRenderProbes(t *testing.T) {
+	cfg := `
+    cluster:
+      project: hdfs
+      cluster_name: tst-hdfs
+      main_process: /usr/bin/java
+      package_name: hadoop-2.6.5.tar.gz
+      package_md5sum: 967c24f3c15fcdd058f34923e92ce8ac
+    jobs:
+      base:
+        readiness_probes:
+          - type: tcp
+            target: "%{self.host}:%{self.base_port}"
+      datanode:
+        super_job: base
+        hosts:
+          - 127.0.0.1:9001/id=0/base_port=20100
+      namenode:
+        super_job: base
+        hosts:
+          - 127.0.0.1:9001/id=0/base_port=20200
+        readiness_probes:
+          - type: http
+            target: "http://%{self.host}:%{self.base_port+1}/jmx"
+            http_status: 200
+            timeout_seconds: 120
+          - type: command
+            target: bin/hdfs dfsadmin -safemode get | grep OFF
+            interval_seconds: 5
+    `
+	c, err := NewCluster([]string{cfg}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probes, err := c.RenderProbes(c.Jobs["datanode"], 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []supervisor.Probe{
+		{Type: "tcp", Target: "127.0.0.1:20100", TimeoutSeconds: 60, IntervalSeconds: 1},
+	}
+	if !reflect.DeepEqual(probes, expected) {
+		t.Errorf("Probes of datanode mismatch, %v != %v", probes, expected)
+	}
+
+	probes, err = c.RenderProbes(c.Jobs["namenode"], 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []supervisor.Probe{
+		{Type: "http", Target: "http://127.0.0.1:20201/jmx", HTTPStatus: 200, TimeoutSeconds: 120, IntervalSeconds: 1},
+		{Type: "command", Target: "bin/hdfs dfsadmin -safemode get | grep OFF", TimeoutSeconds: 60, IntervalSeconds: 5},
+	}
+	if !reflect.DeepEqual(probes, expected) {
+		t.Errorf("Probes of namenode mismatch, %v != %v", probes, expected)
+	}
+}
+
+func TestParseReadinessProbes(t *testing.T) {
+	for _, obj := range []interface{}{
+		map[interface{}]interface{}{"type": "tcp", "target": "localhost:2181"},
+		[]interface{}{"tcp"},
+		[]interface{}{map[interface{}]interface{}{"type": "udp", "target": "localhost:2181"}},
+		[]interface{}{map[interface{}]interface{}{"type": "tcp"}},
+		[]interface{}{map[interface{}]interface{}{"type": "tcp", "target": "localhost:2181", "http_status": 200}},
+		[]interface{}{map[interface{}]interface{}{"type": "tcp", "target": "localhost:2181", "timeout_seconds": 0}},
+		[]interface{}{map[interface{}]interface{}{"type": "tcp", "target": "localhost:2181", "retries": 3}},
+	} {
+		if _, err := parseReadinessProbes(obj); err == nil {
+			t.Errorf("Readiness probes %v should be invalid", obj)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"fmt"
+	"github.com/openinx/huker/pkg/supervisor"
+	"github.com/openinx/huker/pkg/utils"
+	"path"
+	"strings"
+)
+
+// Process type decides how to build the arguments of main process for a job, and which settings are allowed. It
+// can be declared by `process_type` in the cluster section or in a job, otherwise it's guessed by main_process.
+type processType interface {
+	// Validate the settings of job for the process type.
+	validate(job *Job) error
+	// Build the arguments of main process for the job.
+	buildArgs(job *Job) []string
+}
+
+var processTypes = map[string]processType{
+	supervisor.ProcessJava:   javaProcess{},
+	supervisor.ProcessPython: pythonProcess{},
+	supervisor.ProcessBinary: binaryProcess{},
+	supervisor.ProcessScript: scriptProcess{},
+}
+
+func parseProcessType(obj interface{}) (string, error) {
+	if !utils.IsStringType(obj) {
+		return "", fmt.Errorf("`process_type` should be a string, now: %v", obj)
+	}
+	if _, ok := processTypes[obj.(string)]; !ok {
+		return "", fmt.Errorf("Unknown process_type `%s`, should be one of java, python, binary and script", obj)
+	}
+	return obj.(string), nil
+}
+
+func getProcessType(name string) processType {
+	if p, ok := processTypes[name]; ok {
+		return p
+	}
+	return javaProcess{}
+}
+
+// Guess the process type by the main process, such as /usr/bin/java or python2.7. The java type is used by default
+// to keep the behavior of clusters declared before the process types.
+func guessProcessType(mainProcess string) string {
+	bin := path.Base(mainProcess)
+	if strings.HasPrefix(bin, "python") {
+		return supervisor.ProcessPython
+	} else if bin == "sh" || bin == "bash" {
+		return supervisor.ProcessScript
+	}
+	return supervisor.ProcessJava
+}
+
+// Check that the settings which make no sense for the process type are not declared.
+func checkUnsupported(job *Job, settings ...string) error {
+	for _, setting := range settings {
+		declared := false
+		switch setting {
+		case "jvm_opts":
+			declared = len(job.JvmOpts) > 0
+		case "jvm_properties":
+			declared = len(job.JvmProperties) > 0
+		case "classpath":
+			declared = len(job.Classpath) > 0
+		case "main_entry.java_class":
+			declared = job.MainEntry.JavaClass != ""
+		case "main_entry.module":
+			declared = job.MainEntry.Module != ""
+		case "main_entry.script":
+			declared = job.MainEntry.Script != ""
+		}
+		if declared {
+			return fmt.Errorf("`%s` is not supported by %s process of job `%s`", setting, job.ProcessType, job.JobName)
+		}
+	}
+	return nil
+}
+
+// Java process: <jvm_opts> -D<jvm_properties> -cp <classpath> <java_class> <extra_args>
+type javaProcess struct{}
+
+func (javaProcess) validate(job *Job) error {
+	return checkUnsupported(job, "main_entry.module", "main_entry.script")
+}
+
+func (javaProcess) buildArgs(job *Job) []string {
+	jvmArgs := append([]string{}, job.JvmOpts...)
+	for i := range job.JvmProperties {
+		jvmPro := strings.TrimSpace(job.JvmProperties[i])
+		if len(jvmPro) > 0 {
+			jvmArgs = append(jvmArgs, fmt.Sprintf("-D%s", jvmPro))
+		}
+	}
+	// The properties win the -D options with the same key.
+	buf := mergeByKey(jvmArgs, nil, jvmOptKey)
+
+	var classpath []string
+	for i := range job.Classpath {
+		cp := strings.TrimSpace(job.Classpath[i])
+		if len(cp) > 0 {
+			classpath = append(classpath, cp)
+		}
+	}
+	if len(classpath) > 0 {
+		buf = append(buf, "-cp")
+		buf = append(buf, strings.Join(classpath, ":"))
+	}
+	return append(buf, job.MainEntry.toShell()...)
+}
+
+// Python process: -m <module> <extra_args>, or <script> <extra_args>
+type pythonProcess struct{}
+
+func (pythonProcess) validate(job *Job) error {
+	if job.MainEntry.Module != "" && job.MainEntry.Script != "" {
+		return fmt.Errorf("Only one of `main_entry.module` and `main_entry.script` can be declared for job `%s`", job.JobName)
+	}
+	return checkUnsupported(job, "jvm_opts", "jvm_properties", "classpath", "main_entry.java_class")
+}
+
+func (pythonProcess) buildArgs(job *Job) []string {
+	var buf []string
+	if job.MainEntry.Module != "" {
+		buf = append(buf, "-m", job.MainEntry.Module)
+	} else if job.MainEntry.Script != "" {
+		buf = append(buf, job.MainEntry.Script)
+	}
+	return append(buf, job.MainEntry.extraArgs()...)
+}
+
+// Binary process, the main_process is the executable itself: <extra_args>
+type binaryProcess struct{}
+
+func (binaryProcess) validate(job *Job) error {
+	return checkUnsupported(job, "jvm_opts", "jvm_properties", "classpath", "main_entry.java_class",
+		"main_entry.module", "main_entry.script")
+}
+
+func (binaryProcess) buildArgs(job *Job) []string {
+	return job.MainEntry.extraArgs()
+}
+
+// Script process, the main_process is the interpreter such as bash: <script> <extra_args>
+type scriptProcess struct{}
+
+func (scriptProcess) validate(job *Job) error {
+	return checkUnsupported(job, "jvm_opts", "jvm_properties", "classpath", "main_entry.java_class", "main_entry.module")
+}
+
+func (scriptProcess) buildArgs(job *Job) []string {
+	var buf []string
+	if job.MainEntry.Script != "" {
+		buf = append(buf, job.MainEntry.Script)
+	}
+	return append(buf, job.MainEntry.extraArgs()...)
+}
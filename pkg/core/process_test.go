@@ -0,0 +1,101 @@
+package core
+
+import (
+	"github.com/openinx/huker/pkg/supervisor"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProcessTypes(t *testing.T) {
+	clusterCfg := func(mainProcess, clusterSection, jobSection string) string {
+		return `
+    cluster:
+      project: test
+      cluster_name: tst-process
+      main_process: ` + mainProcess + `
+      package_name: test.tar.gz
+      package_md5sum: f77f526dcfbdbfb2dd942b6628f4c0ab` + clusterSection + `
+    jobs:
+      server:` + jobSection + `
+    `
+	}
+	testCases := []struct {
+		mainProcess    string
+		clusterSection string
+		jobSection     string
+		processType    string
+		args           []string
+	}{
+		{"/usr/bin/java", "", `
+        jvm_opts: [-Xmx1g]
+        classpath: [./*]
+        main_entry:
+          java_class: Main
+          extra_args: start`, supervisor.ProcessJava, []string{"-Xmx1g", "-cp", "./*", "Main", "start"}},
+		{"python2.7", "", `
+        main_entry:
+          module: SimpleHTTPServer
+          extra_args: "8080"`, supervisor.ProcessPython, []string{"-m", "SimpleHTTPServer", "8080"}},
+		{"python", "", `
+        main_entry:
+          script: bin/server.py
+          extra_args: --port 8080`, supervisor.ProcessPython, []string{"bin/server.py", "--port", "8080"}},
+		{"pkg/bin/redis-server", `
+      process_type: binary`, `
+        main_entry:
+          extra_args: conf/redis.conf`, supervisor.ProcessBinary, []string{"conf/redis.conf"}},
+		{"bash", "", `
+        main_entry:
+          script: bin/start.sh
+          extra_args: -f`, supervisor.ProcessScript, []string{"bin/start.sh", "-f"}},
+		// The process type of job wins the cluster's.
+		{"/usr/bin/java", "", `
+        process_type: script
+        main_entry:
+          script: bin/start.sh`, supervisor.ProcessScript, []string{"bin/start.sh"}},
+	}
+	for idx, tc := range testCases {
+		c, err := NewCluster([]string{clusterCfg(tc.mainProcess, tc.clusterSection, tc.jobSection)}, nil)
+		if err != nil {
+			t.Errorf("Case#%d failed: %v", idx, err)
+			continue
+		}
+		job := c.Jobs["server"]
+		if job.ProcessType != tc.processType {
+			t.Errorf("Case#%d: process type mismatch, %s != %s", idx, job.ProcessType, tc.processType)
+		}
+		if args := job.toShell(-1); !reflect.DeepEqual(args, tc.args) {
+			t.Errorf("Case#%d: args mismatch, %v != %v", idx, args, tc.args)
+		}
+	}
+
+	invalidCases := []struct {
+		mainProcess    string
+		clusterSection string
+		jobSection     string
+		err            string
+	}{
+		{"python", "", `
+        jvm_opts: [-Xmx1g]`, "`jvm_opts` is not supported by python process of job `server`"},
+		{"python", "", `
+        main_entry:
+          module: a
+          script: b.py`, "Only one of `main_entry.module` and `main_entry.script`"},
+		{"redis-server", `
+      process_type: binary`, `
+        main_entry:
+          java_class: Main`, "`main_entry.java_class` is not supported by binary process"},
+		{"java", "", `
+        main_entry:
+          module: a`, "`main_entry.module` is not supported by java process"},
+		{"java", `
+      process_type: golang`, "", "Unknown process_type `golang`"},
+	}
+	for idx, tc := range invalidCases {
+		_, err := NewCluster([]string{clusterCfg(tc.mainProcess, tc.clusterSection, tc.jobSection)}, nil)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Case#%d should be failed with [%s], but: %v", idx, tc.err, err)
+		}
+	}
+}
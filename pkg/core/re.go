@@ -1,322 +1,772 @@
 package core
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/qiniu/log"
 	"regexp"
 	"strconv"
 	"strings"
 )
 
+// Placeholders in configurations are expressions wrapped by %{ and }, for example:
+//
+//   %{cluster.name}
+//   %{namenode.0.host}, %{namenode.0.base_port+1}, %{namenode.x.base_port*2+1}
+//   %{journalnode.server_list}, %{join(filter(journalnode.server_list, "^10\\."), ";")}
+//   %{dependencies.0.zkServer.server_list}, %{dependencies.0.zkServer.0.host}, %{dependencies.0.cluster_name}
+//   %{deps.zk.zkServer.server_list}, %{deps.'test-zk'.zkServer.0.host}, %{deps.hdfs.cluster_name}
+//   %{upper(cluster.name)}, %{replace(cluster.name, "-", "_")}, %{namenode.0.host + ":" + str(namenode.0.base_port)}
+//   %{self.host}, %{self.base_port+1}, %{self.id}, %{self.job}, %{self.cluster}
+//
+// The references such as %{self.<attribute>} depend on the task being rendered, so GlobalRender will leave the
+// whole placeholder untouched and TaskRender will resolve it. %{<job>.x.<attribute>} is the deprecated form of
+// %{self.<attribute>}, which looks up the rendered taskId in the named job. Placeholders with unrecognized
+// references are always left untouched. Syntax and evaluation errors are reported with the column of the input string.
+
+// Error of parsing or evaluating a placeholder, the column is 1-based and relative to the input before rendering.
+type RenderError struct {
+	Placeholder string
+	Column      int
+	Msg         string
+}
+
+func (e *RenderError) Error() string {
+	return fmt.Sprintf("%s at column %d: %s", e.Msg, e.Column, e.Placeholder)
+}
+
+type tokenKind int
+
 const (
-	patternClusterName                    = "%{cluster\\.name}"
-	patternJobAttribute                   = "%{([a-zA-Z0-9_]+)\\.([0-9]+)\\.([a-zA-Z0-9_]+)}"
-	patternJobAttributeNumber             = "%{([a-zA-Z0-9_]+)\\.([0-9]+)\\.([a-zA-Z0-9_]+)\\+([0-9]+)}"
-	patternDependenciesServerList         = "%{dependencies\\.([0-9]+)\\.([a-zA-Z0-9_]+)\\.server_list}"
-	patternDependenciesJobAttribute       = "%{dependencies\\.([0-9]+)\\.([a-zA-Z0-9_]+)\\.([0-9]+)\\.([a-zA-Z0-9_]+)}"
-	patternDependenciesJobAttributeNumber = "%{dependencies\\.([0-9]+)\\.([a-zA-Z0-9_]+)\\.([0-9]+)\\.([a-zA-Z0-9_]+)\\+([0-9]+)}"
-	patternDependenciesClusterName        = "%{dependencies\\.([0-9]+)\\.cluster_name}"
-	patternJobServerList                  = "%{([a-zA-Z0-9_]+)\\.server_list}"
-	patternVarJobAttribute                = "%{([a-zA-Z0-9_]+)\\.x\\.([a-zA-Z0-9_]+)}"
-	patternVarJobAttributeNumber          = "%{([a-zA-Z0-9_]+)\\.x\\.([a-zA-Z0-9_]+)\\+([0-9]+)}"
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokDot
+	tokComma
+	tokLParen
+	tokRParen
+	tokOp
 )
 
-type matchFunc func(c *Cluster, input string) (string, error)
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // offset in the whole input string.
+}
 
-// format %{cluster.name}
-func match0(c *Cluster, input string) (string, error) {
-	re := regexp.MustCompile(patternClusterName)
-	return re.ReplaceAllString(input, c.ClusterName), nil
+func isIdentChar(ch byte, first bool) bool {
+	if ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') {
+		return true
+	}
+	return !first && ch >= '0' && ch <= '9'
 }
 
-// format %{namenode.0.host}
-func match1(c *Cluster, input string) (string, error) {
-	re := regexp.MustCompile(patternJobAttribute)
-	matches := re.FindAllStringSubmatch(input, -1)
-	for _, match := range matches {
-		match = match[1:]
-		jobName, taskIdStr, key := match[0], match[1], match[2]
-		matchPatten := fmt.Sprintf("%%{%s.%s.%s}", jobName, taskIdStr, key)
-		// It's possible that the format conflict with the format %{dependencies.0.cluster_name}
-		if jobName == "dependencies" {
-			continue
-		}
-		if _, ok := c.Jobs[jobName]; !ok {
-			return "", fmt.Errorf("Invalid job name. %s", matchPatten)
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// Split the expression into tokens, base is the offset of the expression in the whole input string.
+func lex(expr string, base int) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		ch := expr[i]
+		switch {
+		case ch == ' ' || ch == '\t':
+			i++
+		case isIdentChar(ch, true):
+			j := i + 1
+			for j < len(expr) && isIdentChar(expr[j], false) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, expr[i:j], base + i})
+			i = j
+		case isDigit(ch):
+			j := i + 1
+			for j < len(expr) && isDigit(expr[j]) {
+				j++
+			}
+			// Such as %{hiveserver2.x.base_port} or a job name starting with digit.
+			for j < len(expr) && isIdentChar(expr[j], false) {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, expr[i:j], base + i})
+			i = j
+		case ch == '"' || ch == '\'':
+			var buf bytes.Buffer
+			j := i + 1
+			for ; j < len(expr) && expr[j] != ch; j++ {
+				if expr[j] == '\\' && j+1 < len(expr) {
+					j++
+				}
+				buf.WriteByte(expr[j])
+			}
+			if j >= len(expr) {
+				return nil, fmt.Errorf("column %d: unterminated string", base+i+1)
+			}
+			tokens = append(tokens, token{tokString, buf.String(), base + i})
+			i = j + 1
+		case ch == '.':
+			tokens = append(tokens, token{tokDot, ".", base + i})
+			i++
+		case ch == ',':
+			tokens = append(tokens, token{tokComma, ",", base + i})
+			i++
+		case ch == '(':
+			tokens = append(tokens, token{tokLParen, "(", base + i})
+			i++
+		case ch == ')':
+			tokens = append(tokens, token{tokRParen, ")", base + i})
+			i++
+		case strings.IndexByte("+-*/%", ch) >= 0:
+			tokens = append(tokens, token{tokOp, string(ch), base + i})
+			i++
+		default:
+			return nil, fmt.Errorf("column %d: unexpected character %q", base+i+1, ch)
 		}
-		taskId, err := strconv.Atoi(taskIdStr)
+	}
+	tokens = append(tokens, token{tokEOF, "", base + len(expr)})
+	return tokens, nil
+}
+
+// AST of the placeholder expression.
+type exprNode interface {
+	pos() int
+}
+
+type numberNode struct {
+	val int
+	p   int
+}
+
+type stringNode struct {
+	val string
+	p   int
+}
+
+type refNode struct {
+	path []string
+	p    int
+}
+
+type callNode struct {
+	name string
+	args []exprNode
+	p    int
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+	p           int
+}
+
+type negNode struct {
+	x exprNode
+	p int
+}
+
+func (n *numberNode) pos() int { return n.p }
+func (n *stringNode) pos() int { return n.p }
+func (n *refNode) pos() int    { return n.p }
+func (n *callNode) pos() int   { return n.p }
+func (n *binaryNode) pos() int { return n.p }
+func (n *negNode) pos() int    { return n.p }
+
+// Grammar:
+//
+//	expr    := term (('+' | '-') term)*
+//	term    := unary (('*' | '/' | '%') unary)*
+//	unary   := '-' unary | primary
+//	primary := number | string | '(' expr ')' | ident '(' [expr (',' expr)*] ')' | ident ('.' (ident | number | string))*
+type parser struct {
+	tokens []token
+	idx    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.idx]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.idx]
+	if t.kind != tokEOF {
+		p.idx++
+	}
+	return t
+}
+
+func unexpected(t token) error {
+	if t.kind == tokEOF {
+		return fmt.Errorf("column %d: unexpected end of expression", t.pos+1)
+	}
+	return fmt.Errorf("column %d: unexpected token %q", t.pos+1, t.text)
+}
+
+func (p *parser) parseExpr() (exprNode, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == tokOp && (t.text == "+" || t.text == "-"); t = p.peek() {
+		p.next()
+		right, err := p.parseTerm()
 		if err != nil {
-			return "", fmt.Errorf("TaskId shoud be integer. %s", matchPatten)
-		}
-		job := c.Jobs[jobName]
-		host, ok := job.GetHost(taskId)
-		if !ok {
-			return "", fmt.Errorf("Task not found. %s", matchPatten)
+			return nil, err
 		}
-		if val, ok := host.Attributes[key]; ok {
-			input = strings.Replace(input, matchPatten, val, 1)
-		} else {
-			return "", fmt.Errorf("Attribute %s not exist. %s", key, matchPatten)
+		left = &binaryNode{op: t.text, left: left, right: right, p: t.pos}
+	}
+	return left, nil
+}
+
+func (p *parser) parseTerm() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == tokOp && (t.text == "*" || t.text == "/" || t.text == "%"); t = p.peek() {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
 		}
+		left = &binaryNode{op: t.text, left: left, right: right, p: t.pos}
 	}
-	return input, nil
+	return left, nil
 }
 
-// format %{name.0.base_port+1}
-func match2(c *Cluster, input string) (string, error) {
-	re := regexp.MustCompile(patternJobAttributeNumber)
-	matches := re.FindAllStringSubmatch(input, -1)
-	for _, match := range matches {
-		match = match[1:]
-		jobName, taskIdStr, key, incrStr := match[0], match[1], match[2], match[3]
-		matchPatten := fmt.Sprintf("%%{%s.%s.%s+%s}", jobName, taskIdStr, key, incrStr)
-		if _, ok := c.Jobs[jobName]; !ok {
-			return "", fmt.Errorf("Invalid job name. %s", matchPatten)
+func (p *parser) parseUnary() (exprNode, error) {
+	if t := p.peek(); t.kind == tokOp && t.text == "-" {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
 		}
-		taskId, err := strconv.Atoi(taskIdStr)
+		return &negNode{x: x, p: t.pos}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		val, err := strconv.Atoi(t.text)
 		if err != nil {
-			return "", fmt.Errorf("TaskId shoud be integer, %s", matchPatten)
+			return nil, fmt.Errorf("column %d: invalid number %q", t.pos+1, t.text)
 		}
-		job := c.Jobs[jobName]
-		host, ok := job.GetHost(taskId)
-		if !ok {
-			return "", fmt.Errorf("Task not found. %s", matchPatten)
+		return &numberNode{val: val, p: t.pos}, nil
+	case tokString:
+		return &stringNode{val: t.text, p: t.pos}, nil
+	case tokLParen:
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if r := p.next(); r.kind != tokRParen {
+			return nil, unexpected(r)
+		}
+		return x, nil
+	case tokIdent:
+		if p.peek().kind == tokLParen {
+			p.next()
+			call := &callNode{name: t.text, p: t.pos}
+			if p.peek().kind == tokRParen {
+				p.next()
+				return call, nil
+			}
+			for {
+				arg, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				call.args = append(call.args, arg)
+				if sep := p.next(); sep.kind == tokRParen {
+					return call, nil
+				} else if sep.kind != tokComma {
+					return nil, unexpected(sep)
+				}
+			}
 		}
-		if val, ok := host.Attributes[key]; ok {
-			incr, _ := strconv.Atoi(incrStr)
-			valInt, err := strconv.Atoi(val)
-			if err != nil {
-				return "", err
+		ref := &refNode{path: []string{t.text}, p: t.pos}
+		for p.peek().kind == tokDot {
+			p.next()
+			seg := p.next()
+			// Quoted segment is allowed for names with special characters, such as deps.'test-zk'.
+			if seg.kind != tokIdent && seg.kind != tokNumber && seg.kind != tokString {
+				return nil, unexpected(seg)
 			}
-			input = strings.Replace(input, matchPatten, fmt.Sprintf("%d", valInt+incr), 1)
-		} else {
-			return "", fmt.Errorf("Attribute %s not exist. %s", key, matchPatten)
+			ref.path = append(ref.path, seg.text)
 		}
+		return ref, nil
+	}
+	return nil, unexpected(t)
+}
+
+// Parse the expression between %{ and }, base is the offset of the expression in the whole input string.
+func parseExpr(expr string, base int) (exprNode, error) {
+	tokens, err := lex(expr, base)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	node, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, unexpected(t)
 	}
-	return input, nil
+	return node, nil
+}
+
+// A placeholder %{...} found in the input string.
+type placeholder struct {
+	text  string
+	start int
+	end   int // exclusive
+	expr  exprNode
 }
 
-// format %{dependencies.0.zkServer.server_list}
-func match3(c *Cluster, input string) (string, error) {
-	re := regexp.MustCompile(patternDependenciesServerList)
-	matches := re.FindAllStringSubmatch(input, -1)
-	for _, match := range matches {
-		match = match[1:]
-		clusterIndexStr, jobName := match[0], match[1]
-		matchPattern := fmt.Sprintf("%%{dependencies.%s.%s.server_list}", clusterIndexStr, jobName)
-		clusterIndex, _ := strconv.Atoi(clusterIndexStr)
-		if clusterIndex >= len(c.Dependencies) {
-			return "", fmt.Errorf("Cluster index exceeded. %s", matchPattern)
+// Find and parse all the placeholders of the input string.
+func parsePlaceholders(input string) ([]*placeholder, error) {
+	var placeholders []*placeholder
+	for i := 0; i < len(input); {
+		idx := strings.Index(input[i:], "%{")
+		if idx < 0 {
+			break
+		}
+		start := i + idx
+		end, quote := -1, byte(0)
+		for j := start + 2; j < len(input) && end < 0; j++ {
+			switch {
+			case quote != 0 && input[j] == '\\':
+				j++
+			case quote != 0 && input[j] == quote:
+				quote = 0
+			case quote != 0:
+			case input[j] == '"' || input[j] == '\'':
+				quote = input[j]
+			case input[j] == '}':
+				end = j + 1
+			}
 		}
-		dep := c.Dependencies[clusterIndex]
-		job, ok := dep.Jobs[jobName]
-		if !ok {
-			return "", fmt.Errorf("Job %s does not exist in cluster: %s", jobName, dep.ClusterName)
+		if end < 0 {
+			return nil, &RenderError{Placeholder: input[start:], Column: start + 1, Msg: "Unclosed placeholder"}
 		}
-		var buf []string
-		for _, host := range job.Hosts {
-			buf = append(buf, fmt.Sprintf("%s:%d", host.Hostname, host.BasePort))
+		expr, err := parseExpr(input[start+2:end-1], start+2)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid placeholder %s, %v", input[start:end], err)
 		}
-		input = strings.Replace(input, matchPattern, strings.Join(buf, ","), 1)
+		placeholders = append(placeholders, &placeholder{text: input[start:end], start: start, end: end, expr: expr})
+		i = end
 	}
-	return input, nil
+	return placeholders, nil
 }
 
-// format %{journalnode.server_list}
-func match4(c *Cluster, input string) (string, error) {
-	re := regexp.MustCompile(patternJobServerList)
-	matches := re.FindAllStringSubmatch(input, -1)
-	for _, match := range matches {
-		match = match[1:]
-		jobName := match[0]
-		matchPattern := fmt.Sprintf("%%{%s.server_list}", jobName)
-		job, ok := c.Jobs[jobName]
-		if !ok {
-			return "", fmt.Errorf("Job %s does not exist in cluster: %s", jobName, c.ClusterName)
-		}
-		var buf []string
-		for _, host := range job.Hosts {
-			buf = append(buf, fmt.Sprintf("%s:%d", host.Hostname, host.BasePort))
+type refScope int
+
+// The scopes are ordered, a wider scope needs more context to evaluate.
+const (
+	scopeUnknown refScope = iota
+	scopeGlobal
+	scopeJob
+	scopeHost
+)
+
+func isIndex(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := range s {
+		if !isDigit(s[i]) {
+			return false
 		}
-		input = strings.Replace(input, matchPattern, strings.Join(buf, ","), 1)
 	}
-	return input, nil
+	return true
 }
 
-type matchHostFunc func(c *Cluster, taskId int, input string) (string, error)
+// Classify the shape of the reference path. The shapes are:
+//
+//	cluster.name
+//	dependencies.<index>.cluster_name
+//	dependencies.<index>.<job>.server_list
+//	dependencies.<index>.<job>.<taskId>.<attribute>
+//	deps.<alias|cluster_name>.cluster_name
+//	deps.<alias|cluster_name>.<job>.server_list
+//	deps.<alias|cluster_name>.<job>.<taskId>.<attribute>
+//	<job>.server_list
+//	<job>.<taskId>.<attribute>
+//	<job>.x.<attribute>
+//	self.job, self.cluster
+//	self.<attribute>
+func classifyRef(path []string) refScope {
+	n := len(path)
+	switch {
+	case n == 2 && path[0] == "cluster" && path[1] == "name":
+		return scopeGlobal
+	case n == 2 && path[0] == "self" && (path[1] == "job" || path[1] == "cluster"):
+		return scopeJob
+	case n == 2 && path[0] == "self":
+		return scopeHost
+	case path[0] == "dependencies" || path[0] == "deps":
+		if n < 3 || (path[0] == "dependencies" && !isIndex(path[1])) {
+			return scopeUnknown
+		}
+		if (n == 3 && path[2] == "cluster_name") || (n == 4 && path[3] == "server_list") || (n == 5 && isIndex(path[3])) {
+			return scopeGlobal
+		}
+	case n == 2 && path[1] == "server_list":
+		return scopeGlobal
+	case n == 3 && path[1] == "x":
+		return scopeHost
+	case n == 3 && isIndex(path[1]):
+		return scopeGlobal
+	}
+	return scopeUnknown
+}
 
-// format %{namenode.x.base_port}
-// TODO BUG: the taskId may not match with the job.
-func match5(c *Cluster, taskId int, input string) (string, error) {
-	re := regexp.MustCompile(patternVarJobAttribute)
-	matches := re.FindAllStringSubmatch(input, -1)
-	for _, match := range matches {
-		match = match[1:]
-		jobName, key := match[0], match[1]
-		matchPatten := fmt.Sprintf("%%{%s.x.%s}", jobName, key)
-		if _, ok := c.Jobs[jobName]; !ok {
-			return "", fmt.Errorf("Invalid job name. %s", matchPatten)
-		}
-		job := c.Jobs[jobName]
-		host, ok := job.GetHost(taskId)
-		if !ok {
-			return "", fmt.Errorf("Task not found. %s", matchPatten)
-		}
-		if val, ok := host.Attributes[key]; ok {
-			input = strings.Replace(input, matchPatten, val, 1)
-		} else {
-			return "", fmt.Errorf("Attribute %s not exist. %s", key, matchPatten)
-		}
+func collectRefs(node exprNode, refs []*refNode) []*refNode {
+	switch n := node.(type) {
+	case *refNode:
+		refs = append(refs, n)
+	case *callNode:
+		for _, arg := range n.args {
+			refs = collectRefs(arg, refs)
+		}
+	case *binaryNode:
+		refs = collectRefs(n.right, collectRefs(n.left, refs))
+	case *negNode:
+		refs = collectRefs(n.x, refs)
 	}
-	return input, nil
+	return refs
 }
 
-// format %{namenode.x.base_port+1}
-// TODO BUG: the taskId may not match with the job.
-func match6(c *Cluster, taskId int, input string) (string, error) {
-	re := regexp.MustCompile(patternVarJobAttributeNumber)
-	matches := re.FindAllStringSubmatch(input, -1)
-	for _, match := range matches {
-		match = match[1:]
-		jobName, key, incrStr := match[0], match[1], match[2]
-		matchPatten := fmt.Sprintf("%%{%s.x.%s+%s}", jobName, key, incrStr)
-		if _, ok := c.Jobs[jobName]; !ok {
-			return "", fmt.Errorf("Invalid job name. %s", matchPatten)
+// The scope of the expression is the widest scope of all its references.
+func exprScope(node exprNode) refScope {
+	scope := scopeGlobal
+	for _, ref := range collectRefs(node, nil) {
+		refScope := classifyRef(ref.path)
+		if refScope == scopeUnknown {
+			return scopeUnknown
+		} else if refScope > scope {
+			scope = refScope
 		}
-		job := c.Jobs[jobName]
-		host, ok := job.GetHost(taskId)
-		if !ok {
-			return "", fmt.Errorf("Task not found. %s", matchPatten)
+	}
+	return scope
+}
+
+// The context to evaluate the expression, job is only used for the %{self.*} references and taskId is only used
+// for the host scoped references.
+type renderContext struct {
+	c      *Cluster
+	job    *Job
+	taskId int
+}
+
+func evalErr(node exprNode, format string, args ...interface{}) error {
+	return &RenderError{Column: node.pos() + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+func getTaskAttribute(node exprNode, c *Cluster, jobName, taskIdStr, key string) (interface{}, error) {
+	job, ok := c.Jobs[jobName]
+	if !ok {
+		return nil, evalErr(node, "Job %s does not exist in cluster: %s", jobName, c.ClusterName)
+	}
+	taskId, err := strconv.Atoi(taskIdStr)
+	if err != nil {
+		return nil, evalErr(node, "TaskId shoud be integer")
+	}
+	host, ok := job.GetHost(taskId)
+	if !ok {
+		return nil, evalErr(node, "Task not found")
+	}
+	if val, ok := host.Attributes[key]; ok {
+		// Attributes such as base_port are integers, so they can be used in arithmetic.
+		if i, err := strconv.Atoi(val); err == nil && strconv.Itoa(i) == val {
+			return i, nil
 		}
-		if val, ok := host.Attributes[key]; ok {
-			incr, _ := strconv.Atoi(incrStr)
-			valInt, err := strconv.Atoi(val)
-			if err != nil {
-				return "", err
+		return val, nil
+	}
+	return nil, evalErr(node, "Attribute %s not exist", key)
+}
+
+func getServerList(node exprNode, c *Cluster, jobName string) ([]string, error) {
+	job, ok := c.Jobs[jobName]
+	if !ok {
+		return nil, evalErr(node, "Job %s does not exist in cluster: %s", jobName, c.ClusterName)
+	}
+	var buf []string
+	for _, host := range job.Hosts {
+		buf = append(buf, fmt.Sprintf("%s:%d", host.Hostname, host.BasePort))
+	}
+	return buf, nil
+}
+
+func getDependency(node exprNode, c *Cluster, indexStr string) (*Cluster, error) {
+	index, err := strconv.Atoi(indexStr)
+	if err != nil || index >= len(c.Dependencies) {
+		return nil, evalErr(node, "Cluster index exceeded")
+	}
+	return c.Dependencies[index], nil
+}
+
+func (ctx *renderContext) evalRef(n *refNode) (interface{}, error) {
+	path := n.path
+	switch {
+	case path[0] == "cluster":
+		return ctx.c.ClusterName, nil
+	case path[0] == "dependencies" || path[0] == "deps":
+		var dep *Cluster
+		var err error
+		if path[0] == "deps" {
+			if dep, err = ctx.c.lookupDependency(path[1]); err != nil {
+				return nil, evalErr(n, "%v", err)
 			}
-			input = strings.Replace(input, matchPatten, fmt.Sprintf("%d", valInt+incr), 1)
-		} else {
-			return "", fmt.Errorf("Attribute %s not exist. %s", key, matchPatten)
-		}
+		} else if dep, err = getDependency(n, ctx.c, path[1]); err != nil {
+			return nil, err
+		}
+		switch len(path) {
+		case 3:
+			return dep.ClusterName, nil
+		case 4:
+			return getServerList(n, dep, path[2])
+		default:
+			return getTaskAttribute(n, dep, path[2], path[3], path[4])
+		}
+	case path[0] == "self":
+		if ctx.job == nil {
+			return nil, evalErr(n, "Reference self.%s is only available when rendering a job", path[1])
+		}
+		switch path[1] {
+		case "job":
+			return ctx.job.JobName, nil
+		case "cluster":
+			return ctx.c.ClusterName, nil
+		}
+		return getTaskAttribute(n, ctx.c, ctx.job.JobName, strconv.Itoa(ctx.taskId), path[1])
+	case len(path) == 2:
+		return getServerList(n, ctx.c, path[0])
+	case path[1] == "x":
+		if ctx.job != nil && ctx.job.JobName != path[0] {
+			log.Warnf("Reference %s resolves task %d of job %s when rendering job %s, it's deprecated, use self.%s instead.",
+				strings.Join(path, "."), ctx.taskId, path[0], ctx.job.JobName, path[2])
+		}
+		return getTaskAttribute(n, ctx.c, path[0], strconv.Itoa(ctx.taskId), path[2])
+	default:
+		return getTaskAttribute(n, ctx.c, path[0], path[1], path[2])
 	}
-	return input, nil
 }
 
-// format %{dependencies.0.zkServer.0.host}
-func match7(c *Cluster, input string) (string, error) {
-	re := regexp.MustCompile(patternDependenciesJobAttribute)
-	matches := re.FindAllStringSubmatch(input, -1)
-	for _, match := range matches {
-		match = match[1:]
-		clusterIndexStr, jobName, taskIdStr, key := match[0], match[1], match[2], match[3]
-		matchPattern := fmt.Sprintf("%%{dependencies.%s.%s.%s.%s}", clusterIndexStr, jobName, taskIdStr, key)
-		clusterIndex, _ := strconv.Atoi(clusterIndexStr)
-		if clusterIndex >= len(c.Dependencies) {
-			return "", fmt.Errorf("Cluster index exceeded. %s", matchPattern)
-		}
-		dep := c.Dependencies[clusterIndex]
-		job, ok := dep.Jobs[jobName]
-		if !ok {
-			return "", fmt.Errorf("Job %s does not exist in cluster: %s", jobName, dep.ClusterName)
-		}
-		taskId, err := strconv.Atoi(taskIdStr)
+func toList(v interface{}) []string {
+	if list, ok := v.([]string); ok {
+		return list
+	}
+	return []string{valueToString(v)}
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case int:
+		return "integer"
+	case []string:
+		return "list"
+	}
+	return "string"
+}
+
+// List will be joined with comma by default.
+func valueToString(v interface{}) string {
+	switch val := v.(type) {
+	case int:
+		return strconv.Itoa(val)
+	case string:
+		return val
+	case []string:
+		return strings.Join(val, ",")
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func (ctx *renderContext) evalCall(n *callNode) (interface{}, error) {
+	var args []interface{}
+	for _, arg := range n.args {
+		val, err := ctx.eval(arg)
 		if err != nil {
-			return "", fmt.Errorf("TaskId shoud be integer. %s", matchPattern)
+			return nil, err
 		}
-		host, ok := job.GetHost(taskId)
-		if !ok {
-			return "", fmt.Errorf("Task not found. %s, taskId: %d, job: %v", matchPattern, taskId, job)
+		args = append(args, val)
+	}
+	argc := map[string]int{"join": 2, "filter": 2, "upper": 1, "lower": 1, "str": 1, "replace": 3}
+	if expected, ok := argc[n.name]; !ok {
+		return nil, evalErr(n, "Unknown function %s", n.name)
+	} else if expected != len(args) {
+		return nil, evalErr(n, "Function %s expects %d arguments, but got %d", n.name, expected, len(args))
+	}
+	switch n.name {
+	case "join":
+		return strings.Join(toList(args[0]), valueToString(args[1])), nil
+	case "filter":
+		re, err := regexp.Compile(valueToString(args[1]))
+		if err != nil {
+			return nil, evalErr(n.args[1], "Invalid regular expression: %v", err)
 		}
-		if val, ok := host.Attributes[key]; ok {
-			input = strings.Replace(input, matchPattern, val, 1)
-		} else {
-			return "", fmt.Errorf("Attribute %s not exist. %s", key, matchPattern)
+		filtered := []string{}
+		for _, item := range toList(args[0]) {
+			if re.MatchString(item) {
+				filtered = append(filtered, item)
+			}
 		}
+		return filtered, nil
+	case "upper":
+		return strings.ToUpper(valueToString(args[0])), nil
+	case "lower":
+		return strings.ToLower(valueToString(args[0])), nil
+	case "replace":
+		return strings.Replace(valueToString(args[0]), valueToString(args[1]), valueToString(args[2]), -1), nil
+	default:
+		return valueToString(args[0]), nil
 	}
-	return input, nil
 }
 
-// format %{dependencies.0.zkServer.0.base_port+1}
-func match8(c *Cluster, input string) (string, error) {
-	re := regexp.MustCompile(patternDependenciesJobAttributeNumber)
-	matches := re.FindAllStringSubmatch(input, -1)
-	for _, match := range matches {
-		match = match[1:]
-		clusterIndexStr, jobName, taskIdStr, key, incrStr := match[0], match[1], match[2], match[3], match[4]
-		matchPattern := fmt.Sprintf("%%{dependencies.%s.%s.%s.%s+%s}", clusterIndexStr, jobName, taskIdStr, key, incrStr)
-		clusterIndex, _ := strconv.Atoi(clusterIndexStr)
-		if clusterIndex >= len(c.Dependencies) {
-			return "", fmt.Errorf("Cluster index exceeded. %s", matchPattern)
-		}
-		dep := c.Dependencies[clusterIndex]
-		job, ok := dep.Jobs[jobName]
-		if !ok {
-			return "", fmt.Errorf("Job %s does not exist in cluster: %s", jobName, dep.ClusterName)
+func (ctx *renderContext) evalBinary(n *binaryNode) (interface{}, error) {
+	left, err := ctx.eval(n.left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := ctx.eval(n.right)
+	if err != nil {
+		return nil, err
+	}
+	l, lok := left.(int)
+	r, rok := right.(int)
+	if n.op == "+" && !lok && !rok {
+		ls, lStr := left.(string)
+		rs, rStr := right.(string)
+		if lStr && rStr {
+			return ls + rs, nil
 		}
-		taskId, err := strconv.Atoi(taskIdStr)
+	}
+	if !lok || !rok {
+		return nil, evalErr(n, "Operator %s is not supported for %s and %s, use str() to convert integer to string",
+			n.op, typeName(left), typeName(right))
+	}
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	}
+	if r == 0 {
+		return nil, evalErr(n, "Division by zero")
+	}
+	if n.op == "/" {
+		return l / r, nil
+	}
+	return l % r, nil
+}
+
+func (ctx *renderContext) eval(node exprNode) (interface{}, error) {
+	switch n := node.(type) {
+	case *numberNode:
+		return n.val, nil
+	case *stringNode:
+		return n.val, nil
+	case *refNode:
+		return ctx.evalRef(n)
+	case *callNode:
+		return ctx.evalCall(n)
+	case *binaryNode:
+		return ctx.evalBinary(n)
+	case *negNode:
+		val, err := ctx.eval(n.x)
 		if err != nil {
-			return "", fmt.Errorf("TaskId shoud be integer, %s", matchPattern)
+			return nil, err
 		}
-		host, ok := job.GetHost(taskId)
-		if !ok {
-			return "", fmt.Errorf("Task not found. %s", matchPattern)
+		if i, ok := val.(int); ok {
+			return -i, nil
 		}
-		if val, ok := host.Attributes[key]; ok {
-			incr, _ := strconv.Atoi(incrStr)
-			valInt, err := strconv.Atoi(val)
+		return nil, evalErr(n, "Operator - is not supported for %s", typeName(val))
+	}
+	return nil, evalErr(node, "Unexpected expression")
+}
+
+// Phase of rendering, which evaluates the placeholders whose scope is allowed by the context.
+type renderPhase struct {
+	ctx      *renderContext
+	maxScope refScope
+}
+
+// Render the placeholders phase by phase, every placeholder is evaluated by the first phase allowing its scope and
+// left untouched if none does. The placeholders are parsed once, so the errors of the later phases are still reported
+// with the column of the input, rather than the one rendered by the earlier phases.
+func render(input string, phases ...renderPhase) (string, error) {
+	placeholders, err := parsePlaceholders(input)
+	if err != nil {
+		return "", err
+	}
+	values := make([]*string, len(placeholders))
+	for _, phase := range phases {
+		for i, ph := range placeholders {
+			scope := exprScope(ph.expr)
+			if values[i] != nil || scope == scopeUnknown || scope > phase.maxScope {
+				continue
+			}
+			val, err := phase.ctx.eval(ph.expr)
 			if err != nil {
+				if re, ok := err.(*RenderError); ok {
+					re.Placeholder = ph.text
+				}
 				return "", err
 			}
-			input = strings.Replace(input, matchPattern, fmt.Sprintf("%d", valInt+incr), 1)
-		} else {
-			return "", fmt.Errorf("Attribute %s not exist. %s", key, matchPattern)
+			str := valueToString(val)
+			values[i] = &str
 		}
 	}
-	return input, nil
-}
-
-// format %{dependencies.0.cluster_name}
-func match9(c *Cluster, input string) (string, error) {
-	re := regexp.MustCompile(patternDependenciesClusterName)
-	matches := re.FindAllStringSubmatch(input, -1)
-	for _, match := range matches {
-		match = match[1:]
-		clusterIndexStr := match[0]
-		matchPattern := fmt.Sprintf("%%{dependencies.%s.cluster_name}", clusterIndexStr)
-		clusterIndex, _ := strconv.Atoi(clusterIndexStr)
-		if clusterIndex >= len(c.Dependencies) {
-			return "", fmt.Errorf("Cluster index exceeded. %s", matchPattern)
+	var buf bytes.Buffer
+	last := 0
+	for i, ph := range placeholders {
+		buf.WriteString(input[last:ph.start])
+		last = ph.end
+		if values[i] != nil {
+			buf.WriteString(*values[i])
+		} else {
+			buf.WriteString(ph.text)
 		}
-		dep := c.Dependencies[clusterIndex]
-		input = strings.Replace(input, matchPattern, dep.ClusterName, 1)
 	}
-	return input, nil
+	buf.WriteString(input[last:])
+	return buf.String(), nil
 }
 
-// Render the %{<job>.<index>.<attribute>} and %{dependencies.<index>.<job>.server_list} of input string
-// to value for the global cluster.
+// Render the placeholders such as %{<job>.<index>.<attribute>} and %{dependencies.<index>.<job>.server_list} of
+// input string to value for the global cluster.
 func GlobalRender(c *Cluster, input string) (string, error) {
-	var err error
-	for _, matchFun := range []matchFunc{
-		match0, match1, match2, match3, match4, match7, match8, match9,
-	} {
-		input, err = matchFun(c, input)
-		if err != nil {
-			return "", err
-		}
-	}
-	return input, nil
+	return render(input, renderPhase{&renderContext{c: c}, scopeGlobal})
 }
 
-// Render the %{<job>.x.<attribute>} of input string to value for the specific host.
+// Render the placeholders such as %{<job>.x.<attribute>} of input string to value for the specific host.
 func HostRender(c *Cluster, taskId int, input string) (string, error) {
-	var err error
-	for _, matchFun := range []matchHostFunc{
-		match5, match6,
-	} {
-		input, err = matchFun(c, taskId, input)
-		if err != nil {
-			return "", err
-		}
+	return render(input, renderPhase{&renderContext{c: c, taskId: taskId}, scopeHost})
+}
+
+// Render the placeholders such as %{self.<attribute>} of input string to value for the task of the job.
+func TaskRender(c *Cluster, job *Job, taskId int, input string) (string, error) {
+	return render(input, renderPhase{&renderContext{c: c, job: job, taskId: taskId}, scopeHost})
+}
+
+// Render the placeholders %{self.job} and %{self.cluster} for jobs without tasks, such as the shell job.
+func jobRender(c *Cluster, job *Job, input string) (string, error) {
+	return render(input, renderPhase{&renderContext{c: c, job: job}, scopeJob})
+}
+
+// Render the line just like GlobalRender followed by TaskRender, or by jobRender if skipHostRender is true. The
+// placeholders are parsed once from the line, so the errors are reported with the column of the line.
+func lineRender(c *Cluster, job *Job, taskId int, line string, skipHostRender bool) (string, error) {
+	global := renderPhase{&renderContext{c: c}, scopeGlobal}
+	if skipHostRender {
+		return render(line, global, renderPhase{&renderContext{c: c, job: job}, scopeJob})
 	}
-	return input, nil
+	return render(line, global, renderPhase{&renderContext{c: c, job: job, taskId: taskId}, scopeHost})
 }
@@ -1,39 +1,36 @@
 package core
 
 import (
-	"regexp"
+	"strings"
 	"testing"
 )
 
-func TestPattern(t *testing.T) {
-
+func TestParseExpression(t *testing.T) {
 	var testCases = []struct {
-		pattern   string
-		text      string
-		isMatched bool
+		input string
+		err   string
 	}{
-		{patternClusterName, "address=%{cluster.name}", true},
-		{patternClusterName, "address=%{clusteraname}", false},
-		{patternJobAttribute, "key=%{job01.0.port0}", true},
-		{patternJobAttribute, "key=%{job01x0.port0}", false},
-		{patternJobAttribute, "key=%{jOb0_1.0.port0}", true},
-		{patternJobAttribute, "key=%{job01.0xport0}", false},
-		{patternJobAttribute, "key=%{job01.x.port0}", false},
-		{patternJobAttributeNumber, "key=%{jOb0_1.0.base_port+1}", true},
-		{patternJobAttributeNumber, "key=%{jOb0_1.0.base_por3t+00100}", true},
-		{patternJobAttributeNumber, "key=%{jOb0_1.0.base_port}", false},
-		{patternDependenciesServerList, "key=%{dependencies.100.jOb0_1.server_list}", true},
-		{patternDependenciesClusterName, "key=%{dependencies.100.cluster.name}", false},
-		{patternDependenciesClusterName, "key=%{dependencies.100.cluster_name}", true},
-		{patternJobServerList, "key=%{aB_0.server_list}", true},
-		{patternVarJobAttribute, "key=%{aB_0.x.base_PORT0}", true},
-		{patternVarJobAttributeNumber, "key=%{aB_0.x.base_PORT0+1}", true},
+		{"address=%{cluster.name}", ""},
+		{"key=%{job01.0.port0}", ""},
+		{"key=%{jOb0_1.x.base_port*2+1}", ""},
+		{"key=%{(job01.0.base_port + 1) % 10 - -1}", ""},
+		{"key=%{join(filter(zk.server_list, \"^10\\\\.\"), ';')}", ""},
+		{"key=%{replace(cluster.name, \"}\", \"-\")}", ""},
+		{"key=%{job01.0.base_port+}", "column 25: unexpected end of expression"},
+		{"key=%{job01.0.}", "column 15: unexpected end of expression"},
+		{"key=%{upper(cluster.name}", "column 25: unexpected end of expression"},
+		{"key=%{cluster.name)}", "column 19: unexpected token \")\""},
+		{"key=%{cluster.name # 1}", "column 20: unexpected character '#'"},
+		{"key=%{upper(\"abc)} + 1", "Unclosed placeholder at column 5"},
 	}
-
 	for i := range testCases {
-		re := regexp.MustCompile(testCases[i].pattern)
-		if testCases[i].isMatched != re.Match([]byte(testCases[i].text)) {
-			t.Errorf("Test case #%d failed, regexp mismatched.", i)
+		_, err := parsePlaceholders(testCases[i].input)
+		if testCases[i].err == "" {
+			if err != nil {
+				t.Errorf("Test case #%d failed, %v", i, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), testCases[i].err) {
+			t.Errorf("Test case #%d failed, error should contain [%s], but: %v", i, testCases[i].err, err)
 		}
 	}
 }
@@ -109,6 +106,22 @@ func TestRender(t *testing.T) {
 		{"key=%{dependencies.1.cluster_name}", false, ""},
 		{"key=%{dependencies.1.cluster-name}", true, "key=%{dependencies.1.cluster-name}"},
 		{"key=%{test_job.server_list}", true, "key=www.example.com:7001,www.example.com:8001"},
+		{"key=%{test_job.0.base_port*2+1}", true, "key=14003"},
+		{"key=%{(test_job.1.base_port - test_job.0.base_port) / 10 % 7}", true, "key=2"},
+		{"key=%{-test_job.0.base_port}", true, "key=-7001"},
+		{"key=%{test_job.0.host + \":\" + str(test_job.0.base_port)}", true, "key=www.example.com:7001"},
+		{"key=%{test_job.0.host + \":\" + test_job.0.base_port}", false, ""},
+		{"key=%{join(test_job.server_list, \";\")}", true, "key=www.example.com:7001;www.example.com:8001"},
+		{"key=%{join(filter(test_job.server_list, \":8\"), \";\")}", true, "key=www.example.com:8001"},
+		{"key=%{upper(cluster.name)}-%{lower(\"ABC\")}", true, "key=TEST-abc"},
+		{"key=%{replace(dependencies.0.test_job.0.host, \".\", \"_\")}", true, "key=www_example_com"},
+		{"key=%{str(test_job.0.base_port) + str(1)}", true, "key=70011"},
+		{"key=%{test_job.0.base_port / 0}", false, ""},
+		{"key=%{test_job.0.host * 2}", false, ""},
+		{"key=%{test_job.server_list + 1}", false, ""},
+		{"key=%{unknown(cluster.name)}", false, ""},
+		{"key=%{join(test_job.server_list)}", false, ""},
+		{"key=%{test_job.x.base_port*2}", true, "key=%{test_job.x.base_port*2}"},
 	}
 
 	for i := range testCases {
@@ -142,6 +155,8 @@ func TestRender(t *testing.T) {
 		{"key=%{test_job.x.invalid_port+100}", 0, false, ""},
 		{"key=%{test_job.x.base_port+200}", 1, true, "key=8201"},
 		{"key=%{test_job.x.base_port+200}", 100, false, ""},
+		{"key=%{test_job.x.base_port*2+1}", 1, true, "key=16003"},
+		{"key=%{test_job.x.host}:%{test_job.0.base_port}", 1, true, "key=www.example.com:7001"},
 	}
 
 	for i := range testHostCases {
@@ -160,4 +175,60 @@ func TestRender(t *testing.T) {
 			t.Fatalf("Test case #%d failed, output mismatch. [%s] != [%s]", i, output, testHostCases[i].output)
 		}
 	}
+
+	var testTaskCases = []struct {
+		input   string
+		taskId  int
+		success bool
+		output  string
+	}{
+		{"key=%{self.host}:%{self.base_port+1}", 1, true, "key=www.example.com:8002"},
+		{"key=%{self.id}/%{self.port}", 0, true, "key=0/9001"},
+		{"key=%{self.cluster}/%{self.job}", 0, true, "key=test/test_job"},
+		{"key=%{self.invalid_attr}", 0, false, ""},
+		{"key=%{self.host}", 100, false, ""},
+		{"key=%{test_job.x.base_port}", 1, true, "key=8001"},
+	}
+
+	for i := range testTaskCases {
+		output, err := TaskRender(c, c.Jobs["test_job"], testTaskCases[i].taskId, testTaskCases[i].input)
+		if err != nil {
+			if testTaskCases[i].success {
+				t.Fatalf("Test case #%d failed, should success but failed: %v", i, err)
+			}
+			continue
+		}
+		if !testTaskCases[i].success {
+			t.Fatalf("Test case #%d failed, should failed but success", i)
+		}
+		if output != testTaskCases[i].output {
+			t.Fatalf("Test case #%d failed, output mismatch. [%s] != [%s]", i, output, testTaskCases[i].output)
+		}
+	}
+
+	// The self references are left untouched by GlobalRender, and can not be resolved without job.
+	if output, err := GlobalRender(c, "key=%{self.host}"); err != nil || output != "key=%{self.host}" {
+		t.Errorf("GlobalRender should leave the self reference untouched, output: %s, err: %v", output, err)
+	}
+	if _, err := HostRender(c, 0, "key=%{self.host}"); err == nil {
+		t.Errorf("HostRender should fail to resolve the self reference without job")
+	}
+
+	expected := "Task not found at column 13: %{ test_job.2.host}"
+	if _, err := GlobalRender(c, "key=host:%{ test_job.2.host}"); err == nil || err.Error() != expected {
+		t.Errorf("Error mismatch, [%v] != [%s]", err, expected)
+	}
+
+	// The column of the task placeholder is not shifted by the global one rendered before it.
+	_, renderLine, err := c.taskRenderer(c.Jobs["test_job"], 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "Attribute invalid_attr not exist at column 23: %{self.invalid_attr}"
+	if _, err := renderLine("key=%{cluster.name}/%{self.invalid_attr}"); err == nil || err.Error() != expected {
+		t.Errorf("Error mismatch, [%v] != [%s]", err, expected)
+	}
+	if output, err := renderLine("key=%{cluster.name}/%{self.id}"); err != nil || output != "key=test/0" {
+		t.Errorf("Line should be rendered, output: %s, err: %v", output, err)
+	}
 }
@@ -0,0 +1,288 @@
+package core
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"github.com/openinx/huker/pkg/supervisor"
+	"github.com/openinx/huker/pkg/utils"
+	"github.com/qiniu/log"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+// Settings of rolling update, which can be declared in the rolling_update section of job, such as:
+//
+//	rolling_update:
+//	  batch_size: 2
+//	  max_unavailable: 3
+//	  failure_threshold: 1
+//	  wait_healthy_seconds: 60
+//
+// The zero values are filled by the super job, then by the defaults.
+type RollingUpdateOptions struct {
+	// Number of tasks to update at the same time, default: 1
+	BatchSize int
+	// Max number of unavailable tasks of the job during the rollout, including the updating ones. default: BatchSize
+	MaxUnavailable int
+	// Stop the rollout once the number of failed tasks reaches the threshold, default: 1
+	FailureThreshold int
+	// Max time to wait for the updated tasks to be healthy before the next batch, default: 60s
+	WaitHealthy time.Duration
+	// Skip the tasks completed by the previous rollout of the same tasks to the same programs.
+	Resume bool
+}
+
+var defaultRollingUpdateOptions = RollingUpdateOptions{
+	BatchSize:        1,
+	FailureThreshold: 1,
+	WaitHealthy:      60 * time.Second,
+}
+
+func parseRollingUpdateOptions(obj interface{}) (RollingUpdateOptions, error) {
+	var opts RollingUpdateOptions
+	if !utils.IsMapType(obj) {
+		return opts, fmt.Errorf("Invalid rolling_update, should be a map. %v", obj)
+	}
+	for key, value := range obj.(map[interface{}]interface{}) {
+		if !utils.IsIntegerType(value) || value.(int) < 0 {
+			return opts, fmt.Errorf("Invalid rolling_update `%v`, should be a non-negative int. %v", key, value)
+		}
+		switch key {
+		case "batch_size":
+			opts.BatchSize = value.(int)
+		case "max_unavailable":
+			opts.MaxUnavailable = value.(int)
+		case "failure_threshold":
+			opts.FailureThreshold = value.(int)
+		case "wait_healthy_seconds":
+			opts.WaitHealthy = time.Duration(value.(int)) * time.Second
+		default:
+			return opts, fmt.Errorf("Unknown rolling_update `%v`", key)
+		}
+	}
+	return opts, nil
+}
+
+// Fill the zero values with the other's.
+func (o RollingUpdateOptions) fillWith(other RollingUpdateOptions) RollingUpdateOptions {
+	if o.BatchSize == 0 {
+		o.BatchSize = other.BatchSize
+	}
+	if o.MaxUnavailable == 0 {
+		o.MaxUnavailable = other.MaxUnavailable
+	}
+	if o.FailureThreshold == 0 {
+		o.FailureThreshold = other.FailureThreshold
+	}
+	if o.WaitHealthy == 0 {
+		o.WaitHealthy = other.WaitHealthy
+	}
+	return o
+}
+
+// Progress of a rollout, which is saved after every batch so that an interrupted rollout can be resumed.
+type rolloutState struct {
+	CompletedTasks []int `json:"completed_tasks"`
+	// Fingerprint of the program which every completed task is updated to.
+	Fingerprints map[int]string `json:"fingerprints"`
+}
+
+// Fingerprint of the package, command, config files, env, hooks and probes of the program to deploy.
+func programFingerprint(prog *supervisor.Program) string {
+	data, _ := json.Marshal([]interface{}{prog.PkgName, prog.PkgMD5Sum, prog.Bin, prog.Args, prog.Configs, prog.Env,
+		prog.Hooks, prog.Type, prog.Probes})
+	return fmt.Sprintf("%x", md5.Sum(data))
+}
+
+func loadRolloutState(stateFile string) (*rolloutState, error) {
+	data, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	state := &rolloutState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("Invalid rolling update state %s: %v", stateFile, err)
+	}
+	return state, nil
+}
+
+func saveRolloutState(stateFile string, state *rolloutState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(stateFile), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(stateFile, data, 0644)
+}
+
+// Rolling update the tasks batch by batch. Every task of a batch is updated and waited to be healthy before the
+// next batch starts, and the rollout stops once the failed tasks reach the failure threshold.
+type rollout struct {
+	opts RollingUpdateOptions
+	// All tasks of the job, to count the unavailable ones.
+	jobHosts []*Host
+	// Tasks to update in task id order.
+	hosts     []*Host
+	stateFile string
+	// Fingerprint of the program to deploy by task id, a completed task is resumed only if its fingerprint matches.
+	fingerprints map[int]string
+	// Run the task function for the hosts concurrently, and return the results in the same order.
+	run    func([]*Host, func(context.Context, *Host) TaskResult) []TaskResult
+	update func(context.Context, *Host) error
+	// Return the deployed program of the task, and the error if it isn't healthy.
+	checkHealthy func(context.Context, *Host) (*supervisor.Program, error)
+	// Called with the result of every updated task once it's done, may be nil.
+	done         func(TaskResult)
+	pollInterval time.Duration
+}
+
+// Poll the task until it's healthy, opts.WaitHealthy elapsed or ctx is done, and return its last deployed program.
+func (r *rollout) waitHealthy(ctx context.Context, host *Host) (*supervisor.Program, error) {
+	deadline := time.Now().Add(r.opts.WaitHealthy)
+	for {
+		prog, err := r.checkHealthy(ctx, host)
+		if err == nil {
+			return prog, nil
+		}
+		if time.Now().After(deadline) {
+			return prog, fmt.Errorf("Not healthy after %v, %v", r.opts.WaitHealthy, err)
+		}
+		select {
+		case <-ctx.Done():
+			return prog, fmt.Errorf("Not healthy, %v", err)
+		case <-time.After(r.pollInterval):
+		}
+	}
+}
+
+// Update the task and wait for it to be healthy.
+func (r *rollout) updateTask(ctx context.Context, host *Host) TaskResult {
+	if err := r.update(ctx, host); err != nil {
+		return NewTaskResult(host, nil, err)
+	}
+	prog, err := r.waitHealthy(ctx, host)
+	return NewTaskResult(host, prog, err)
+}
+
+// Number of tasks in candidates which can be updated without exceeding max_unavailable.
+func (r *rollout) availableSlots(candidates []*Host) (int, error) {
+	inBatch := make(map[int]bool)
+	for _, host := range candidates {
+		inBatch[host.TaskId] = true
+	}
+	var others []*Host
+	for _, host := range r.jobHosts {
+		if !inBatch[host.TaskId] {
+			others = append(others, host)
+		}
+	}
+	unavailable := 0
+	for _, result := range r.run(others, func(ctx context.Context, host *Host) TaskResult {
+		_, err := r.checkHealthy(ctx, host)
+		return NewTaskResult(host, nil, err)
+	}) {
+		if result.Err != nil {
+			unavailable++
+		}
+	}
+	slots := r.opts.MaxUnavailable - unavailable
+	if slots <= 0 {
+		return 0, fmt.Errorf("%d task(s) of job are unavailable, which reaches max_unavailable %d",
+			unavailable, r.opts.MaxUnavailable)
+	}
+	if slots > len(candidates) {
+		slots = len(candidates)
+	}
+	return slots, nil
+}
+
+func (r *rollout) execute() []TaskResult {
+	state := &rolloutState{}
+	if r.opts.Resume {
+		if prevState, err := loadRolloutState(r.stateFile); err != nil {
+			log.Warnf("No rolling update to resume, start a new one: %v", err)
+		} else {
+			state = prevState
+		}
+	}
+	// Keep the tasks completed with the same programs only, the ones updated to other programs are updated again.
+	completed, updated := make(map[int]bool), make(map[int]bool)
+	prevTasks, prevFingerprints := state.CompletedTasks, state.Fingerprints
+	state = &rolloutState{Fingerprints: make(map[int]string)}
+	for _, taskId := range prevTasks {
+		updated[taskId] = true
+		if fingerprint, ok := prevFingerprints[taskId]; ok && fingerprint == r.fingerprints[taskId] {
+			completed[taskId] = true
+			state.CompletedTasks = append(state.CompletedTasks, taskId)
+			state.Fingerprints[taskId] = fingerprint
+		}
+	}
+	var pending []*Host
+	for _, host := range r.hosts {
+		if completed[host.TaskId] {
+			log.Infof("Skip task %s, which is completed by the previous rolling update", host.ToKey())
+			continue
+		}
+		if updated[host.TaskId] {
+			log.Warnf("Task %s was updated to another program by the previous rolling update, update it again",
+				host.ToKey())
+		}
+		pending = append(pending, host)
+	}
+
+	var taskResults []TaskResult
+	var abortErr error
+	failed := 0
+	for len(pending) > 0 {
+		if failed >= r.opts.FailureThreshold {
+			abortErr = fmt.Errorf("Rolling update aborted, %d task(s) failed which reaches failure_threshold %d",
+				failed, r.opts.FailureThreshold)
+			break
+		}
+		candidates := pending
+		if len(candidates) > r.opts.BatchSize {
+			candidates = candidates[:r.opts.BatchSize]
+		}
+		slots, err := r.availableSlots(candidates)
+		if err != nil {
+			abortErr = fmt.Errorf("Rolling update aborted, %v", err)
+			break
+		}
+		batch := candidates[:slots]
+		for _, result := range r.run(batch, func(ctx context.Context, host *Host) TaskResult {
+			result := r.updateTask(ctx, host)
+			if r.done != nil {
+				r.done(result)
+			}
+			return result
+		}) {
+			if result.Err != nil {
+				failed++
+			} else {
+				state.CompletedTasks = append(state.CompletedTasks, result.Host.TaskId)
+				state.Fingerprints[result.Host.TaskId] = r.fingerprints[result.Host.TaskId]
+			}
+			taskResults = append(taskResults, result)
+		}
+		pending = pending[len(batch):]
+		if err := saveRolloutState(r.stateFile, state); err != nil {
+			log.Warnf("Failed to save the rolling update state %s: %v", r.stateFile, err)
+		}
+	}
+
+	for _, host := range pending {
+		taskResults = append(taskResults, NewTaskResult(host, nil, abortErr))
+	}
+	if abortErr != nil || failed > 0 {
+		log.Errorf("Rolling update is not completed, retry the remaining tasks with --resume please.")
+	} else if err := os.Remove(r.stateFile); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Failed to remove the rolling update state %s: %v", r.stateFile, err)
+	}
+	return taskResults
+}
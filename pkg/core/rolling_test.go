@@ -0,0 +1,252 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/openinx/huker/pkg/supervisor"
+	"github.com/openinx/huker/pkg/utils"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake tasks for rolling update, the failed tasks never become healthy after update.
+type fakeTasks struct {
+	mu        sync.Mutex
+	healthy   map[int]bool
+	failed    map[int]bool
+	batches   [][]int
+	updatedBy map[int]int
+}
+
+func newFakeTasks(size int, failed ...int) *fakeTasks {
+	f := &fakeTasks{healthy: make(map[int]bool), failed: make(map[int]bool), updatedBy: make(map[int]int)}
+	for i := 0; i < size; i++ {
+		f.healthy[i] = true
+	}
+	for _, taskId := range failed {
+		f.failed[taskId] = true
+	}
+	return f
+}
+
+func (f *fakeTasks) newRollout(opts RollingUpdateOptions, stateFile string) *rollout {
+	var hosts []*Host
+	for i := 0; i < len(f.healthy); i++ {
+		hosts = append(hosts, &Host{Hostname: "127.0.0.1", SupervisorPort: 9001, TaskId: i})
+	}
+	j := &ConfigFileHukerJob{parallel: 10, taskTimeout: time.Minute}
+	calls := 0
+	return &rollout{
+		opts:      opts.fillWith(defaultRollingUpdateOptions),
+		jobHosts:  hosts,
+		hosts:     hosts,
+		stateFile: stateFile,
+		run: func(batch []*Host, task func(context.Context, *Host) TaskResult) []TaskResult {
+			// Every batch is preceded by a run to count the unavailable tasks.
+			calls++
+			if calls%2 == 0 {
+				var taskIds []int
+				for _, host := range batch {
+					taskIds = append(taskIds, host.TaskId)
+				}
+				f.batches = append(f.batches, taskIds)
+			}
+			return j.runTasks(batch, task)
+		},
+		update: func(ctx context.Context, host *Host) error {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.updatedBy[host.TaskId]++
+			f.healthy[host.TaskId] = false
+			return nil
+		},
+		checkHealthy: func(ctx context.Context, host *Host) (*supervisor.Program, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if !f.healthy[host.TaskId] && !f.failed[host.TaskId] && f.updatedBy[host.TaskId] > 0 {
+				f.healthy[host.TaskId] = true
+			}
+			if !f.healthy[host.TaskId] {
+				return nil, fmt.Errorf("task %d is unhealthy", host.TaskId)
+			}
+			return &supervisor.Program{Status: supervisor.StatusReady, PkgMD5Sum: "md5"}, nil
+		},
+		pollInterval: 10 * time.Millisecond,
+	}
+}
+
+func failedTasks(results []TaskResult) []int {
+	var taskIds []int
+	for _, result := range results {
+		if result.Err != nil {
+			taskIds = append(taskIds, result.Host.TaskId)
+		}
+	}
+	return taskIds
+}
+
+func TestRollout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rollout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := path.Join(dir, "state.json")
+
+	// All tasks are updated in batches of 2.
+	f := newFakeTasks(5)
+	results := f.newRollout(RollingUpdateOptions{BatchSize: 2, MaxUnavailable: 2}, stateFile).execute()
+	if len(results) != 5 || failedTasks(results) != nil {
+		t.Fatalf("All tasks should be updated, results: %v", results)
+	}
+	if !reflect.DeepEqual(f.batches, [][]int{{0, 1}, {2, 3}, {4}}) {
+		t.Errorf("Tasks should be updated in batches of 2, %v", f.batches)
+	}
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		t.Errorf("State file should be removed after the rollout completed, err: %v", err)
+	}
+
+	// The max_unavailable limits the batch size.
+	f = newFakeTasks(4)
+	f.newRollout(RollingUpdateOptions{BatchSize: 3, MaxUnavailable: 1}, stateFile).execute()
+	if !reflect.DeepEqual(f.batches, [][]int{{0}, {1}, {2}, {3}}) {
+		t.Errorf("Tasks should be updated one by one, %v", f.batches)
+	}
+
+	// Stop once the failed tasks reach the threshold.
+	f = newFakeTasks(6, 1, 2)
+	r := f.newRollout(RollingUpdateOptions{BatchSize: 1, MaxUnavailable: 3, FailureThreshold: 2,
+		WaitHealthy: 50 * time.Millisecond}, stateFile)
+	var done []TaskResult
+	r.done = func(result TaskResult) {
+		done = append(done, result)
+	}
+	results = r.execute()
+	if !reflect.DeepEqual(failedTasks(results), []int{1, 2, 3, 4, 5}) {
+		t.Errorf("Tasks after the second failure should be aborted, results: %v", results)
+	}
+	if len(done) != 3 || !reflect.DeepEqual(failedTasks(done), []int{1, 2}) {
+		t.Errorf("Only the updated tasks should be done, %v", done)
+	}
+	if f.updatedBy[3] != 0 {
+		t.Errorf("Task 3 should not be updated after the rollout aborted")
+	}
+
+	// Resume from the completed tasks, the failed ones are retried.
+	f.failed = make(map[int]bool)
+	results = f.newRollout(RollingUpdateOptions{BatchSize: 1, MaxUnavailable: 3, FailureThreshold: 2,
+		Resume: true}, stateFile).execute()
+	if len(results) != 5 || failedTasks(results) != nil {
+		t.Errorf("The remaining tasks should be updated, results: %v", results)
+	}
+	if f.updatedBy[0] != 1 {
+		t.Errorf("Task 0 completed by the previous rollout should be skipped")
+	}
+
+	// The tasks completed with other programs are updated again by the resumed rollout.
+	f = newFakeTasks(3, 2)
+	r = f.newRollout(RollingUpdateOptions{BatchSize: 1, MaxUnavailable: 3, WaitHealthy: 50 * time.Millisecond},
+		stateFile)
+	r.fingerprints = map[int]string{0: "a", 1: "a", 2: "a"}
+	if results = r.execute(); !reflect.DeepEqual(failedTasks(results), []int{2}) {
+		t.Fatalf("Task 2 should fail, results: %v", results)
+	}
+	f.failed = make(map[int]bool)
+	r = f.newRollout(RollingUpdateOptions{BatchSize: 1, MaxUnavailable: 3, Resume: true}, stateFile)
+	r.fingerprints = map[int]string{0: "a", 1: "b", 2: "b"}
+	if results = r.execute(); len(results) != 2 || failedTasks(results) != nil {
+		t.Errorf("Task 1 and 2 should be updated, results: %v", results)
+	}
+	if f.updatedBy[0] != 1 || f.updatedBy[1] != 2 {
+		t.Errorf("Only task 1 updated to another program should be updated again, %v", f.updatedBy)
+	}
+
+	// Abort if too many tasks are unavailable before the rollout.
+	f = newFakeTasks(3)
+	f.healthy[2] = false
+	results = f.newRollout(RollingUpdateOptions{BatchSize: 1, MaxUnavailable: 1}, stateFile).execute()
+	if !reflect.DeepEqual(failedTasks(results), []int{0, 1, 2}) || len(f.batches) != 0 {
+		t.Errorf("No task should be updated, results: %v, batches: %v", results, f.batches)
+	}
+}
+
+func TestParseRollingUpdateOptions(t *testing.T) {
+	job, err := NewJob("datanode", map[interface{}]interface{}{
+		"rolling_update": map[interface{}]interface{}{
+			"batch_size":           2,
+			"wait_healthy_seconds": 30,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	superJob, err := NewJob("base", map[interface{}]interface{}{
+		"rolling_update": map[interface{}]interface{}{
+			"batch_size":        4,
+			"failure_threshold": 3,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.SuperJob = "base"
+	if job, err = job.mergeWith(superJob); err != nil {
+		t.Fatal(err)
+	}
+	expected := RollingUpdateOptions{BatchSize: 2, FailureThreshold: 3, WaitHealthy: 30 * time.Second}
+	if job.RollingUpdate != expected {
+		t.Errorf("Rolling update options mismatch, %v != %v", job.RollingUpdate, expected)
+	}
+
+	for _, obj := range []interface{}{
+		"batch_size",
+		map[interface{}]interface{}{"batch_size": -1},
+		map[interface{}]interface{}{"batch_size": "2"},
+		map[interface{}]interface{}{"max_unavailable_tasks": 1},
+	} {
+		if _, err := parseRollingUpdateOptions(obj); err == nil {
+			t.Errorf("Rolling update options %v should be invalid", obj)
+		}
+	}
+}
+
+func TestRolloutStateDir(t *testing.T) {
+	j, err := NewConfigFileHukerJob(os.TempDir(), "http://127.0.0.1:4000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, jobPtr := &Cluster{ClusterName: "test-hdfs"}, &Job{JobName: "datanode"}
+	if r := j.newRollout(c, jobPtr, nil, nil, RollingUpdateOptions{}, "hdfs.test-hdfs.datanode"); r.stateFile !=
+		path.Join(utils.LocalHukerDir(), "rolling_update", "hdfs.test-hdfs.datanode.json") {
+		t.Errorf("State file should be under the local huker dir by default, instead of %s", r.stateFile)
+	}
+	j.SetRolloutStateDir("/tmp/rollout")
+	if r := j.newRollout(c, jobPtr, nil, nil, RollingUpdateOptions{}, "hdfs.test-hdfs.datanode"); r.stateFile !=
+		"/tmp/rollout/hdfs.test-hdfs.datanode.json" {
+		t.Errorf("State file should be under the given dir, instead of %s", r.stateFile)
+	}
+}
+
+func TestProgramFingerprint(t *testing.T) {
+	prog := &supervisor.Program{PkgName: "hadoop-2.6.5.tar.gz", PkgMD5Sum: "md5", Bin: "java",
+		Configs: map[string]string{"a": "b", "c": "d"}}
+	other := *prog
+	other.Configs = map[string]string{"c": "d", "a": "b"}
+	if programFingerprint(prog) != programFingerprint(&other) {
+		t.Errorf("Fingerprint of the same programs should be the same")
+	}
+	other.Configs = map[string]string{"a": "b", "c": "e"}
+	if programFingerprint(prog) == programFingerprint(&other) {
+		t.Errorf("Fingerprint of the programs with different configs should be different")
+	}
+	other = *prog
+	other.PkgMD5Sum = "md5-2"
+	if programFingerprint(prog) == programFingerprint(&other) {
+		t.Errorf("Fingerprint of the programs with different packages should be different")
+	}
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Error found when validating the cluster configurations, with the file, job, task and key as context.
+type ValidateError struct {
+	File   string
+	Job    string
+	TaskId int // -1 if the error is not specific to a task.
+	Key    string
+	Err    error
+}
+
+func (e *ValidateError) Error() string {
+	ctx := []string{e.File}
+	if e.Job != "" {
+		ctx = append(ctx, "job="+e.Job)
+	}
+	if e.TaskId >= 0 {
+		ctx = append(ctx, fmt.Sprintf("task=%d", e.TaskId))
+	}
+	if e.Key != "" {
+		ctx = append(ctx, "key="+e.Key)
+	}
+	return fmt.Sprintf("%s: %v", strings.Join(ctx, " "), e.Err)
+}
+
+// Render the input just like RenderConfigFiles, and treat the unresolved placeholders as error.
+func (c *Cluster) renderForValidate(job *Job, input string, taskId int, skipHostRender bool) error {
+	output, err := lineRender(c, job, taskId, input, skipHostRender)
+	if err != nil {
+		return err
+	}
+	// Any placeholder which is still left after rendering can not be resolved, except the host scoped ones
+	// when the HostRender is skipped.
+	unresolved, err := parsePlaceholders(output)
+	if err != nil {
+		return err
+	}
+	var placeholders []string
+	for _, ph := range unresolved {
+		if skipHostRender && exprScope(ph.expr) == scopeHost {
+			continue
+		}
+		placeholders = append(placeholders, ph.text)
+	}
+	if len(placeholders) > 0 {
+		return fmt.Errorf("Unresolved placeholder %s", strings.Join(placeholders, ", "))
+	}
+	return nil
+}
+
+func (c *Cluster) validateConfigFiles(job *Job, taskId int, skipHostRender bool) []*ValidateError {
+	if !skipHostRender {
+		job = job.forTask(taskId)
+	}
+	var errs []*ValidateError
+	var fnames []string
+	for fname := range job.ConfigFiles {
+		fnames = append(fnames, fname)
+	}
+	sort.Strings(fnames)
+	for _, fname := range fnames {
+		cfg := job.ConfigFiles[fname]
+		if err := c.renderForValidate(job, fname, taskId, skipHostRender); err != nil {
+			errs = append(errs, &ValidateError{File: c.ConfigPath, Job: job.JobName, TaskId: taskId, Key: fname, Err: err})
+		}
+		if tmpl, ok := cfg.(TemplateConfigFile); ok {
+			if _, err := tmpl.parse(templateFuncs(nil)); err != nil {
+				errs = append(errs, &ValidateError{File: c.ConfigPath, Job: job.JobName, TaskId: taskId, Key: fname, Err: err})
+			}
+		}
+		kvMap := cfg.ToKeyValue()
+		var keys []string
+		for key := range kvMap {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			for _, s := range []string{key, kvMap[key]} {
+				if err := c.renderForValidate(job, s, taskId, skipHostRender); err != nil {
+					errs = append(errs, &ValidateError{
+						File:   c.ConfigPath,
+						Job:    job.JobName,
+						TaskId: taskId,
+						Key:    fmt.Sprintf("%s:%s", fname, key),
+						Err:    err,
+					})
+					break
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func (c *Cluster) validateEnv(job *Job, taskId int, skipHostRender bool) []*ValidateError {
+	var errs []*ValidateError
+	var keys []string
+	for key := range job.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := c.renderForValidate(job, job.Env[key], taskId, skipHostRender); err != nil {
+			errs = append(errs, &ValidateError{File: c.ConfigPath, Job: job.JobName, TaskId: taskId, Key: "env:" + key, Err: err})
+		}
+	}
+	return errs
+}
+
+// The jobs referred by the decommission procedure should exist, and the procedure should be rendered for every task.
+func (c *Cluster) validateDecommission(job *Job) []*ValidateError {
+	var errs []*ValidateError
+	d := job.Decommission
+	for _, name := range []string{d.ExcludeJob, d.RefreshJob} {
+		if _, ok := c.Jobs[name]; name != "" && !ok {
+			errs = append(errs, &ValidateError{File: c.ConfigPath, Job: job.JobName, TaskId: -1, Key: "decommission",
+				Err: fmt.Errorf("Job `%s` does not exist", name)})
+		}
+	}
+	for _, host := range job.Hosts {
+		if _, err := c.renderDecommission(job, host.TaskId); err != nil {
+			errs = append(errs, &ValidateError{File: c.ConfigPath, Job: job.JobName, TaskId: host.TaskId,
+				Key: "decommission", Err: err})
+		}
+	}
+	return errs
+}
+
+// Render every config file of all jobs and tasks in the cluster, and collect all of the errors instead of
+// stopping at the first one. Jobs without hosts (such as shell jobs) will skip the HostRender.
+func (c *Cluster) Validate() []*ValidateError {
+	var errs []*ValidateError
+	var jobNames []string
+	for jobName := range c.Jobs {
+		jobNames = append(jobNames, jobName)
+	}
+	sort.Strings(jobNames)
+	for _, jobName := range jobNames {
+		job := c.Jobs[jobName]
+		if len(job.Hosts) == 0 {
+			errs = append(errs, c.validateConfigFiles(job, -1, true)...)
+			errs = append(errs, c.validateEnv(job, -1, true)...)
+			continue
+		}
+		for _, host := range job.Hosts {
+			errs = append(errs, c.validateConfigFiles(job, host.TaskId, false)...)
+			errs = append(errs, c.validateEnv(job, host.TaskId, false)...)
+		}
+		for _, o := range job.Overrides {
+			if _, ok := job.GetHost(o.TaskId); o.byTaskId() && !ok {
+				errs = append(errs, &ValidateError{File: c.ConfigPath, Job: job.JobName, TaskId: o.TaskId, Key: "overrides",
+					Err: fmt.Errorf("Override `%s` matches no task", o.Key)})
+			}
+		}
+		if job.Decommission != nil {
+			errs = append(errs, c.validateDecommission(job)...)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	cfg := `
+    cluster:
+      project: hdfs
+      cluster_name: tst-hdfs
+      main_process: /usr/bin/java
+      package_name: hadoop-2.6.5.tar.gz
+      package_md5sum: 967c24f3c15fcdd058f34923e92ce8ac
+    jobs:
+      namenode:
+        hosts:
+          - 127.0.0.1:9001/id=0/base_port=20100
+        config:
+          hdfs-site.xml:
+            - dfs.namenode.rpc-address=%{namenode.0.host}:%{namenode.0.base_port}
+            - dfs.namenode.http-address=%{namenode.x.host}:%{namenode.x.base_port+1}
+            - dfs.namenode.backup-address=%{namenode.1.host}
+            - dfs.nameservices=%{cluster.name}
+      datanode:
+        hosts:
+          - 127.0.0.1:9001/id=0/base_port=21000
+          - 127.0.0.1:9001/id=1/base_port=21010
+        config:
+          hdfs-site.xml:
+            - dfs.datanode.address=0.0.0.0:%{datanode.x.base_port}
+            - dfs.datanode.unknown=%{datanode.y.base_port}
+      shell:
+        config:
+          hdfs-site.xml:
+            - dfs.datanode.address=0.0.0.0:%{datanode.x.base_port}
+    `
+	c, err := NewCluster([]string{cfg}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ConfigPath = "conf/hdfs/tst-hdfs.yaml"
+
+	expected := []string{
+		"conf/hdfs/tst-hdfs.yaml job=datanode task=0 key=hdfs-site.xml:dfs.datanode.unknown: Unresolved placeholder %{datanode.y.base_port}",
+		"conf/hdfs/tst-hdfs.yaml job=datanode task=1 key=hdfs-site.xml:dfs.datanode.unknown: Unresolved placeholder %{datanode.y.base_port}",
+		"conf/hdfs/tst-hdfs.yaml job=namenode task=0 key=hdfs-site.xml:dfs.namenode.backup-address: Task not found at column 3: %{namenode.1.host}",
+	}
+	errs := c.Validate()
+	if len(errs) != len(expected) {
+		t.Fatalf("Size of errors mismatch, %d != %d: %v", len(errs), len(expected), errs)
+	}
+	for i := range errs {
+		if errs[i].Error() != expected[i] {
+			t.Errorf("Error #%d mismatch, [%s] != [%s]", i, errs[i].Error(), expected[i])
+		}
+	}
+
+	// Invalid base_port should be reported when loading the cluster.
+	invalidCfg := strings.Replace(cfg, "base_port=21010", "base_port=abc", 1)
+	if _, err := NewCluster([]string{invalidCfg}, nil); err == nil {
+		t.Errorf("Non-integer base_port should be failed.")
+	} else if !strings.Contains(err.Error(), "base_port=abc") {
+		t.Errorf("Error should contain the host key: %v", err)
+	}
+}
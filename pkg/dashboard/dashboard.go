@@ -11,21 +11,29 @@ import (
 	"github.com/qiniu/log"
 	"html/template"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Dashboard struct {
-	Port             int
-	srv              *http.Server
-	hukerJob         huker.HukerJob
-	refreshTicker    *time.Ticker
-	quit             chan int
+	Port          int
+	srv           *http.Server
+	hukerJob      *huker.ConfigFileHukerJob
+	refreshTicker *time.Ticker
+	quit          chan int
+	// Guard the cached clusters, which are replaced as a whole by refreshCache. The cached clusters and their hosts
+	// are never modified, so they can be read without the lock once got.
+	mu               sync.RWMutex
 	clusters         []*huker.Cluster
 	pkgServerAddress string
 	grafanaAddress   string
+	auditLog         *huker.AuditLog
+	locks            *huker.LockManager
+	operations       *operations
 }
 
 func NewDashboard(port int, configRootDir, pkgServerAddress string, grafanaAddress string) (*Dashboard, error) {
@@ -44,10 +52,42 @@ func NewDashboard(port int, configRootDir, pkgServerAddress string, grafanaAddre
 		clusters:         make([]*huker.Cluster, 0),
 		pkgServerAddress: pkgServerAddress,
 		grafanaAddress:   grafanaAddress,
+		auditLog:         huker.NewDefaultAuditLog(),
+		locks:            huker.NewDefaultLockManager(),
+		operations:       newOperations(),
 	}
 	return d, nil
 }
 
+// Set the audit log to record the operations of dashboard.
+func (d *Dashboard) SetAuditLog(auditLog *huker.AuditLog) {
+	d.auditLog = auditLog
+}
+
+// Set the lock manager to lock the jobs operated by dashboard, which should be shared with CLI.
+func (d *Dashboard) SetLockManager(locks *huker.LockManager) {
+	d.locks = locks
+}
+
+// Set the directory to save the progress of rolling updates, which should be shared with CLI to resume them.
+func (d *Dashboard) SetRolloutStateDir(dir string) {
+	d.hukerJob.SetRolloutStateDir(dir)
+}
+
+// Operator of the request, which is the user of basic auth if any, otherwise the address of client.
+func operatorOf(r *http.Request) string {
+	if username, _, ok := r.BasicAuth(); ok && username != "" {
+		return username
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
 type HandleFunc func(w http.ResponseWriter, r *http.Request) (string, error)
 
 func handleResponse(w http.ResponseWriter, r *http.Request, handleFunc HandleFunc) {
@@ -111,6 +151,8 @@ func (d *Dashboard) hNewCluster(w http.ResponseWriter, r *http.Request) {
 }
 
 func (d *Dashboard) getCluster(project string, clusterName string) *huker.Cluster {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 	for i := 0; i < len(d.clusters); i++ {
 		if d.clusters[i].Project == project && d.clusters[i].ClusterName == clusterName {
 			return d.clusters[i]
@@ -166,6 +208,13 @@ func (d *Dashboard) hConfig(w http.ResponseWriter, r *http.Request) {
 			return "", fmt.Errorf("Render configuration files failed, project:%s, cluster:%s, job:%s,task:%d, err: %v",
 				project, clusterName, jobName, taskId, err)
 		}
+		// Compare with the program deployed on agent, to tell whether the task needs a rolling_update.
+		var taskDiff *huker.TaskDiff
+		if taskDiffs, err := d.hukerJob.Diff(project, clusterName, jobName, taskId); err != nil {
+			taskDiff = &huker.TaskDiff{Err: err}
+		} else if len(taskDiffs) == 1 {
+			taskDiff = taskDiffs[0]
+		}
 
 		isFirst := 1
 		return utils.RenderHTMLTemplate("site/config.html", "site/base.html", map[string]interface{}{
@@ -173,6 +222,7 @@ func (d *Dashboard) hConfig(w http.ResponseWriter, r *http.Request) {
 			"Job":              jobName,
 			"TaskId":           strconv.Itoa(taskId),
 			"config":           configMap,
+			"diff":             taskDiff,
 			"pkgServerAddress": d.pkgServerAddress,
 		}, template.FuncMap{
 			"checkIsFirst": func() int {
@@ -195,6 +245,43 @@ func (d *Dashboard) hConfig(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// List the recorded operations, filtered by the query parameters project, cluster, job, action and operator.
+func (d *Dashboard) hHistory(w http.ResponseWriter, r *http.Request) {
+	handleResponse(w, r, func(w http.ResponseWriter, r *http.Request) (string, error) {
+		query := r.URL.Query()
+		filter := huker.AuditFilter{
+			Project:  query.Get("project"),
+			Cluster:  query.Get("cluster"),
+			Job:      query.Get("job"),
+			Action:   query.Get("action"),
+			Operator: query.Get("operator"),
+			Limit:    100,
+		}
+		if limit := query.Get("limit"); limit != "" {
+			var err error
+			if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+				return "", fmt.Errorf("limit should be a non-negative integer, instead of %s", limit)
+			}
+		}
+		records, err := d.auditLog.Query(filter)
+		if err != nil {
+			return "", err
+		}
+		return utils.RenderHTMLTemplate("site/history.html", "site/base.html", map[string]interface{}{
+			"filter":           filter,
+			"records":          records,
+			"pkgServerAddress": d.pkgServerAddress,
+		}, template.FuncMap{
+			"formatTime": func(t time.Time) string {
+				return t.Format("2006-01-02 15:04:05")
+			},
+			"formatDuration": func(d time.Duration) string {
+				return d.Round(time.Millisecond).String()
+			},
+		})
+	})
+}
+
 func (d *Dashboard) hStaticFile(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, utils.GetHukerDir()+"/site/static/"+mux.Vars(r)["filename"])
 }
@@ -224,66 +311,197 @@ func (d *Dashboard) hDeployAgent(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
-func (d *Dashboard) hWebApi(w http.ResponseWriter, r *http.Request) {
-	handleResponse(w, r, func(w http.ResponseWriter, r *http.Request) (string, error) {
-		action := mux.Vars(r)["action"]
-		project := mux.Vars(r)["project"]
-		cluster := mux.Vars(r)["cluster"]
-		job := mux.Vars(r)["job"]
-		taskId, err := strconv.Atoi(mux.Vars(r)["task_id"])
-		if err != nil {
-			return "", fmt.Errorf("task_id should be a integer, instead of %s", mux.Vars(r)["task_id"])
+// Status of the task after the action succeed.
+var successStatus = map[string]string{
+	"bootstrap":         supervisor.StatusRunning,
+	"install":           supervisor.StatusStopped,
+	"start":             supervisor.StatusRunning,
+	"stop":              supervisor.StatusStopped,
+	"restart":           supervisor.StatusRunning,
+	"rolling_update":    supervisor.StatusRunning,
+	"cleanup":           supervisor.StatusNotBootstrap,
+	"decommission":      supervisor.StatusStopped,
+	"recommission":      supervisor.StatusRunning,
+	"bootstrap-cluster": supervisor.StatusRunning,
+	"start-cluster":     supervisor.StatusRunning,
+	"stop-cluster":      supervisor.StatusStopped,
+}
+
+func (d *Dashboard) getHost(project, cluster, job string, taskId int) (*huker.Host, bool) {
+	if c := d.getCluster(project, cluster); c != nil {
+		if jobPtr, ok := c.Jobs[job]; ok {
+			return jobPtr.GetHost(taskId)
+		}
+	}
+	return nil, false
+}
+
+// Append the tasks of the job to operate, all tasks if taskId is -1.
+func (d *Dashboard) appendTasks(op *Operation, project, cluster, job string, taskId int) error {
+	c := d.getCluster(project, cluster)
+	if c == nil {
+		return fmt.Errorf("Cluster not found. project:%s, cluster:%s", project, cluster)
+	}
+	jobPtr, ok := c.Jobs[job]
+	if !ok {
+		return fmt.Errorf("Job not found. project:%s, cluster:%s, job:%s", project, cluster, job)
+	}
+	found := false
+	for _, host := range jobPtr.Hosts {
+		if taskId < 0 || host.TaskId == taskId {
+			op.Tasks = append(op.Tasks, &OperationTask{Project: project, Cluster: cluster, Job: job,
+				Host: host.ToKey(), TaskId: host.TaskId, Status: host.Attributes["status"]})
+			found = true
 		}
+	}
+	if taskId >= 0 && !found {
+		return fmt.Errorf("Task not found. project:%s, cluster:%s, job:%s, task:%d", project, cluster, job, taskId)
+	}
+	return nil
+}
 
-		var taskResults []huker.TaskResult
-		switch action {
-		case "bootstrap":
-			taskResults, err = d.hukerJob.Bootstrap(project, cluster, job, taskId)
-		case "start":
-			taskResults, err = d.hukerJob.Start(project, cluster, job, taskId)
-		case "stop":
-			taskResults, err = d.hukerJob.Stop(project, cluster, job, taskId)
-		case "restart":
-			taskResults, err = d.hukerJob.Restart(project, cluster, job, taskId)
-		case "rolling_update":
-			taskResults, err = d.hukerJob.RollingUpdate(project, cluster, job, taskId)
-		case "cleanup":
-			taskResults, err = d.hukerJob.Cleanup(project, cluster, job, taskId)
-		default:
-			return "", fmt.Errorf("Unsupported action: " + action)
+// Resolve the tasks of the operation, and return the function to run it.
+func (d *Dashboard) prepare(op *Operation, hukerJob huker.HukerJob) (func() ([]huker.JobResult, error), error) {
+	p, c, job, taskId := op.Project, op.Cluster, op.Job, op.TaskId
+	var runCluster func() ([]huker.JobResult, error)
+	switch op.Action {
+	case "bootstrap-cluster":
+		runCluster = func() ([]huker.JobResult, error) { return hukerJob.BootstrapCluster(p, c) }
+	case "start-cluster":
+		runCluster = func() ([]huker.JobResult, error) { return hukerJob.StartCluster(p, c) }
+	case "stop-cluster":
+		runCluster = func() ([]huker.JobResult, error) { return hukerJob.StopCluster(p, c) }
+	}
+	if runCluster != nil {
+		if job != "" {
+			return nil, fmt.Errorf("Action %s operates the whole cluster, instead of job %s", op.Action, job)
 		}
+		steps, err := d.hukerJob.ClusterSteps(p, c)
 		if err != nil {
-			log.Stack(err)
-			return "", err
+			return nil, err
 		}
-		if len(taskResults) != 1 {
-			return "", fmt.Errorf("TaskResults size should be 1, instead of %d, project: %s, cluster: %s, job:%s, task:%d",
-				len(taskResults), project, cluster, job, taskId)
+		for _, step := range steps {
+			if err := d.appendTasks(op, step.Project, step.Cluster, step.Job, -1); err != nil {
+				return nil, err
+			}
 		}
-		if taskResults[0].Err != nil {
-			return "", taskResults[0].Err
+		return runCluster, nil
+	}
+
+	var runJob func() ([]huker.TaskResult, error)
+	switch op.Action {
+	case "bootstrap":
+		runJob = func() ([]huker.TaskResult, error) { return hukerJob.Bootstrap(p, c, job, taskId) }
+	case "install":
+		runJob = func() ([]huker.TaskResult, error) { return hukerJob.Install(p, c, job, taskId) }
+	case "start":
+		runJob = func() ([]huker.TaskResult, error) { return hukerJob.Start(p, c, job, taskId) }
+	case "stop":
+		runJob = func() ([]huker.TaskResult, error) { return hukerJob.Stop(p, c, job, taskId) }
+	case "restart":
+		runJob = func() ([]huker.TaskResult, error) { return hukerJob.Restart(p, c, job, taskId) }
+	case "rolling_update":
+		runJob = func() ([]huker.TaskResult, error) {
+			return hukerJob.RollingUpdate(p, c, job, taskId, huker.RollingUpdateOptions{})
 		}
+	case "cleanup":
+		runJob = func() ([]huker.TaskResult, error) { return hukerJob.Cleanup(p, c, job, taskId) }
+	case "decommission":
+		runJob = func() ([]huker.TaskResult, error) { return hukerJob.Decommission(p, c, job, taskId) }
+	case "recommission":
+		runJob = func() ([]huker.TaskResult, error) { return hukerJob.Recommission(p, c, job, taskId) }
+	default:
+		return nil, fmt.Errorf("Unsupported action: %s", op.Action)
+	}
+	if job == "" {
+		return nil, fmt.Errorf("Action %s should operate a job", op.Action)
+	}
+	if err := d.appendTasks(op, p, c, job, taskId); err != nil {
+		return nil, err
+	}
+	return func() ([]huker.JobResult, error) {
+		results, err := runJob()
+		return []huker.JobResult{{ClusterStep: huker.ClusterStep{Project: p, Cluster: c, Job: job}, Results: results}}, err
+	}, nil
+}
 
-		successStatus := map[string]string{
-			"bootstrap":      supervisor.StatusRunning,
-			"start":          supervisor.StatusRunning,
-			"stop":           supervisor.StatusStopped,
-			"restart":        supervisor.StatusRunning,
-			"rolling_update": supervisor.StatusRunning,
-			"cleanup":        supervisor.StatusNotBootstrap,
+// Submit an operation running in background and return it as json, the progress of which is polled from
+// /api/operations/{id}. The job and task_id are optional, the operation of job without task_id operates all tasks
+// of the job, and the operation of cluster is one of bootstrap-cluster, start-cluster and stop-cluster.
+func (d *Dashboard) hWebApi(w http.ResponseWriter, r *http.Request) {
+	handleResponse(w, r, func(w http.ResponseWriter, r *http.Request) (string, error) {
+		vars := mux.Vars(r)
+		op := &Operation{
+			Action:   vars["action"],
+			Operator: operatorOf(r),
+			Project:  vars["project"],
+			Cluster:  vars["cluster"],
+			Job:      vars["job"],
+			TaskId:   -1,
+		}
+		if taskId, ok := vars["task_id"]; ok {
+			var err error
+			if op.TaskId, err = strconv.Atoi(taskId); err != nil {
+				return "", fmt.Errorf("task_id should be a integer, instead of %s", taskId)
+			}
 		}
 
-		// Refresh the status if action succeed.
-		for i := 0; i < len(d.clusters); i++ {
-			if d.clusters[i].ClusterName == cluster {
-				if jobPtr, ok := d.clusters[i].Jobs[job]; ok {
-					if host, ok := jobPtr.GetHost(taskId); ok {
-						host.Attributes["status"] = successStatus[action]
-					}
-				}
+		status := func(result huker.TaskResult) string {
+			return taskStatus(op.Action, result)
+		}
+		// Report the progress of every task once it's done.
+		base := d.hukerJob.WithTaskListener(func(cluster, job string, result huker.TaskResult) {
+			d.operations.progress(op, cluster, job, result, status)
+		})
+		locked := huker.NewLockedHukerJob(base, d.locks, op.Operator+" ("+huker.OriginDashboard+")", false)
+		run, err := d.prepare(op, huker.NewAuditedHukerJob(locked, d.auditLog, huker.OriginDashboard, op.Operator))
+		if err != nil {
+			return "", err
+		}
+		submitted := d.operations.submit(op, func() ([]huker.JobResult, error) {
+			jobResults, err := run()
+			// Refresh the status of the operated tasks.
+			if err := d.refreshCache(); err != nil {
+				log.Errorf("Failed to refresh the status of tasks after %s %s: %v", op.Action, op.Id, err)
 			}
+			return jobResults, err
+		}, status)
+		data, err := json.Marshal(submitted)
+		return string(data), err
+	})
+}
+
+// Status of the task after the action succeed, the started task reports its readiness.
+func taskStatus(action string, result huker.TaskResult) string {
+	if result.Prog != nil {
+		return result.Prog.Status
+	}
+	return successStatus[action]
+}
+
+// Progress of the operation, with the latest status of its pending tasks.
+func (d *Dashboard) hOperation(w http.ResponseWriter, r *http.Request) {
+	handleResponse(w, r, func(w http.ResponseWriter, r *http.Request) (string, error) {
+		id := mux.Vars(r)["id"]
+		op, ok := d.operations.get(id, func(task *OperationTask) string {
+			if host, ok := d.getHost(task.Project, task.Cluster, task.Job, task.TaskId); ok {
+				return host.Attributes["status"]
+			}
+			return task.Status
+		})
+		if !ok {
+			return "", fmt.Errorf("Operation not found: %s", id)
 		}
-		return "", nil
+		data, err := json.Marshal(op)
+		return string(data), err
+	})
+}
+
+// List the running and the latest finished operations.
+func (d *Dashboard) hOperations(w http.ResponseWriter, r *http.Request) {
+	handleResponse(w, r, func(w http.ResponseWriter, r *http.Request) (string, error) {
+		data, err := json.Marshal(d.operations.list())
+		return string(data), err
 	})
 }
 
@@ -311,7 +529,9 @@ func (s *Dashboard) refreshCache() error {
 			}
 		}
 	}
+	s.mu.Lock()
 	s.clusters = clusters
+	s.mu.Unlock()
 	return nil
 }
 
@@ -342,8 +562,13 @@ func (s *Dashboard) Start() error {
 	r.HandleFunc("/new-cluster", s.hNewCluster)
 	r.HandleFunc("/detail/{project}/{cluster}", s.hDetail)
 	r.HandleFunc("/config/{project}/{cluster}/{job}/{task_id}", s.hConfig)
+	r.HandleFunc("/history", s.hHistory)
 	r.HandleFunc("/static/{filename}", s.hStaticFile)
 	r.HandleFunc("/api/deploy-agent", s.hDeployAgent)
+	r.HandleFunc("/api/operations", s.hOperations)
+	r.HandleFunc("/api/operations/{id}", s.hOperation)
+	r.HandleFunc("/api/{action}/{project}/{cluster}", s.hWebApi)
+	r.HandleFunc("/api/{action}/{project}/{cluster}/{job}", s.hWebApi)
 	r.HandleFunc("/api/{action}/{project}/{cluster}/{job}/{task_id}", s.hWebApi)
 	s.srv.Handler = r
 
@@ -114,14 +114,14 @@ func deployHukerAgent(req *DeployRequest) error {
 
 	conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", h.hostname, h.sshPort), sshConfig)
 	if err != nil {
-		log.Error("Failed to dial: %v", err)
+		log.Errorf("Failed to dial: %v", err)
 		return fmt.Errorf("Failed to dial: %v", err)
 	}
 	defer conn.Close()
 
 	session, err := conn.NewSession()
 	if err != nil {
-		log.Error("Failed to create new session to sshd-server, %v", err)
+		log.Errorf("Failed to create new session to sshd-server, %v", err)
 		return err
 	}
 	defer session.Close()
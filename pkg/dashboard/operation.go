@@ -0,0 +1,208 @@
+package dashboard
+
+import (
+	"fmt"
+	huker "github.com/openinx/huker/pkg/core"
+	"github.com/qiniu/log"
+	"sync"
+	"time"
+)
+
+// States of the operations and their tasks.
+const (
+	OperationPending = "pending"
+	OperationRunning = "running"
+	OperationSucceed = "succeed"
+	OperationFailed  = "failed"
+	// The task isn't operated because the operation failed before reaching it.
+	OperationSkipped = "skipped"
+)
+
+// Number of the finished operations kept in memory.
+const maxFinishedOperations = 100
+
+// A task operated by the operation.
+type OperationTask struct {
+	Project string `json:"project"`
+	Cluster string `json:"cluster"`
+	Job     string `json:"job"`
+	Host    string `json:"host"`
+	TaskId  int    `json:"task_id"`
+	State   string `json:"state"`
+	// Status of the program reported by the agent, which is refreshed while the operation runs.
+	Status string `json:"status"`
+	Err    string `json:"error,omitempty"`
+}
+
+// An operation of dashboard running in background, which operates a task, all tasks of a job or a whole cluster.
+type Operation struct {
+	Id       string `json:"id"`
+	Action   string `json:"action"`
+	Operator string `json:"operator"`
+	Project  string `json:"project"`
+	Cluster  string `json:"cluster"`
+	// Empty for the operations of the whole cluster.
+	Job string `json:"job,omitempty"`
+	// -1 if all tasks of the job are operated.
+	TaskId    int              `json:"task_id"`
+	State     string           `json:"state"`
+	StartTime time.Time        `json:"start_time"`
+	EndTime   time.Time        `json:"end_time"`
+	Tasks     []*OperationTask `json:"tasks"`
+	// Error of the whole operation, the errors of tasks are kept in Tasks.
+	Err string `json:"error,omitempty"`
+}
+
+func (op *Operation) Finished() bool {
+	return op.State == OperationSucceed || op.State == OperationFailed
+}
+
+func (op *Operation) copy() *Operation {
+	c := *op
+	c.Tasks = make([]*OperationTask, len(op.Tasks))
+	for i, task := range op.Tasks {
+		t := *task
+		c.Tasks[i] = &t
+	}
+	return &c
+}
+
+// Registry of the operations, the running ones and the latest finished ones.
+type operations struct {
+	mu  sync.Mutex
+	seq int
+	ops map[string]*Operation
+	// Ids of the operations in submitted order.
+	ids []string
+}
+
+func newOperations() *operations {
+	return &operations{ops: make(map[string]*Operation)}
+}
+
+// Run the operation in background, and return a snapshot of the submitted operation. The run function returns the
+// results of every operated job, and the status of every succeed task is resolved by status.
+func (o *operations) submit(op *Operation, run func() ([]huker.JobResult, error),
+	status func(result huker.TaskResult) string) *Operation {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.seq++
+	op.StartTime = time.Now()
+	op.Id = fmt.Sprintf("%s-%d", op.StartTime.Format("20060102150405"), o.seq)
+	op.State = OperationRunning
+	for _, task := range op.Tasks {
+		task.State = OperationPending
+	}
+	o.ops[op.Id] = op
+	o.ids = append(o.ids, op.Id)
+	o.trim()
+
+	go func() {
+		jobResults, err := run()
+		if err != nil {
+			log.Errorf("Failed to %s %s: %v", op.Action, op.Project+"/"+op.Cluster, err)
+		}
+		o.finish(op, jobResults, err, status)
+	}()
+	return op.copy()
+}
+
+func (o *operations) finish(op *Operation, jobResults []huker.JobResult, err error,
+	status func(result huker.TaskResult) string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	results := make(map[string]huker.TaskResult)
+	for _, jobResult := range jobResults {
+		for _, result := range jobResult.Results {
+			results[fmt.Sprintf("%s/%s/%d", jobResult.Cluster, jobResult.Job, result.Host.TaskId)] = result
+		}
+	}
+	op.State = OperationSucceed
+	if err != nil {
+		op.State, op.Err = OperationFailed, err.Error()
+	}
+	for _, task := range op.Tasks {
+		result, ok := results[fmt.Sprintf("%s/%s/%d", task.Cluster, task.Job, task.TaskId)]
+		if !ok {
+			task.State = OperationSkipped
+			continue
+		}
+		settle(task, result, status)
+		if task.State == OperationFailed {
+			op.State = OperationFailed
+		}
+	}
+	op.EndTime = time.Now()
+}
+
+// Record the result of a task of the running operation once it's done, before the whole operation finishes. The
+// results of the tasks not operated by op are ignored.
+func (o *operations) progress(op *Operation, cluster, job string, result huker.TaskResult,
+	status func(result huker.TaskResult) string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if op.Finished() {
+		return
+	}
+	for _, task := range op.Tasks {
+		if task.Cluster == cluster && task.Job == job && task.TaskId == result.Host.TaskId {
+			settle(task, result, status)
+		}
+	}
+}
+
+func settle(task *OperationTask, result huker.TaskResult, status func(result huker.TaskResult) string) {
+	if result.Err != nil {
+		task.State, task.Err = OperationFailed, result.Err.Error()
+		return
+	}
+	task.State, task.Status, task.Err = OperationSucceed, status(result), ""
+}
+
+// Forget the oldest finished operations beyond the limit, must be called with the lock held.
+func (o *operations) trim() {
+	finished := 0
+	for _, id := range o.ids {
+		if o.ops[id].Finished() {
+			finished++
+		}
+	}
+	var ids []string
+	for _, id := range o.ids {
+		if finished > maxFinishedOperations && o.ops[id].Finished() {
+			delete(o.ops, id)
+			finished--
+			continue
+		}
+		ids = append(ids, id)
+	}
+	o.ids = ids
+}
+
+// Snapshot of the operation, with the status of its pending tasks refreshed by status.
+func (o *operations) get(id string, status func(task *OperationTask) string) (*Operation, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	op, ok := o.ops[id]
+	if !ok {
+		return nil, false
+	}
+	c := op.copy()
+	for _, task := range c.Tasks {
+		if task.State == OperationPending {
+			task.Status = status(task)
+		}
+	}
+	return c, true
+}
+
+// Snapshots of all operations, the latest ones come first.
+func (o *operations) list() []*Operation {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var ops []*Operation
+	for i := len(o.ids) - 1; i >= 0; i-- {
+		ops = append(ops, o.ops[o.ids[i]].copy())
+	}
+	return ops
+}
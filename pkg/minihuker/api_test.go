@@ -1,12 +1,26 @@
 package minihuker
 
 import (
+	"fmt"
 	"github.com/openinx/huker/pkg/core"
 	"github.com/openinx/huker/pkg/supervisor"
 	"github.com/openinx/huker/pkg/utils"
+	"path"
+	"reflect"
+	"sync"
 	"testing"
 )
 
+// Huker job of the testing config, which saves the progress of rolling updates under the agent root dir of m.
+func newTestingHukerJob(m *MiniHuker) (*core.ConfigFileHukerJob, error) {
+	hukerJob, err := core.NewConfigFileHukerJob(utils.GetHukerSourceDir()+"/testdata/conf", localHttpAddress(testPkgSrvPort))
+	if err != nil {
+		return nil, err
+	}
+	hukerJob.SetRolloutStateDir(path.Join(m.Supervisor[0].RootDir(), "rolling_update"))
+	return hukerJob, nil
+}
+
 func TestHukerJob(t *testing.T) {
 	// TODO try to increase task size to 5. need to render(both global & host) the extra_args section.
 	taskSize := 1
@@ -15,7 +29,7 @@ func TestHukerJob(t *testing.T) {
 	miniHuker.Start()
 	defer miniHuker.Stop()
 
-	hukerJob, err := core.NewConfigFileHukerJob(utils.GetHukerSourceDir()+"/testdata/conf", localHttpAddress(testPkgSrvPort))
+	hukerJob, err := newTestingHukerJob(miniHuker)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -57,6 +71,21 @@ func TestHukerJob(t *testing.T) {
 			t.Errorf("Status of %s shoud be Running. other than %s", results[i].Host.ToKey(), results[i].Prog.Status)
 		}
 	}
+	// Test Diff, the deployed program should be the same as the desired one.
+	taskDiffs, err := hukerJob.Diff(project, cluster, job, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(taskDiffs) != taskSize {
+		t.Fatalf("Result size of Diff mismatch, %d != %d", len(taskDiffs), taskSize)
+	}
+	for i := 0; i < taskSize; i++ {
+		if taskDiffs[i].Err != nil {
+			t.Errorf("Diff task %s failed, %v", taskDiffs[i].Host.ToKey(), taskDiffs[i].Err)
+		} else if taskDiffs[i].NeedRollingUpdate() {
+			t.Errorf("Task %s should be up to date, diff: %v", taskDiffs[i].Host.ToKey(), taskDiffs[i])
+		}
+	}
 	// Test Start
 	results, err = hukerJob.Start(project, cluster, job, -1)
 	if err != nil {
@@ -84,7 +113,7 @@ func TestHukerJob(t *testing.T) {
 		}
 	}
 	// Test RollingUpdate
-	results, err = hukerJob.RollingUpdate(project, cluster, job, -1)
+	results, err = hukerJob.RollingUpdate(project, cluster, job, -1, core.RollingUpdateOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -124,6 +153,73 @@ func TestHukerJob(t *testing.T) {
 	}
 }
 
+func TestHukerJobCluster(t *testing.T) {
+	miniHuker := NewTestingMiniHuker(1)
+	miniHuker.Start()
+	defer miniHuker.Stop()
+
+	hukerJob, err := newTestingHukerJob(miniHuker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	project, cluster, job := "pyserver", "py_test", "httpserver"
+	// The result of every operated task is notified once it's done.
+	var mu sync.Mutex
+	var notified []string
+	listened := hukerJob.WithTaskListener(func(cluster, job string, result core.TaskResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		notified = append(notified, fmt.Sprintf("%s/%s/%d", cluster, job, result.Host.TaskId))
+	})
+	checkStatus := func(action string, jobResults []core.JobResult, err error, status string) {
+		if err != nil {
+			t.Fatalf("%s failed: %v", action, err)
+		}
+		mu.Lock()
+		if !reflect.DeepEqual(notified, []string{"py_test/httpserver/0"}) {
+			t.Errorf("%s should notify the result of task once, %v", action, notified)
+		}
+		notified = nil
+		mu.Unlock()
+		// The shell job without hosts is skipped.
+		if len(jobResults) != 1 || jobResults[0].Job != job || len(jobResults[0].Results) != 1 {
+			t.Fatalf("%s should operate the httpserver job only, %v", action, jobResults)
+		}
+		if jobResults[0].Results[0].Err != nil {
+			t.Fatalf("%s task failed: %v", action, jobResults[0].Results[0].Err)
+		}
+		results, err := hukerJob.Show(project, cluster, job, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results[0].Err != nil || results[0].Prog.Status != status {
+			t.Fatalf("Status after %s should be %s, result: %v", action, status, results[0])
+		}
+	}
+
+	jobResults, err := listened.BootstrapCluster(project, cluster)
+	checkStatus("BootstrapCluster", jobResults, err, supervisor.StatusRunning)
+	// The running tasks are skipped.
+	jobResults, err = listened.BootstrapCluster(project, cluster)
+	checkStatus("BootstrapCluster", jobResults, err, supervisor.StatusRunning)
+	jobResults, err = listened.StopCluster(project, cluster)
+	checkStatus("StopCluster", jobResults, err, supervisor.StatusStopped)
+	jobResults, err = listened.StopCluster(project, cluster)
+	checkStatus("StopCluster", jobResults, err, supervisor.StatusStopped)
+	jobResults, err = listened.StartCluster(project, cluster)
+	checkStatus("StartCluster", jobResults, err, supervisor.StatusRunning)
+	jobResults, err = listened.StopCluster(project, cluster)
+	checkStatus("StopCluster", jobResults, err, supervisor.StatusStopped)
+
+	results, err := hukerJob.Cleanup(project, cluster, job, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err != nil {
+		t.Errorf("Cleanup task %s failed, %v", results[0].Host.ToKey(), results[0].Err)
+	}
+}
+
 func TestHukerJobList(t *testing.T) {
 	hukerJob, err := core.NewConfigFileHukerJob(utils.GetHukerSourceDir()+"/testdata/conf", localHttpAddress(testPkgSrvPort))
 	if err != nil {
@@ -149,3 +245,94 @@ func TestHukerJobList(t *testing.T) {
 		}
 	}
 }
+
+func TestHukerJobRender(t *testing.T) {
+	hukerJob, err := core.NewConfigFileHukerJob(utils.GetHukerSourceDir()+"/testdata/conf", localHttpAddress(testPkgSrvPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Render should not contact any agent.
+	results, err := hukerJob.Render("pyserver", "py_test", "httpserver", -1, "/tmp/agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Result size of render mismatch, %d != %d", len(results), 1)
+	}
+	prog := results[0].Prog
+	if prog.Bin != "python" || prog.TaskId != 0 || prog.RootDir != "/tmp/agent/py_test/httpserver.0" {
+		t.Errorf("Rendered program mismatch: %v", prog)
+	}
+	if prog.Type != supervisor.ProcessPython || !reflect.DeepEqual(prog.Args, []string{"-m", "SimpleHTTPServer", "30120"}) {
+		t.Errorf("Rendered args mismatch, type: %s, args: %v", prog.Type, prog.Args)
+	}
+	if prog.Configs["test.cfg"] != "hello=world" {
+		t.Errorf("Rendered config mismatch: %v", prog.Configs)
+	}
+	if prog.Env["PYTHONPATH"] != "/tmp/agent/py_test/httpserver.0/pkg" || prog.Env["HUKER_TASK"] != "py_test/httpserver.0" {
+		t.Errorf("Rendered env mismatch: %v", prog.Env)
+	}
+	if results, err = hukerJob.Render("pyserver", "py_test", "httpserver", 100, "/tmp/agent"); err != nil || len(results) != 0 {
+		t.Errorf("Render non-existent task should return no result, results: %v, err: %v", results, err)
+	}
+}
+
+func TestHukerJobDecommission(t *testing.T) {
+	miniHuker := NewTestingMiniHuker(1)
+	miniHuker.Start()
+	defer miniHuker.Stop()
+
+	hukerJob, err := newTestingHukerJob(miniHuker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	project, cluster, job := "pyserver", "py_test", "httpserver"
+	if _, err := hukerJob.Decommission(project, cluster, "shell", -1); err == nil {
+		t.Errorf("Decommission the job without decommission procedure should fail")
+	}
+	results, err := hukerJob.Bootstrap(project, cluster, job, -1)
+	if err != nil || results[0].Err != nil {
+		t.Fatalf("Bootstrap failed, results: %v, err: %v", results, err)
+	}
+	defer hukerJob.Cleanup(project, cluster, job, -1)
+	defer hukerJob.Stop(project, cluster, job, -1)
+
+	// Decommission twice, the exclude entry is added once and the stopped task is skipped.
+	for i := 0; i < 2; i++ {
+		results, err = hukerJob.Decommission(project, cluster, job, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].Err != nil || results[0].Prog.Status != supervisor.StatusStopped {
+			t.Fatalf("Task should be stopped after decommissioned, results: %v", results)
+		}
+		if exclude := results[0].Prog.Configs["exclude"]; exclude != "127.0.0.1:30120\n" {
+			t.Errorf("Exclude file mismatch: %q", exclude)
+		}
+	}
+
+	// The decommissioned entry is no drift, and it's kept by deploying the exclude_job from yaml again.
+	diffs, err := hukerJob.Diff(project, cluster, job, 0)
+	if err != nil || len(diffs) != 1 || diffs[0].Err != nil || diffs[0].Configs["exclude"] != "" {
+		t.Fatalf("Decommissioned entry should not be reported by diff, diffs: %v, err: %v", diffs, err)
+	}
+	results, err = hukerJob.RollingUpdate(project, cluster, job, 0, core.RollingUpdateOptions{})
+	if err != nil || len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("Rolling update failed, results: %v, err: %v", results, err)
+	}
+	if results, err = hukerJob.Show(project, cluster, job, 0); err != nil || results[0].Err != nil ||
+		results[0].Prog.Configs["exclude"] != "127.0.0.1:30120\n" {
+		t.Fatalf("Decommissioned entry should be kept by rolling update, results: %v, err: %v", results, err)
+	}
+
+	results, err = hukerJob.Recommission(project, cluster, job, 0)
+	if err != nil || len(results) != 1 || results[0].Err != nil || results[0].Prog.Status != supervisor.StatusRunning {
+		t.Fatalf("Task should be running after recommissioned, results: %v, err: %v", results, err)
+	}
+	if exclude := results[0].Prog.Configs["exclude"]; exclude != "" {
+		t.Errorf("Exclude file should be empty after recommissioned: %q", exclude)
+	}
+	if diffs, err = hukerJob.Diff(project, cluster, job, 0); err != nil || diffs[0].NeedRollingUpdate() {
+		t.Errorf("Recommissioned task should match yaml, diffs: %v, err: %v", diffs, err)
+	}
+}
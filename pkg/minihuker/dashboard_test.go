@@ -0,0 +1,97 @@
+package minihuker
+
+import (
+	"encoding/json"
+	dash "github.com/openinx/huker/pkg/dashboard"
+	"github.com/openinx/huker/pkg/supervisor"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func getDashboardApi(t *testing.T, uri string) (int, []byte) {
+	resp, err := http.Get(localHttpAddress(testDashboardPort) + uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+// Submit the operation and wait until it finished.
+func runDashboardOperation(t *testing.T, uri string) *dash.Operation {
+	code, data := getDashboardApi(t, uri)
+	if code != http.StatusOK {
+		t.Fatalf("Failed to submit %s: %s", uri, data)
+	}
+	op := &dash.Operation{}
+	if err := json.Unmarshal(data, op); err != nil {
+		t.Fatal(err)
+	}
+	if op.State != dash.OperationRunning || len(op.Tasks) != 1 || op.Tasks[0].State != dash.OperationPending {
+		t.Fatalf("Operation %s should be running with a pending task, %s", uri, data)
+	}
+	for deadline := time.Now().Add(time.Minute); !op.Finished(); time.Sleep(200 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Operation %s doesn't finish in time, %+v", uri, op)
+		}
+		code, data = getDashboardApi(t, "/api/operations/"+op.Id)
+		if code != http.StatusOK {
+			t.Fatalf("Failed to get operation %s: %s", op.Id, data)
+		}
+		op = &dash.Operation{}
+		if err := json.Unmarshal(data, op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return op
+}
+
+func TestDashboardOperations(t *testing.T) {
+	miniHuker := NewTestingMiniHuker(1)
+	miniHuker.Start()
+	defer miniHuker.Stop()
+
+	for _, c := range []struct {
+		uri    string
+		status string
+	}{
+		{"/api/bootstrap-cluster/pyserver/py_test", supervisor.StatusRunning},
+		{"/api/stop/pyserver/py_test/httpserver", supervisor.StatusStopped},
+		{"/api/start/pyserver/py_test/httpserver/0", supervisor.StatusRunning},
+		{"/api/stop-cluster/pyserver/py_test", supervisor.StatusStopped},
+		{"/api/cleanup/pyserver/py_test/httpserver", supervisor.StatusNotBootstrap},
+	} {
+		op := runDashboardOperation(t, c.uri)
+		if op.State != dash.OperationSucceed || op.Tasks[0].State != dash.OperationSucceed ||
+			op.Tasks[0].Job != "httpserver" || op.Tasks[0].Status != c.status {
+			t.Errorf("Operation %s should succeed with task %s, %+v, task: %+v", c.uri, c.status, op, op.Tasks[0])
+		}
+	}
+
+	for _, uri := range []string{
+		"/api/start/pyserver/py_test/not-exist",
+		"/api/start/pyserver/py_test/httpserver/5",
+		"/api/start-cluster/pyserver/py_test/httpserver",
+		"/api/start/pyserver/py_test",
+		"/api/operations/not-exist",
+	} {
+		if code, data := getDashboardApi(t, uri); code != http.StatusBadRequest {
+			t.Errorf("Request %s should fail, code: %d, body: %s", uri, code, data)
+		}
+	}
+
+	code, data := getDashboardApi(t, "/api/operations")
+	var ops []*dash.Operation
+	if err := json.Unmarshal(data, &ops); err != nil || code != http.StatusOK {
+		t.Fatalf("Failed to list operations, code: %d, body: %s, err: %v", code, data, err)
+	}
+	if len(ops) != 5 || ops[0].Action != "cleanup" {
+		t.Errorf("Operations should be listed latest first, %s", data)
+	}
+}
@@ -2,12 +2,14 @@ package minihuker
 
 import (
 	"fmt"
+	huker "github.com/openinx/huker/pkg/core"
 	dash "github.com/openinx/huker/pkg/dashboard"
 	"github.com/openinx/huker/pkg/pkgsrv"
 	"github.com/openinx/huker/pkg/supervisor"
 	"github.com/openinx/huker/pkg/utils"
 	"github.com/qiniu/log"
 	"os"
+	"path"
 	"strconv"
 	"sync"
 	"time"
@@ -67,6 +69,9 @@ func NewMiniHuker(cfgRootDir string, agentSize int, agentRootDir string, agentPo
 	if err != nil {
 		panic(err)
 	}
+	dashboard.SetAuditLog(huker.NewAuditLog(path.Join(agentRootDir, "audit.log")))
+	dashboard.SetLockManager(huker.NewLockManager(path.Join(agentRootDir, "locks")))
+	dashboard.SetRolloutStateDir(path.Join(agentRootDir, "rolling_update"))
 
 	m := &MiniHuker{
 		SupervisorSize: agentSize,
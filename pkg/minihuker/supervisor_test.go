@@ -6,8 +6,11 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func NewProgram() *supervisor.Program {
@@ -189,6 +192,50 @@ func TestHooks(t *testing.T) {
 	}
 }
 
+func TestInstall(t *testing.T) {
+	m := NewTestingMiniHuker(1)
+	m.Start()
+	defer m.Stop()
+
+	prog := NewProgram()
+	for _, hookName := range []string{"pre_install", "post_install"} {
+		prog.Hooks[hookName] = fmt.Sprintf(hookScript, hookName)
+	}
+	if err := m.SuperClient[0].Install(prog); err != nil {
+		t.Fatalf("install failed: %v", err)
+	}
+	if err := m.SuperClient[0].Install(prog); err == nil {
+		t.Fatalf("install twice should be failed")
+	}
+
+	if p, err := m.SuperClient[0].Show(prog.Name, prog.Job, prog.TaskId); err != nil {
+		t.Fatalf("show process failed: %v", err)
+	} else if p.Status != supervisor.StatusStopped {
+		t.Fatalf("process should be stopped after install, instead of %s", p.Status)
+	} else if data, err := ioutil.ReadFile(path.Join(p.RootDir, "conf", "a")); err != nil || string(data) != "b" {
+		t.Fatalf("config file a mismatch, data: %s, err: %v", string(data), err)
+	}
+	for _, hookName := range []string{"pre_install", "post_install"} {
+		testHookFile := path.Join(m.Supervisor[0].RootDir(), supervisor.HOOKS_DIR, prog.Name,
+			fmt.Sprintf("%s.%d", prog.Job, prog.TaskId), hookName+".sh")
+		if _, err := os.Stat(testHookFile); err != nil {
+			t.Errorf("hook %s should be executed, err: %v", hookName, err)
+		}
+	}
+
+	if err := m.SuperClient[0].Start(prog.Name, prog.Job, prog.TaskId); err != nil {
+		t.Fatalf("start process failed: %v", err)
+	}
+	if p, err := m.SuperClient[0].Show(prog.Name, prog.Job, prog.TaskId); err != nil {
+		t.Fatalf("show process failed: %v", err)
+	} else if p.Status != supervisor.StatusRunning {
+		t.Fatalf("process should be running after start, instead of %s", p.Status)
+	}
+	if err := m.SuperClient[0].Stop(prog.Name, prog.Job, prog.TaskId); err != nil {
+		t.Fatalf("stop process failed: %v", err)
+	}
+}
+
 func TestListTasks(t *testing.T) {
 	m := NewTestingMiniHuker(1)
 	m.Start()
@@ -251,3 +298,120 @@ func TestListTasks(t *testing.T) {
 		t.Fatalf("%v", err)
 	}
 }
+
+func TestProgramEnv(t *testing.T) {
+	m := NewTestingMiniHuker(1)
+
+	m.Start()
+	defer m.Stop()
+
+	prog := NewProgram()
+	prog.Bin = "sh"
+	prog.Args = []string{"-c", "echo $HUKER_TEST_ENV; pwd; exec sleep 60"}
+	prog.Env = map[string]string{"HUKER_TEST_ENV": "task-$TaskId"}
+	if err := m.SuperClient[0].Bootstrap(prog); err != nil {
+		t.Fatalf("bootstrap failed: %v", err)
+	}
+	defer m.SuperClient[0].Cleanup(prog.Name, prog.Job, prog.TaskId)
+	defer m.SuperClient[0].Stop(prog.Name, prog.Job, prog.TaskId)
+
+	rootDir := path.Join(m.Supervisor[0].RootDir(), prog.Name, fmt.Sprintf("%s.%d", prog.Job, prog.TaskId))
+	data, err := ioutil.ReadFile(path.Join(rootDir, supervisor.STDOUT_DIR, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The root dir of agent may be a symbolic link.
+	realRootDir, _ := filepath.EvalSymlinks(rootDir)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || lines[0] != "task-100" || (lines[1] != rootDir && lines[1] != realRootDir) {
+		t.Errorf("Env or working directory of process mismatch: %v", lines)
+	}
+}
+
+func TestReadinessProbes(t *testing.T) {
+	m := NewTestingMiniHuker(1)
+
+	m.Start()
+	defer m.Stop()
+
+	waitStatus := func(prog *supervisor.Program) *supervisor.Program {
+		for i := 0; i < 30; i++ {
+			p, err := m.SuperClient[0].Show(prog.Name, prog.Job, prog.TaskId)
+			if err != nil {
+				t.Fatalf("show process failed: %v", err)
+			}
+			if p.Status != supervisor.StatusStarting {
+				return p
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+		t.Fatalf("process is still starting")
+		return nil
+	}
+
+	prog := NewProgram()
+	prog.Args = []string{"-m", "SimpleHTTPServer", "8453"}
+	prog.Probes = []supervisor.Probe{
+		{Type: supervisor.ProbeTCP, Target: "127.0.0.1:8453", TimeoutSeconds: 10, IntervalSeconds: 1},
+		{Type: supervisor.ProbeHTTP, Target: "http://127.0.0.1:8453/", TimeoutSeconds: 10, IntervalSeconds: 1},
+		{Type: supervisor.ProbeCommand, Target: "test -d $AgentRootDir", TimeoutSeconds: 10, IntervalSeconds: 1},
+	}
+	if err := m.SuperClient[0].Bootstrap(prog); err != nil {
+		t.Fatalf("bootstrap failed: %v", err)
+	}
+	defer m.SuperClient[0].Cleanup(prog.Name, prog.Job, prog.TaskId)
+	if p := waitStatus(prog); p.Status != supervisor.StatusReady {
+		t.Errorf("process should be ready, instead of %s, %s", p.Status, p.ProbeError)
+	}
+	if err := m.SuperClient[0].Stop(prog.Name, prog.Job, prog.TaskId); err != nil {
+		t.Fatalf("stop process failed: %v", err)
+	}
+
+	prog = NewProgram()
+	prog.TaskId = 101
+	prog.Bin = "sh"
+	prog.Args = []string{"-c", "exec sleep 60"}
+	prog.Probes = []supervisor.Probe{{Type: supervisor.ProbeCommand, Target: "exit 1", TimeoutSeconds: 1}}
+	if err := m.SuperClient[0].Bootstrap(prog); err != nil {
+		t.Fatalf("bootstrap failed: %v", err)
+	}
+	defer m.SuperClient[0].Cleanup(prog.Name, prog.Job, prog.TaskId)
+	defer m.SuperClient[0].Stop(prog.Name, prog.Job, prog.TaskId)
+	if p := waitStatus(prog); p.Status != supervisor.StatusUnhealthy || p.ProbeError == "" {
+		t.Errorf("process should be unhealthy, instead of %s, %s", p.Status, p.ProbeError)
+	}
+}
+
+func TestShowDuringReadiness(t *testing.T) {
+	m := NewTestingMiniHuker(1)
+
+	m.Start()
+	defer m.Stop()
+
+	// The readiness is resolved in background while the program is shown, which should be safe under -race.
+	prog := NewProgram()
+	prog.TaskId = 102
+	prog.Bin = "sh"
+	prog.Args = []string{"-c", "exec sleep 60"}
+	prog.Probes = []supervisor.Probe{{Type: supervisor.ProbeCommand, Target: "test -f ready || ! touch ready",
+		TimeoutSeconds: 10, IntervalSeconds: 1}}
+	if err := m.SuperClient[0].Bootstrap(prog); err != nil {
+		t.Fatalf("bootstrap failed: %v", err)
+	}
+	defer m.SuperClient[0].Cleanup(prog.Name, prog.Job, prog.TaskId)
+	defer m.SuperClient[0].Stop(prog.Name, prog.Job, prog.TaskId)
+	for i := 0; i < 1000; i++ {
+		p, err := m.SuperClient[0].Show(prog.Name, prog.Job, prog.TaskId)
+		if err != nil {
+			t.Fatalf("show process failed: %v", err)
+		}
+		if p.Status != supervisor.StatusStarting {
+			if p.Status != supervisor.StatusReady {
+				t.Errorf("process should be ready, instead of %s, %s", p.Status, p.ProbeError)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("process is still starting")
+}
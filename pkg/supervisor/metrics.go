@@ -9,13 +9,12 @@ import (
 	"github.com/shirou/gopsutil/load"
 	"github.com/shirou/gopsutil/mem"
 	"github.com/shirou/gopsutil/net"
-	"strings"
 )
 
 func progsJVMMetrics(progs *programMap) map[string]interface{} {
 	pMetrics := make(map[string]interface{})
 	for pKey, prog := range progs.programs {
-		if strings.Contains(prog.Bin, "java") && utils.IsProcessOK(prog.PID) {
+		if prog.IsJava() && utils.IsProcessOK(prog.PID) {
 			javaHome, err := utils.FindJavaHome(prog.Bin)
 			if err != nil {
 				log.Warnf("Failed to find the JAVA_HOME for %s, error: %v", pKey, err)
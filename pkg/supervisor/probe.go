@@ -0,0 +1,146 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"github.com/openinx/huker/pkg/utils"
+	"net"
+	"net/http"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// Types of readiness probe.
+const (
+	ProbeTCP     = "tcp"
+	ProbeHTTP    = "http"
+	ProbeCommand = "command"
+)
+
+// Readiness probe of program, which is evaluated by the agent every interval after the process started, until it
+// succeeds or the timeout elapses.
+type Probe struct {
+	Type string `json:"type"`
+	// <host>:<port> for tcp, url for http, and the shell command executed in the job root dir for command.
+	Target string `json:"target"`
+	// Expected status code of http GET, default: 200
+	HTTPStatus      int `json:"http_status,omitempty"`
+	TimeoutSeconds  int `json:"timeout_seconds"`
+	IntervalSeconds int `json:"interval_seconds"`
+}
+
+// Interval between two attempts, default: 1s
+func (p *Probe) interval() time.Duration {
+	if p.IntervalSeconds <= 0 {
+		return time.Second
+	}
+	return time.Duration(p.IntervalSeconds) * time.Second
+}
+
+// Check the probe once, every attempt should finish within an interval or once ctx is done.
+func (p *Probe) check(ctx context.Context, prog *Program, env []string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.interval())
+	defer cancel()
+	switch p.Type {
+	case ProbeTCP:
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", p.Target)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case ProbeHTTP:
+		req, err := http.NewRequestWithContext(ctx, "GET", p.Target, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		expected := p.HTTPStatus
+		if expected == 0 {
+			expected = http.StatusOK
+		}
+		if resp.StatusCode != expected {
+			return fmt.Errorf("GET %s returns status %d, expected: %d", p.Target, resp.StatusCode, expected)
+		}
+		return nil
+	case ProbeCommand:
+		cmd := exec.CommandContext(ctx, "sh", "-c", p.Target)
+		cmd.Env, cmd.Dir = env, prog.RootDir
+		// Kill the whole process group of the command, or its children would keep the output open after ctx is done.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		cmd.Cancel = func() error {
+			return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("Command `%s` failed: %v, output: %s", p.Target, err, out)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unknown probe type `%s`", p.Type)
+	}
+}
+
+// Evaluate the probe until it succeeds or times out, give up once the process exits.
+func (p *Probe) wait(prog *Program) error {
+	return p.poll(context.Background(), prog, prog.ProcessEnv(), func() error {
+		if !utils.IsProcessOK(prog.PID) {
+			return fmt.Errorf("Process %d exited", prog.PID)
+		}
+		return nil
+	})
+}
+
+// Evaluate the probe outside the agent until it succeeds or times out, such as the completion probe of decommission.
+// The command runs in the root dir of prog with the same environment variables as its hooks, and the polling stops
+// once ctx is done.
+func (p *Probe) Poll(ctx context.Context, prog *Program) error {
+	return p.poll(ctx, prog, prog.hookEnv(), func() error {
+		return nil
+	})
+}
+
+// Evaluate the probe every interval, give up once abort returns an error or ctx is done.
+func (p *Probe) poll(ctx context.Context, prog *Program, env []string, abort func() error) error {
+	deadline := time.Now().Add(time.Duration(p.TimeoutSeconds) * time.Second)
+	for {
+		err := p.check(ctx, prog, env)
+		if err == nil {
+			return nil
+		}
+		if err := abort(); err != nil {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Probe %s `%s` failed after %ds: %v", p.Type, p.Target, p.TimeoutSeconds, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Probe %s `%s` aborted: %v, last error: %v", p.Type, p.Target, ctx.Err(), err)
+		case <-time.After(p.interval()):
+		}
+	}
+}
+
+// Evaluate all readiness probes of the program in order, and return the error of the first failed one.
+func (prog *Program) waitReady() error {
+	for i := range prog.Probes {
+		if err := prog.Probes[i].wait(prog); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// True if the process of program is supposed to be alive, whether it's ready or not.
+func IsRunningStatus(status string) bool {
+	return status == StatusRunning || status == StatusStarting || status == StatusReady || status == StatusUnhealthy
+}
+
+// True if the program is serving, the program without readiness probes is ready once it's running.
+func IsReadyStatus(status string) bool {
+	return status == StatusRunning || status == StatusReady
+}
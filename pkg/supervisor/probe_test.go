@@ -0,0 +1,31 @@
+package supervisor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbePollCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	prog := &Program{Name: "test", Job: "test", RootDir: dir}
+	probe := &Probe{Type: ProbeCommand, Target: "sleep 30; false", TimeoutSeconds: 120, IntervalSeconds: 60}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = probe.Poll(ctx, prog)
+	if err == nil || !strings.Contains(err.Error(), "aborted") {
+		t.Errorf("Probe should be aborted, err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Probe command should be killed once the context is done, elapsed: %v", elapsed)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -25,9 +26,16 @@ const (
 	STDOUT_DIR         = "stdout"
 	HOOKS_DIR          = ".hooks"
 	StatusRunning      = "Running"
+	StatusStarting     = "Starting"
+	StatusReady        = "Ready"
+	StatusUnhealthy    = "Unhealthy"
 	StatusStopped      = "Stopped"
 	StatusNotBootstrap = "NotBootstrap"
 	StatusUnknown      = "Unknown"
+	ProcessJava        = "java"
+	ProcessPython      = "python"
+	ProcessBinary      = "binary"
+	ProcessScript      = "script"
 )
 
 func progDirs() []string {
@@ -49,6 +57,19 @@ type Program struct {
 	Status     string            `json:"status"`
 	RootDir    string            `json:"root_dir"`
 	Hooks      map[string]string `json:"hooks"`
+	Env        map[string]string `json:"env"`
+	Type       string            `json:"type"`
+	Probes     []Probe           `json:"probes,omitempty"`
+	// Reason of the Unhealthy status.
+	ProbeError string `json:"probe_error,omitempty"`
+}
+
+// True if the program is a java process, the programs deployed without type are guessed by the main process.
+func (p *Program) IsJava() bool {
+	if p.Type == "" {
+		return strings.Contains(p.Bin, "java")
+	}
+	return p.Type == ProcessJava
 }
 
 // <agent-root-dir>/<cluster-name>/<job-name>.<task-id>
@@ -149,6 +170,16 @@ func (p *Program) RenderVars(agentRootDir string) {
 		p.Args[idx] = arg
 	}
 
+	for key, value := range p.Env {
+		value = strings.Replace(value, "$AgentRootDir", agentRootDir, -1)
+		p.Env[key] = strings.Replace(value, "$TaskId", strconv.Itoa(p.TaskId), -1)
+	}
+
+	for idx := range p.Probes {
+		target := strings.Replace(p.Probes[idx].Target, "$AgentRootDir", agentRootDir, -1)
+		p.Probes[idx].Target = strings.Replace(target, "$TaskId", strconv.Itoa(p.TaskId), -1)
+	}
+
 	p.Bin = strings.Replace(p.Bin, "$AgentRootDir", agentRootDir, -1)
 	p.Bin = strings.Replace(p.Bin, "$TaskId", strconv.Itoa(p.TaskId), -1)
 
@@ -185,8 +216,8 @@ func (p *Program) Install(agentRootDir string) error {
 	return p.DumpConfigFiles(agentRootDir)
 }
 
-// Start the process in daemon.
-// TODO pipe stdout & stderr into pkg_root_dir/stdout directories.
+// Start the process in daemon. The program with readiness probes is Starting until the supervisor evaluates them,
+// otherwise it's Running if the process is still alive after one second.
 func (p *Program) Start(s *Supervisor) error {
 	if utils.IsProcessOK(p.PID) {
 		return fmt.Errorf("Process %d is already running.", p.PID)
@@ -196,6 +227,7 @@ func (p *Program) Start(s *Supervisor) error {
 		return err
 	}
 	cmd := exec.Command(p.Bin, p.Args...)
+	cmd.Env, cmd.Dir = p.ProcessEnv(), p.getJobRootDir(s.rootDir)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setsid: true,
 		Pgid:   0,
@@ -203,18 +235,27 @@ func (p *Program) Start(s *Supervisor) error {
 	cmd.Stdout, cmd.Stderr = f, f
 
 	log.Debugf("Start to run command : [%s %s]", p.Bin, strings.Join(p.Args, " "))
+	if err := cmd.Start(); err != nil {
+		f.Close()
+		return fmt.Errorf("Start job failed: %v", err)
+	}
 	go func() {
 		defer f.Close()
-		if err := cmd.Run(); err != nil {
+		if err := cmd.Wait(); err != nil {
 			log.Errorf("Run job failed. [cmd: %s %s], err: %v", p.Bin, strings.Join(p.Args, " "), err)
 		}
 	}()
+	p.PID, p.ProbeError = cmd.Process.Pid, ""
+	if len(p.Probes) > 0 {
+		log.Infof("Start process %d, waiting for readiness. [%s %s]", p.PID, p.Bin, strings.Join(p.Args, " "))
+		p.Status = StatusStarting
+		return nil
+	}
 	time.Sleep(time.Second * 1)
 
-	if cmd.Process != nil && utils.IsProcessOK(cmd.Process.Pid) {
+	if utils.IsProcessOK(p.PID) {
 		log.Infof("Start process success. [%s %s]", p.Bin, strings.Join(p.Args, " "))
 		p.Status = StatusRunning
-		p.PID = cmd.Process.Pid
 		return nil
 	}
 	return fmt.Errorf("Start job failed.")
@@ -248,6 +289,20 @@ func (p *Program) Restart(s *Supervisor) error {
 	return p.Start(s)
 }
 
+// Environment variables of the process, which are the ones of agent overridden by the env of program.
+func (p *Program) ProcessEnv() []string {
+	env := os.Environ()
+	var keys []string
+	for key := range p.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, key+"="+p.Env[key])
+	}
+	return env
+}
+
 func (p *Program) hookEnv() []string {
 	var env []string
 	env = append(env, "SUPERVISOR_ROOT_DIR="+path.Dir(path.Dir(p.RootDir)))
@@ -257,7 +312,7 @@ func (p *Program) hookEnv() []string {
 	env = append(env, "PROGRAM_NAME="+p.Name)
 	env = append(env, "PROGRAM_JOB_NAME="+p.Job)
 	env = append(env, "PROGRAM_TASK_ID="+strconv.Itoa(p.TaskId))
-	env = append(env, os.Environ()...)
+	env = append(env, p.ProcessEnv()...)
 	return env
 }
 
@@ -26,6 +26,8 @@ func newProgramMap() *programMap {
 }
 
 func (p *programMap) get(cluster, job string, taskId int) (Program, bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
 	key := programHash(cluster, job, taskId)
 	prog, ok := p.programs[key]
 	return prog, ok
@@ -49,7 +51,10 @@ func (p *programMap) refreshAndDump(fileName string) error {
 
 	for key, prog := range p.programs {
 		if utils.IsProcessOK(prog.PID) {
-			prog.Status = StatusRunning
+			// Keep the readiness of program, which is updated by the probes.
+			if !IsRunningStatus(prog.Status) {
+				prog.Status = StatusRunning
+			}
 		} else {
 			prog.Status = StatusStopped
 		}
@@ -0,0 +1,41 @@
+package supervisor
+
+import (
+	"os"
+	"path"
+	"sync"
+	"testing"
+)
+
+func TestProgramMapConcurrency(t *testing.T) {
+	dbFile := path.Join(os.TempDir(), "huker-progs-test.db")
+	defer os.Remove(dbFile)
+
+	// The readiness is put in background while the program is shown, which should be safe under -race.
+	progs := newProgramMap()
+	prog := &Program{Name: "tst-py", Job: "http-server", TaskId: 0, Status: StatusStarting}
+	if err := progs.putAndDump(prog, dbFile); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			p := *prog
+			p.Status = StatusReady
+			if err := progs.putAndDump(&p, dbFile); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, ok := progs.get(prog.Name, prog.Job, prog.TaskId); !ok {
+			t.Errorf("Program should exist")
+		}
+	}
+	wg.Wait()
+	if p, _ := progs.get(prog.Name, prog.Job, prog.TaskId); p.Status != StatusReady {
+		t.Errorf("Status should be ready, instead of %s", p.Status)
+	}
+}
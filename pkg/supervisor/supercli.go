@@ -2,6 +2,7 @@ package supervisor
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +12,8 @@ import (
 
 type SupervisorCli struct {
 	ServerAddr string
+	// The requests in flight are aborted once ctx is done, nil means never.
+	ctx context.Context
 }
 
 func NewSupervisorCli(serverAddr string) *SupervisorCli {
@@ -19,10 +22,52 @@ func NewSupervisorCli(serverAddr string) *SupervisorCli {
 	}
 }
 
+// Copy of the client whose requests are aborted once ctx is done. The agent may still complete the operation of an
+// aborted request.
+func (s *SupervisorCli) WithContext(ctx context.Context) *SupervisorCli {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+func (s *SupervisorCli) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// Error of Show if the task is not bootstrapped on the agent.
+type TaskNotFoundError struct {
+	Name   string
+	Job    string
+	TaskId int
+}
+
+func (e *TaskNotFoundError) Error() string {
+	return fmt.Sprintf("Task %s.%s.%d not found", e.Name, e.Job, e.TaskId)
+}
+
+// Whether the task is not bootstrapped on the agent according to the error of Show.
+func IsTaskNotFound(err error) bool {
+	_, ok := err.(*TaskNotFoundError)
+	return ok
+}
+
+// Error of the response whose status code is 4xx or 5xx.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
 func handleResponse(resp *http.Response) ([]byte, error) {
 	if resp.StatusCode >= 400 {
 		data, _ := ioutil.ReadAll(resp.Body)
-		return []byte{}, fmt.Errorf("%s, %s", resp.Status, data)
+		return []byte{}, &statusError{code: resp.StatusCode, msg: fmt.Sprintf("%s, %s", resp.Status, data)}
 	}
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -38,8 +83,8 @@ func handleResponse(resp *http.Response) ([]byte, error) {
 	return data, fmt.Errorf("%s", string(data))
 }
 
-func request(method, url string, body io.Reader) ([]byte, error) {
-	req, err0 := http.NewRequest(method, url, body)
+func request(ctx context.Context, method, url string, body io.Reader) ([]byte, error) {
+	req, err0 := http.NewRequestWithContext(ctx, method, url, body)
 	if err0 != nil {
 		return []byte{}, err0
 	}
@@ -61,14 +106,17 @@ func (s *SupervisorCli) Bootstrap(p *Program) error {
 		return err
 	}
 	url := s.ServerAddr + "/api/programs"
-	_, err2 := request("POST", url, bytes.NewBuffer(data))
+	_, err2 := request(s.context(), "POST", url, bytes.NewBuffer(data))
 	return err2
 }
 
 func (s *SupervisorCli) Show(name, job string, taskId int) (*Program, error) {
 	url := fmt.Sprintf("%s/api/programs/%s/%s/%d", s.ServerAddr, name, job, taskId)
-	data, err := request("GET", url, nil)
+	data, err := request(s.context(), "GET", url, nil)
 	if err != nil {
+		if se, ok := err.(*statusError); ok && se.code == http.StatusNotFound {
+			return nil, &TaskNotFoundError{Name: name, Job: job, TaskId: taskId}
+		}
 		return nil, err
 	}
 	p := &Program{}
@@ -80,13 +128,13 @@ func (s *SupervisorCli) Show(name, job string, taskId int) (*Program, error) {
 
 func (s *SupervisorCli) Start(name, job string, taskId int) error {
 	url := fmt.Sprintf("%s/api/programs/%s/%s/%d/start", s.ServerAddr, name, job, taskId)
-	_, err := request("PUT", url, nil)
+	_, err := request(s.context(), "PUT", url, nil)
 	return err
 }
 
 func (s *SupervisorCli) Cleanup(name, job string, taskId int) error {
 	url := fmt.Sprintf("%s/api/programs/%s/%s/%d", s.ServerAddr, name, job, taskId)
-	_, err := request("DELETE", url, nil)
+	_, err := request(s.context(), "DELETE", url, nil)
 	return err
 }
 
@@ -96,25 +144,51 @@ func (s *SupervisorCli) RollingUpdate(p *Program) error {
 		return err
 	}
 	url := s.ServerAddr + "/api/programs/rolling_update"
-	_, err2 := request("POST", url, bytes.NewBuffer(data))
+	_, err2 := request(s.context(), "POST", url, bytes.NewBuffer(data))
+	return err2
+}
+
+// Install the package and config files of program without starting it.
+func (s *SupervisorCli) Install(p *Program) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	url := s.ServerAddr + "/api/programs/install"
+	_, err2 := request(s.context(), "POST", url, bytes.NewBuffer(data))
+	return err2
+}
+
+// Push the config files of program to the deployed one without restarting it.
+func (s *SupervisorCli) PushConfig(p *Program) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	url := s.ServerAddr + "/api/programs/push_config"
+	_, err2 := request(s.context(), "POST", url, bytes.NewBuffer(data))
 	return err2
 }
 
 func (s *SupervisorCli) Restart(name, job string, taskId int) error {
 	url := fmt.Sprintf("%s/api/programs/%s/%s/%d/restart", s.ServerAddr, name, job, taskId)
-	_, err := request("PUT", url, nil)
+	_, err := request(s.context(), "PUT", url, nil)
 	return err
 }
 
 func (s *SupervisorCli) Stop(name, job string, taskId int) error {
 	url := fmt.Sprintf("%s/api/programs/%s/%s/%d/stop", s.ServerAddr, name, job, taskId)
-	_, err := request("PUT", url, nil)
+	_, err := request(s.context(), "PUT", url, nil)
 	return err
 }
 
 func (s *SupervisorCli) ListTasks() ([]*Program, error) {
 	url := fmt.Sprintf("%s/api/programs", s.ServerAddr)
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(s.context(), "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
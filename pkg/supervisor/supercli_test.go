@@ -0,0 +1,69 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestSupervisorCliWithContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"message":"` + MESSAGE_SUCCESS + `"}`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	supCli := NewSupervisorCli(server.URL).WithContext(ctx)
+	start := time.Now()
+	if err := supCli.Stop("test-cluster", "job", 0); err == nil {
+		t.Errorf("Request should be aborted once the context is done")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Request should be aborted in time, instead of %v", elapsed)
+	}
+	if supCli.ServerAddr != server.URL || NewSupervisorCli(server.URL).context() != context.Background() {
+		t.Errorf("Client without context should never abort the requests")
+	}
+}
+
+func TestSupervisorCliShowNotFound(t *testing.T) {
+	dbFile := path.Join(os.TempDir(), "huker-supercli-test.db")
+	defer os.Remove(dbFile)
+	s := &Supervisor{programs: newProgramMap()}
+	if err := s.programs.putAndDump(&Program{Name: "test-cluster", Job: "job", TaskId: 0}, dbFile); err != nil {
+		t.Fatal(err)
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/api/programs/{name}/{job}/{taskId}", s.hShowProgram).Methods("GET")
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	supCli := NewSupervisorCli(server.URL)
+	if _, err := supCli.Show("test-cluster", "job", 0); err != nil {
+		t.Errorf("Task 0 should be found: %v", err)
+	}
+	_, err := supCli.Show("test-cluster", "job", 1)
+	if !IsTaskNotFound(err) || err.Error() != "Task test-cluster.job.1 not found" {
+		t.Errorf("Task 1 should not be found, instead of %v", err)
+	}
+
+	// Other errors mentioning "not found" are not taken as the task not found.
+	failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "supervisor db not found")
+	}))
+	defer failed.Close()
+	if _, err := NewSupervisorCli(failed.URL).Show("test-cluster", "job", 0); err == nil || IsTaskNotFound(err) {
+		t.Errorf("Internal error should not be taken as the task not found: %v", err)
+	}
+}
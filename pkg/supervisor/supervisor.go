@@ -108,11 +108,34 @@ func (s *Supervisor) hBootstrapProgram(w http.ResponseWriter, r *http.Request) {
 		}
 		// Step.3 Execute post bootstrap hook
 		return p.ExecHooks("post_bootstrap")
-	})
+	}, true)
+}
+
+// Install the package and config files of program, and register it as stopped without starting it.
+func (s *Supervisor) hInstallProgram(w http.ResponseWriter, r *http.Request) {
+	s.taskMux.Lock()
+	defer s.taskMux.Unlock()
+	s.updateProgram(w, r, func(p *Program) error {
+		// Step.0 check the existence of program.
+		if _, ok := s.programs.get(p.Name, p.Job, p.TaskId); ok {
+			return fmt.Errorf("Job %s.%s.%d already exists.", p.Name, p.Job, p.TaskId)
+		}
+		// Step.1 Execute prev install hook
+		if err := p.ExecHooks("pre_install"); err != nil {
+			return err
+		}
+		// Step.2 Install package under root directory of agent.
+		if err := p.Install(s.rootDir); err != nil {
+			return err
+		}
+		// Step.3 Execute post install hook
+		return p.ExecHooks("post_install")
+	}, false)
 }
 
-// Abstract method for bootstrap/rolling_update.
-func (s *Supervisor) updateProgram(w http.ResponseWriter, r *http.Request, handleFunc func(*Program) error) {
+// Abstract method for bootstrap/install/rolling_update, the program will be started in the final if start is true.
+func (s *Supervisor) updateProgram(w http.ResponseWriter, r *http.Request, handleFunc func(*Program) error,
+	start bool) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		w.Write(renderResp(err))
@@ -138,9 +161,18 @@ func (s *Supervisor) updateProgram(w http.ResponseWriter, r *http.Request, handl
 		}
 	}()
 
-	// Start the job in the final.
 	prog.Status = StatusStopped
-	w.Write(renderResp(prog.Start(s)))
+	if !start {
+		w.Write(renderResp(nil))
+		return
+	}
+
+	// Start the job in the final.
+	err = prog.Start(s)
+	if err == nil && prog.Status == StatusStarting {
+		s.watchReadiness(*prog)
+	}
+	w.Write(renderResp(err))
 }
 
 // Abstract method for start/cleanup/restart/stop.
@@ -154,6 +186,9 @@ func (s *Supervisor) handleProgram(w http.ResponseWriter, r *http.Request, handl
 			w.Write(renderResp(err))
 			return
 		}
+		if prog.Status == StatusStarting {
+			s.watchReadiness(prog)
+		}
 
 		// Keep the latest version of program saved in supervisor.
 		w.Write(renderResp(s.programs.putAndDump(&prog, s.dbFile)))
@@ -162,6 +197,30 @@ func (s *Supervisor) handleProgram(w http.ResponseWriter, r *http.Request, handl
 	}
 }
 
+// Evaluate the readiness probes of the started program in background, and update its status to Ready or Unhealthy.
+// The caller should hold the taskMux, so the status is updated after the program is saved. The result is discarded
+// if the program is stopped or restarted meanwhile.
+func (s *Supervisor) watchReadiness(prog Program) {
+	go func() {
+		err := prog.waitReady()
+		s.taskMux.Lock()
+		defer s.taskMux.Unlock()
+		cur, ok := s.programs.get(prog.Name, prog.Job, prog.TaskId)
+		if !ok || cur.PID != prog.PID || cur.Status != StatusStarting {
+			return
+		}
+		if err != nil {
+			log.Warnf("Job %s.%s.%d is unhealthy: %v", prog.Name, prog.Job, prog.TaskId, err)
+			cur.Status, cur.ProbeError = StatusUnhealthy, err.Error()
+		} else {
+			cur.Status = StatusReady
+		}
+		if err := s.programs.putAndDump(&cur, s.dbFile); err != nil {
+			log.Errorf("Failed to dump supervisor db files: %v", err)
+		}
+	}()
+}
+
 func (s *Supervisor) hShowProgram(w http.ResponseWriter, r *http.Request) {
 	name := mux.Vars(r)["name"]
 	job := mux.Vars(r)["job"]
@@ -174,6 +233,7 @@ func (s *Supervisor) hShowProgram(w http.ResponseWriter, r *http.Request) {
 			io.Copy(w, bytes.NewBuffer(data))
 		}
 	} else {
+		w.WriteHeader(http.StatusNotFound)
 		w.Write(renderResp(fmt.Errorf("name: %s, job: %s, taskId: %d not found.", name, job, taskId)))
 	}
 }
@@ -203,7 +263,7 @@ func (s *Supervisor) hCleanupProgram(w http.ResponseWriter, r *http.Request) {
 	// step.0 check and clear cache.
 	prog, progFound := s.programs.get(name, job, taskId)
 	if progFound {
-		if prog.Status == StatusRunning {
+		if IsRunningStatus(prog.Status) {
 			w.Write(renderResp(fmt.Errorf("Job %s.%s.%d is still running, stop it first please.",
 				prog.Name, prog.Job, prog.TaskId)))
 			return
@@ -278,7 +338,47 @@ func (s *Supervisor) hRollingUpdateProgram(w http.ResponseWriter, r *http.Reques
 		}
 		// Step.4 Execute post hook
 		return p.ExecHooks("post_rolling_update")
-	})
+	}, true)
+}
+
+// Replace the config files of the deployed program and dump them without restarting the process, which is supposed
+// to reload them by itself or by the post_push_config hook.
+func (s *Supervisor) hPushConfigProgram(w http.ResponseWriter, r *http.Request) {
+	s.taskMux.Lock()
+	defer s.taskMux.Unlock()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.Write(renderResp(err))
+		return
+	}
+	p := &Program{}
+	if err := json.Unmarshal(body, p); err != nil {
+		w.Write(renderResp(err))
+		return
+	}
+	p.RenderVars(s.rootDir)
+	prog, ok := s.programs.get(p.Name, p.Job, p.TaskId)
+	if !ok {
+		w.Write(renderResp(fmt.Errorf("Bootstrap %s.%s.%d first please.", p.Name, p.Job, p.TaskId)))
+		return
+	}
+	prog.Configs = p.Configs
+	// Step.1 Execute prev hook
+	if err := prog.ExecHooks("pre_push_config"); err != nil {
+		w.Write(renderResp(err))
+		return
+	}
+	// Step.2 Dump config files.
+	if err := prog.DumpConfigFiles(s.rootDir); err != nil {
+		w.Write(renderResp(err))
+		return
+	}
+	if err := s.programs.putAndDump(&prog, s.dbFile); err != nil {
+		w.Write(renderResp(err))
+		return
+	}
+	// Step.3 Execute post hook
+	w.Write(renderResp(prog.ExecHooks("post_push_config")))
 }
 
 func (s *Supervisor) hRestartProgram(w http.ResponseWriter, r *http.Request) {
@@ -337,6 +437,13 @@ func (s *Supervisor) loadSupervisorDB() error {
 		return fmt.Errorf("Unmarshal %s error: %v", s.dbFile, err)
 	}
 
+	// step.2 Continue to evaluate the readiness of programs which are starting when the agent stopped.
+	for _, prog := range s.programs.programs {
+		if prog.Status == StatusStarting {
+			s.watchReadiness(prog)
+		}
+	}
+
 	return nil
 }
 
@@ -389,7 +496,9 @@ func (s *Supervisor) Start() error {
 	r.HandleFunc("/api/programs", s.hBootstrapProgram).Methods("POST")
 	r.HandleFunc("/api/programs/{name}/{job}/{taskId}", s.hShowProgram).Methods("GET")
 	r.HandleFunc("/api/programs/{name}/{job}/{taskId}/start", s.hStartProgram).Methods("PUT")
+	r.HandleFunc("/api/programs/install", s.hInstallProgram).Methods("POST")
 	r.HandleFunc("/api/programs/rolling_update", s.hRollingUpdateProgram).Methods("POST")
+	r.HandleFunc("/api/programs/push_config", s.hPushConfigProgram).Methods("POST")
 	r.HandleFunc("/api/programs/{name}/{job}/{taskId}/restart", s.hRestartProgram).Methods("PUT")
 	r.HandleFunc("/api/programs/{name}/{job}/{taskId}", s.hCleanupProgram).Methods("DELETE")
 	r.HandleFunc("/api/programs/{name}/{job}/{taskId}/stop", s.hStopProgram).Methods("PUT")
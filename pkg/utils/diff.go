@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+type diffLine struct {
+	op   byte
+	text string
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// Compute the edit script from a to b by the longest common subsequence of lines.
+func diffLines(a, b []string) []diffLine {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			lines = append(lines, diffLine{' ', a[i]})
+			i, j = i+1, j+1
+		} else if j >= len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]) {
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		} else {
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+// The start line of hunk range in unified format, which is the line before the hunk if it is empty.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// Generate the unified diff from text a to text b with the given lines of context, such as diff -u. Return empty
+// string if the two texts are equal.
+func UnifiedDiff(fromName, toName, a, b string, context int) string {
+	lines := diffLines(splitLines(a), splitLines(b))
+
+	var changes []int
+	for idx, line := range lines {
+		if line.op != ' ' {
+			changes = append(changes, idx)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var buf []string
+	buf = append(buf, "--- "+fromName, "+++ "+toName)
+	// Line offsets of a and b before lines[idx].
+	offsetA, offsetB := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	for idx, line := range lines {
+		offsetA[idx+1], offsetB[idx+1] = offsetA[idx], offsetB[idx]
+		if line.op != '+' {
+			offsetA[idx+1]++
+		}
+		if line.op != '-' {
+			offsetB[idx+1]++
+		}
+	}
+	for k := 0; k < len(changes); {
+		start := changes[k] - context
+		if start < 0 {
+			start = 0
+		}
+		// Merge the changes whose context overlaps into one hunk.
+		end := changes[k]
+		for k < len(changes) && changes[k]-end <= 2*context+1 {
+			end = changes[k]
+			k++
+		}
+		end += context + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+		buf = append(buf, fmt.Sprintf("@@ -%s +%s @@",
+			hunkRange(offsetA[start], offsetA[end]-offsetA[start]),
+			hunkRange(offsetB[start], offsetB[end]-offsetB[start])))
+		for _, line := range lines[start:end] {
+			buf = append(buf, string(line.op)+line.text)
+		}
+	}
+	return strings.Join(buf, "\n") + "\n"
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	testCases := []struct {
+		a        string
+		b        string
+		expected string
+	}{
+		{"a\nb\nc", "a\nb\nc", ""},
+		{"", "", ""},
+		{"a\nb\nc", "a\nB\nc", "--- x\n+++ y\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"", "a\nb", "--- x\n+++ y\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"a\nb", "", "--- x\n+++ y\n@@ -1,2 +0,0 @@\n-a\n-b\n"},
+		{"1\n2\n3\n4\n5\n6\n7\n8\n9", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10",
+			"--- x\n+++ y\n@@ -8,2 +8,3 @@\n 8\n 9\n+10\n"},
+		// Two changes far away from each other are split into two hunks.
+		{"0\n1\n2\n3\n4\n5\n6\n7\n8\n9", "A\n1\n2\n3\n4\n5\n6\n7\n8\nB",
+			"--- x\n+++ y\n@@ -1,3 +1,3 @@\n-0\n+A\n 1\n 2\n@@ -8,3 +8,3 @@\n 7\n 8\n-9\n+B\n"},
+		// Two changes close to each other are merged into one hunk.
+		{"0\n1\n2\n3\n4\n5", "A\n1\n2\n3\n4\nB",
+			"--- x\n+++ y\n@@ -1,6 +1,6 @@\n-0\n+A\n 1\n 2\n 3\n 4\n-5\n+B\n"},
+	}
+	for idx, tc := range testCases {
+		if diff := UnifiedDiff("x", "y", tc.a, tc.b, 2); diff != tc.expected {
+			t.Errorf("Case#%d: diff mismatch, [%s] != [%s]", idx, diff, tc.expected)
+		}
+	}
+}
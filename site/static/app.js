@@ -33,135 +33,170 @@ function errorHTML(status, errMsg) {
     return "<span class=\"label label-danger\">" + status + "</span><a href=\"javascript:void(0)\" data-toggle=\"tooltip\" data-placement=\"right\" title=\'" + errMsg + "\'><img src=\"/static/help-icon.jpeg\" width=\"20px\" height=\"20px\"></a>"
 }
 
-function bootstrap(column, project, cluster, job, taskId) {
-    console.log("bootstrap ... ")
-    $.ajax({
-        url: "/api/bootstrap/" + project + "/" + cluster + "/" + job + "/" + taskId,
-        beforeSend: function () {
-            column.html("<span class=\"label label-warning\">Bootstrapping</span>")
-        },
-        success: function (data) {
-            column.html("<span class=\"label label-success\">Running</span>");
-        },
-        error: function (xhr, status, error) {
-            column.html(errorHTML("Bootstrap fail", xhr.responseText));
-        }
-    });
-}
+var runningLabels = {
+    "bootstrap": "Bootstrapping",
+    "install": "Installing",
+    "start": "Starting",
+    "stop": "Stopping",
+    "restart": "Restarting",
+    "rolling_update": "RollingUpdating",
+    "cleanup": "Cleanuping",
+    "decommission": "Decommissioning",
+    "recommission": "Recommissioning",
+    "bootstrap-cluster": "Bootstrapping",
+    "start-cluster": "Starting",
+    "stop-cluster": "Stopping"
+};
 
-function start(column, project, cluster, job, taskId) {
-    console.log("start ... ")
-    $.ajax({
-        url: "/api/start/" + project + "/" + cluster + "/" + job + "/" + taskId,
-        beforeSend: function () {
-            column.html("<span class=\"label label-warning\">Starting</span>")
-        },
-        success: function (data) {
-            column.html("<span class=\"label label-success\">Running</span>");
-        },
-        error: function (xhr, status, error) {
-            column.html(errorHTML("Start fail", xhr.responseText));
-        }
-    });
+function statusHTML(status) {
+    var label = "label-warning";
+    if (status == "Running" || status == "Ready") {
+        label = "label-success";
+    } else if (status == "Stopped" || status == "Unhealthy") {
+        label = "label-danger";
+    } else if (status == "NotBootstrap") {
+        label = "label-default";
+    }
+    return "<span class=\"label " + label + "\">" + status + "</span>"
 }
 
-function stop(column, project, cluster, job, taskId) {
-    console.log("stop ... ")
-    $.ajax({
-        url: "/api/stop/" + project + "/" + cluster + "/" + job + "/" + taskId,
-        beforeSend: function () {
-            column.html("<span class=\"label label-warning\">Stopping</span>")
-        },
-        success: function (data) {
-            column.html("<span class=\"label label-danger\">Stopped</span>");
-        },
-        error: function (xhr, status, error) {
-            column.html(errorHTML("Stop fail", xhr.responseText));
+// Render the progress of operation into the status columns, which are keyed by <job>/<taskId>.
+function renderOperation(op, columns) {
+    for (var i = 0, n = op.tasks.length; i < n; i++) {
+        var task = op.tasks[i];
+        var column = columns[task.job + "/" + task.task_id];
+        if (column == undefined) {
+            continue;
         }
-    });
-}
-
-function restart(column, project, cluster, job, taskId) {
-    console.log("restart ... ")
-    $.ajax({
-        url: "/api/restart/" + project + "/" + cluster + "/" + job + "/" + taskId,
-        beforeSend: function () {
-            column.html("<span class=\"label label-warning\">Restarting</span>")
-        },
-        success: function (data) {
-            column.html("<span class=\"label label-success\">Running</span>");
-        },
-        error: function (xhr, status, error) {
-            column.html(errorHTML("Restart fail", xhr.responseText));
+        if (task.state == "pending") {
+            column.html("<span class=\"label label-warning\">" + runningLabels[op.action] + "</span> " + statusHTML(task.status));
+        } else if (task.state == "succeed") {
+            column.html(statusHTML(task.status));
+        } else if (task.state == "failed") {
+            column.html(errorHTML(op.action + " fail", task.error));
+        } else {
+            column.html(errorHTML("Skipped", op.error));
         }
-    });
+    }
 }
 
-function rolling_update(column, project, cluster, job, taskId) {
-    console.log("rolling_update ... ");
-    $.ajax({
-        url: "/api/rolling_update/" + project + "/" + cluster + "/" + job + "/" + taskId,
-        beforeSend: function () {
-            column.html("<span class=\"label label-warning\">RollingUpdating</span>")
-        },
-        success: function (data) {
-            column.html("<span class=\"label label-success\">Running</span>");
-        },
-        error: function (xhr, status, error) {
-            column.html(errorHTML("RollingUpdate fail", xhr.responseText));
+// Poll the operation until it finished, then call done.
+function pollOperation(op, columns, done) {
+    renderOperation(op, columns);
+    if (op.state != "running") {
+        if (op.state == "failed" && op.error) {
+            console.log(op.action + " " + op.id + " failed: " + op.error);
         }
-    });
+        done();
+        return;
+    }
+    setTimeout(function () {
+        $.ajax({
+            url: "/api/operations/" + op.id,
+            dataType: "json",
+            success: function (data) {
+                pollOperation(data, columns, done);
+            },
+            error: function (xhr, status, error) {
+                alert("Failed to get operation " + op.id + ": " + xhr.responseText);
+                done();
+            }
+        });
+    }, 1000);
 }
 
-function cleanup(column, project, cluster, job, taskId) {
-    console.log("cleanup ... ");
+// Submit the operation of a task, all tasks of a job if taskId is undefined, or the whole cluster if job is undefined.
+function operate(action, columns, project, cluster, job, taskId, done) {
+    var url = "/api/" + action + "/" + project + "/" + cluster;
+    if (job != undefined) {
+        url += "/" + job;
+    }
+    if (taskId != undefined) {
+        url += "/" + taskId;
+    }
     $.ajax({
-        url: "/api/cleanup/" + project + "/" + cluster + "/" + job + "/" + taskId,
-        beforeSend: function () {
-            column.html("<span class=\"label label-warning\">Cleanuping</span>")
-        },
+        url: url,
+        type: "POST",
+        dataType: "json",
         success: function (data) {
-            column.html("<span class=\"label label-default\">NotBootstrap</span>");
+            pollOperation(data, columns, done);
         },
         error: function (xhr, status, error) {
-            column.html(errorHTML("Cleanup fail", xhr.responseText));
+            for (var key in columns) {
+                columns[key].html(errorHTML(action + " fail", xhr.responseText));
+            }
+            done();
         }
     });
 }
 
+// Operate the selected tasks. The job is operated as a whole if all its tasks are selected, otherwise its tasks are
+// operated one by one, since the operations of the same job are exclusive.
 function doAction(action) {
     var selected = listAllSelectedCheckBoxes();
     if (selected.length <= 0) {
         alert("Please select at least one task.")
+        return;
     }
+    var project = $("#hiddenProject").val();
+    var cluster = $("#hiddenCluster").val();
+    var jobs = {};
     for (var i = 0, n = selected.length; i < n; i++) {
         var cb = selected[i];
-        var taskId = $(cb).parent().parent().find('td:eq(1)').text();
-        var project = $("#hiddenProject").val();
-        var cluster = $("#hiddenCluster").val();
         var job = $(cb).val();
-        var statusColumn = $(cb).parent().parent().find('td:eq(4)');
-        if (action == "bootstrap") {
-            bootstrap(statusColumn, project, cluster, job, taskId);
-        } else if (action == "start") {
-            start(statusColumn, project, cluster, job, taskId);
-        } else if (action == "stop") {
-            stop(statusColumn, project, cluster, job, taskId)
-        } else if (action == "restart") {
-            restart(statusColumn, project, cluster, job, taskId)
-        } else if (action == "rolling_update") {
-            rolling_update(statusColumn, project, cluster, job, taskId)
-        } else if (action == "cleanup") {
-            cleanup(statusColumn, project, cluster, job, taskId)
+        if (jobs[job] == undefined) {
+            jobs[job] = [];
+        }
+        jobs[job].push({
+            taskId: $(cb).parent().parent().find('td:eq(1)').text(),
+            column: $(cb).parent().parent().find('td:eq(4)')
+        });
+    }
+    for (var job in jobs) {
+        var tasks = jobs[job];
+        if (tasks.length == document.getElementsByName(job + "Checkbox").length) {
+            var columns = {};
+            for (var i = 0, n = tasks.length; i < n; i++) {
+                columns[job + "/" + tasks[i].taskId] = tasks[i].column;
+            }
+            operate(action, columns, project, cluster, job, undefined, function () {
+            });
         } else {
-            alert("Unknown button: " + action);
+            (function next(job, tasks) {
+                if (tasks.length == 0) {
+                    return;
+                }
+                var columns = {};
+                columns[job + "/" + tasks[0].taskId] = tasks[0].column;
+                operate(action, columns, project, cluster, job, tasks[0].taskId, function () {
+                    next(job, tasks.slice(1));
+                });
+            })(job, tasks);
         }
     }
 }
 
+// Operate the whole cluster with its dependencies.
+function doClusterAction(action) {
+    var project = $("#hiddenProject").val();
+    var cluster = $("#hiddenCluster").val();
+    var columns = {};
+    $("[type=checkbox]").each(function () {
+        if (this.name != "selectAll") {
+            var taskId = $(this).parent().parent().find('td:eq(1)').text();
+            columns[$(this).val() + "/" + taskId] = $(this).parent().parent().find('td:eq(4)');
+        }
+    });
+    operate(action, columns, project, cluster, undefined, undefined, function () {
+    });
+}
+
 $(document).on("click", "#bootstrapBtn", function () {
     doAction("bootstrap")
 });
+$(document).on("click", "#installBtn", function () {
+    doAction("install")
+});
 $(document).on("click", "#startBtn", function () {
     doAction("start")
 });
@@ -177,6 +212,12 @@ $(document).on("click", "#rollingUpdateBtn", function () {
 $(document).on("click", "#cleanupBtn", function () {
     doAction("cleanup")
 });
+$(document).on("click", "#decommissionBtn", function () {
+    doAction("decommission")
+});
+$(document).on("click", "#recommissionBtn", function () {
+    doAction("recommission")
+});
 
 
 function deployHukerAgent(sshUser, sshPrivateKey, sshPassword, hukerAgentRootDir, host) {
@@ -226,3 +267,12 @@ $(document).on("click", "#deployHukerAgent", function () {
 $(document).ready(function () {
     $('[data-toggle="tooltip"]').tooltip();
 });
+$(document).on("click", "#bootstrapClusterBtn", function () {
+    doClusterAction("bootstrap-cluster")
+});
+$(document).on("click", "#startClusterBtn", function () {
+    doClusterAction("start-cluster")
+});
+$(document).on("click", "#stopClusterBtn", function () {
+    doClusterAction("stop-cluster")
+});